@@ -79,7 +79,7 @@ func (a Array) toNative(seen map[interface{}]interface{}, ops int) interface{} {
 }
 
 func (a Array) Equals(other Value) bool {
-	o, ok := other.(Array)
+	o, ok := materialize(underlying(other)).(Array)
 	if !ok {
 		return false
 	}
@@ -92,6 +92,9 @@ func (a Array) Get(r *Runtime, key Value) Value {
 	i := key.ToNumber()
 	if i.IsInt() {
 		ii := i.ToInt()
+		if ii < 0 {
+			ii += int64(len(a.values))
+		}
 		if ii < 0 || ii >= int64(len(a.values)) {
 			return Null
 		}
@@ -117,6 +120,18 @@ func (a Array) Set(r *Runtime, key, value Value) {
 	a.values[i] = value
 }
 
+func (a Array) Length(r *Runtime) int { return len(a.values) }
+
+func (a Array) Slice(r *Runtime, low, high Value) Value {
+	l, h, ok := sliceBounds(low, high, len(a.values))
+	if !ok {
+		return Null
+	}
+	values := make([]Value, h-l)
+	copy(values, a.values[l:h])
+	return NewArray(values)
+}
+
 func (a Array) Iterator() Iterator {
 	return &arrayIter{i: 0, a: &a}
 }
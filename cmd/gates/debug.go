@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lujjjh/gates"
+	"github.com/lujjjh/gates/syntax"
+)
+
+// lineList is a flag that may be repeated to collect line numbers.
+type lineList []int
+
+func (l *lineList) String() string { return fmt.Sprint(*l) }
+
+func (l *lineList) Set(s string) error {
+	line, err := strconv.Atoi(s)
+	if err != nil || line <= 0 {
+		return fmt.Errorf("invalid line %q", s)
+	}
+	*l = append(*l, line)
+	return nil
+}
+
+// runDebug implements "gates debug [-b line ...] file". The script runs
+// under a debugger that reads commands from the standard input, and stops
+// at the first statement unless breakpoints are given.
+func runDebug(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	var breakpoints lineList
+	fs.Var(&breakpoints, "b", "set a breakpoint at `line` (may be repeated)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gates debug [flags] file")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	filename := fs.Arg(0)
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	program, err := gates.CompileScript(filename, string(src))
+	if err != nil {
+		syntax.PrintError(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &debugSession{
+		filename: filename,
+		lines:    strings.Split(string(src), "\n"),
+		in:       bufio.NewScanner(os.Stdin),
+		out:      os.Stdout,
+		cancel:   cancel,
+	}
+	s.d = gates.NewDebugger(s.stop)
+	for _, line := range breakpoints {
+		s.d.SetBreakpoint(filename, line)
+	}
+	if len(breakpoints) == 0 {
+		s.d.Pause()
+	}
+
+	r := gates.New()
+	r.SetDebugHook(s.d)
+	v, err := r.RunProgram(ctx, program)
+	if s.quit {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(v.ToString())
+	return 0
+}
+
+type debugSession struct {
+	d        *gates.Debugger
+	filename string
+	lines    []string
+	in       *bufio.Scanner
+	out      io.Writer
+
+	// cancel aborts the program when the user quits.
+	cancel func()
+	quit   bool
+
+	// current is the line where the program stopped, and last the last
+	// command, which an empty line repeats.
+	current int
+	last    string
+}
+
+const debugHelp = `commands:
+  continue, c      run until a breakpoint
+  step, s          step to the next statement, into calls
+  next, n          step to the next statement, over calls
+  out, o           step out of the current function
+  break, b [line]  set a breakpoint, or list the breakpoints
+  clear [line]     remove a breakpoint, or all of them
+  print, p name    print a variable, e.g. p user.roles.0
+  locals           print the local variables
+  globals          print the global variables used by the script
+  stack, bt        print the call stack
+  list, l          list the source around the current line
+  quit, q          abort the script
+An empty line repeats the last command.`
+
+// stop reports where the program stopped and runs commands until one of
+// them resumes it.
+func (s *debugSession) stop(reason gates.StopReason, f *gates.Frame) gates.StepMode {
+	pos := f.Position()
+	s.current = pos.Line
+	fmt.Fprintf(s.out, "%s: %s in %s\n", reason, pos, f.Name())
+	s.printLines(pos.Line, pos.Line)
+
+	for {
+		fmt.Fprint(s.out, "(gates) ")
+		if !s.in.Scan() {
+			// the input is closed
+			fmt.Fprintln(s.out)
+			return s.abort()
+		}
+		line := strings.TrimSpace(s.in.Text())
+		if line == "" {
+			line = s.last
+		}
+		s.last = line
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		switch cmd {
+		case "continue", "c":
+			return gates.Continue
+		case "step", "s":
+			return gates.StepIn
+		case "next", "n":
+			return gates.StepOver
+		case "out", "o":
+			return gates.StepOut
+		case "quit", "q":
+			return s.abort()
+		case "break", "b":
+			if len(args) == 0 {
+				for _, line := range s.d.Breakpoints(s.filename) {
+					s.printLines(line, line)
+				}
+				continue
+			}
+			if line, ok := s.parseLine(args[0]); ok {
+				s.d.SetBreakpoint(s.filename, line)
+			}
+		case "clear":
+			if len(args) == 0 {
+				s.d.ClearBreakpoints(s.filename)
+				continue
+			}
+			if line, ok := s.parseLine(args[0]); ok {
+				s.d.ClearBreakpoint(s.filename, line)
+			}
+		case "print", "p":
+			if len(args) != 1 {
+				fmt.Fprintln(s.out, "usage: print name")
+				continue
+			}
+			if v, ok := f.Lookup(args[0]); ok {
+				fmt.Fprintln(s.out, gates.Inspect(v))
+			} else {
+				fmt.Fprintf(s.out, "%s is not defined\n", args[0])
+			}
+		case "locals":
+			s.printVariables(f.Locals())
+		case "globals":
+			s.printVariables(f.Globals())
+		case "stack", "bt":
+			for caller, i := f, 0; caller != nil; caller, i = caller.Caller(), i+1 {
+				fmt.Fprintf(s.out, "#%d %s at %s\n", i, caller.Name(), caller.Position())
+			}
+		case "list", "l":
+			s.printLines(pos.Line-5, pos.Line+5)
+		case "help", "h":
+			fmt.Fprintln(s.out, debugHelp)
+		default:
+			fmt.Fprintf(s.out, "unknown command %q, try help\n", cmd)
+		}
+	}
+}
+
+// abort makes the program fail at the next instruction.
+func (s *debugSession) abort() gates.StepMode {
+	s.quit = true
+	s.cancel()
+	return gates.Continue
+}
+
+func (s *debugSession) parseLine(arg string) (int, bool) {
+	line, err := strconv.Atoi(arg)
+	if err != nil || line <= 0 || line > len(s.lines) {
+		fmt.Fprintf(s.out, "invalid line %q\n", arg)
+		return 0, false
+	}
+	return line, true
+}
+
+// printLines prints the source lines from first to last, marking the
+// current line and the breakpoints.
+func (s *debugSession) printLines(first, last int) {
+	breakpoints := make(map[int]bool)
+	for _, line := range s.d.Breakpoints(s.filename) {
+		breakpoints[line] = true
+	}
+	for line := first; line <= last; line++ {
+		if line < 1 || line > len(s.lines) {
+			continue
+		}
+		mark := "  "
+		switch {
+		case line == s.current:
+			mark = "=>"
+		case breakpoints[line]:
+			mark = " *"
+		}
+		fmt.Fprintf(s.out, "%s %4d  %s\n", mark, line, s.lines[line-1])
+	}
+}
+
+func (s *debugSession) printVariables(vars []gates.Variable) {
+	for _, v := range vars {
+		fmt.Fprintf(s.out, "%s = %s\n", v.Name, gates.Inspect(v.Value))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/lujjjh/gates/syntax"
+	"github.com/lujjjh/gates/syntax/printer"
+)
+
+// runFmt implements "gates fmt [-w] [-d] [path ...]". Without paths the
+// standard input is formatted to the standard output.
+func runFmt(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write result to (source) file instead of stdout")
+	diff := fs.Bool("d", false, "display diffs instead of rewriting files")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gates fmt [flags] [path ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "gates fmt: cannot use -w with standard input")
+			return 2
+		}
+		if err := formatFile("<stdin>", os.Stdin, false, *diff); err != nil {
+			syntax.PrintError(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		err := filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (filename != path && filepath.Ext(filename) != ".gates") {
+				return nil
+			}
+			f, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := formatFile(filename, f, *write, *diff); err != nil {
+				// continue with the other files
+				syntax.PrintError(os.Stderr, err)
+				status = 1
+			}
+			return nil
+		})
+		if err != nil {
+			syntax.PrintError(os.Stderr, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func formatFile(filename string, in *os.File, write, showDiff bool) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	res, err := printer.Source(filename, src)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(src, res) {
+		if !write && !showDiff {
+			os.Stdout.Write(res)
+		}
+		return nil
+	}
+	if showDiff {
+		d, err := diff(filename, src, res)
+		if err != nil {
+			return fmt.Errorf("computing diff: %s", err)
+		}
+		os.Stdout.Write(d)
+	}
+	if write {
+		info, err := in.Stat()
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filename, res, info.Mode().Perm())
+	}
+	if !showDiff {
+		os.Stdout.Write(res)
+	}
+	return nil
+}
+
+// diff returns the differences between the sources src and res of
+// filename in the unified format, as printed by diff -u.
+func diff(filename string, src, res []byte) ([]byte, error) {
+	f1, err := writeTempFile("gates", src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTempFile("gates", res)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+
+	data, err := exec.Command("diff", "-u", f1, f2).CombinedOutput()
+	if len(data) == 0 {
+		return nil, err
+	}
+	// diff exits with a non-zero status when the files differ, which is
+	// not a failure as long as there is output
+	lines := bytes.SplitN(data, []byte("\n"), 3)
+	if len(lines) < 3 || !bytes.HasPrefix(lines[0], []byte("--- ")) || !bytes.HasPrefix(lines[1], []byte("+++ ")) {
+		return data, nil
+	}
+	// the header names the temporary files
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", filename, filename)
+	buf.Write(lines[2])
+	return buf.Bytes(), nil
+}
+
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
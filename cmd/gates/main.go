@@ -12,9 +12,13 @@ import (
 	"time"
 
 	"github.com/lujjjh/gates"
+	"github.com/lujjjh/gates/dap"
+	"github.com/lujjjh/gates/lsp"
+	"github.com/lujjjh/gates/syntax"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+var scriptprofile = flag.String("scriptprofile", "", "write script profile to file")
 var timelimit = flag.Int("timelimit", 0, "max time to run (in seconds)")
 
 func readSource(filename string) ([]byte, error) {
@@ -24,7 +28,7 @@ func readSource(filename string) ([]byte, error) {
 	return ioutil.ReadFile(filename)
 }
 
-func run() (gates.Value, error) {
+func run() (v gates.Value, err error) {
 	filename := flag.Arg(0)
 	src, err := readSource(filename)
 	if err != nil {
@@ -36,6 +40,27 @@ func run() (gates.Value, error) {
 	}
 
 	vm := gates.New()
+	if *scriptprofile != "" {
+		// err is not shadowed, so that the deferred function below can
+		// report the errors of writing the profile
+		var f *os.File
+		if f, err = os.Create(*scriptprofile); err != nil {
+			return nil, err
+		}
+		if err = vm.StartProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		defer func() {
+			// the run fails if the profile cannot be written
+			if stopErr := vm.StopProfile(); err == nil {
+				err = stopErr
+			}
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
 
 	ctx := context.Background()
 	if *timelimit > 0 {
@@ -44,7 +69,7 @@ func run() (gates.Value, error) {
 		defer cancel()
 	}
 
-	prg, err := gates.Compile(string(src))
+	prg, err := gates.CompileScript(filename, string(src))
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +84,24 @@ func main() {
 		}
 	}()
 	flag.Parse()
+	switch flag.Arg(0) {
+	case "fmt":
+		os.Exit(runFmt(flag.Args()[1:]))
+	case "debug":
+		os.Exit(runDebug(flag.Args()[1:]))
+	case "lsp":
+		// the language server speaks over the standard input and output
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "dap":
+		// so does the debug adapter
+		if err := dap.Serve(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -70,7 +113,12 @@ func main() {
 
 	v, err := run()
 	if err != nil {
-		log.Println(err)
+		if list, ok := err.(syntax.ErrorList); ok {
+			// report all the syntax errors
+			syntax.PrintError(os.Stderr, list)
+		} else {
+			log.Println(err)
+		}
 		os.Exit(64)
 	}
 	fmt.Println(v.ToString())
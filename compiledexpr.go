@@ -39,6 +39,9 @@ type compiledMapLit struct {
 type compiledFunctionLit struct {
 	baseCompiledExpr
 	expr *syntax.FunctionLit
+
+	// name is the name the function is bound to, if any.
+	name string
 }
 
 type compiledUnaryExpr struct {
@@ -76,6 +79,12 @@ type compiledIndexExpr struct {
 	index compiledExpr
 }
 
+type compiledSliceExpr struct {
+	baseCompiledExpr
+	expr      compiledExpr
+	low, high compiledExpr
+}
+
 type compiledCallExpr struct {
 	baseCompiledExpr
 	fun  compiledExpr
@@ -101,10 +110,17 @@ func (e *compiledIdentExpr) emitGetter() {
 	if e.c.scope != nil {
 		idx, ok := e.c.scope.lookupName(e.name)
 		if ok {
+			e.c.defineLocal(e)
 			e.c.emit(loadLocal(idx))
 			return
 		}
 	}
+	e.c.referenceGlobal(e)
+	if e.c.declared != nil {
+		e.c.checkDeclared(e.name, e.pos)
+		e.c.emit(loadGlobalSlot(e.c.program.globals.slot(e.name)))
+		return
+	}
 	e.c.emit(load(e.c.program.defineLit(String(e.name))), loadGlobal, get)
 }
 
@@ -113,10 +129,16 @@ func (e *compiledIdentExpr) emitSetter(valueExpr compiledExpr) {
 	if e.c.scope != nil {
 		idx, ok := e.c.scope.lookupName(e.name)
 		if ok {
+			e.c.defineLocal(e)
 			e.c.emit(storeLocal(idx))
 			return
 		}
 	}
+	e.c.referenceGlobal(e).Assigned = true
+	if e.c.declared != nil {
+		e.c.checkDeclared(e.name, e.pos)
+	}
+	e.c.markPos(e.pos)
 	e.c.emit(load(e.c.program.defineLit(String(e.name))), loadGlobal, set)
 }
 
@@ -143,7 +165,11 @@ func (e *compiledMapLit) emitGetter() {
 			e.c.compileExpr(entry.Value).emitGetter()
 			e.c.emit(mapConcat)
 		} else {
-			e.c.compileExpr(entry.Key).emitGetter()
+			if ident, ok := entry.Key.(*syntax.Ident); ok && !entry.Computed {
+				e.c.newLit(String(ident.Name), ident.NamePos).emitGetter()
+			} else {
+				e.c.compileExpr(entry.Key).emitGetter()
+			}
 			e.c.compileExpr(entry.Value).emitGetter()
 			e.c.emit(mapSet)
 		}
@@ -153,13 +179,17 @@ func (e *compiledMapLit) emitGetter() {
 func (e *compiledFunctionLit) emitGetter() {
 	savedProgram := e.c.program
 	p := &Program{
-		src: e.c.program.src,
+		src:       e.c.program.src,
+		generator: e.expr.Star.IsValid(),
+		globals:   e.c.program.globals,
+		fn:        e.pos,
+		name:      e.name,
 	}
 	e.c.program = p
-	e.c.emit(newStash)
 	e.c.scope = newScope(e.c.scope)
+	e.c.emitNewStash()
 	for i, ident := range e.expr.ParameterList.List {
-		idx := e.c.scope.bindName(ident.Name)
+		idx := e.c.scope.bindName(ident.Name, ident.NamePos)
 		e.c.emit(loadStack(-(i + 1)), storeLocal(idx))
 	}
 	for _, stmt := range e.expr.Body.StmtList {
@@ -168,7 +198,10 @@ func (e *compiledFunctionLit) emitGetter() {
 	e.c.emit(loadNull, ret)
 	if !e.c.scope.visited {
 		e.c.toStashlessFunction(e.c.program.code)
+		delete(p.stashNames, 0)
+		p.stackNames = e.c.scope.names
 	}
+	optimize(p)
 	stackSize := len(e.c.scope.names)
 	e.c.scope = e.c.scope.outer
 	e.c.program = savedProgram
@@ -178,64 +211,79 @@ func (e *compiledFunctionLit) emitGetter() {
 	})
 }
 
-func (e *compiledUnaryExpr) emitGetter() {
-	e.x.emitGetter()
-	switch e.op {
+// unaryOperator returns the instruction of the unary operator op.
+func unaryOperator(op syntax.Token) instruction {
+	switch op {
 	case syntax.ADD:
-		e.c.emit(plus)
+		return plus
 	case syntax.SUB:
-		e.c.emit(neg)
+		return neg
 	case syntax.NOT:
-		e.c.emit(not)
+		return not
 	default:
-		panic(fmt.Errorf("unknown unary operator: %s", e.op))
+		panic(fmt.Errorf("unknown unary operator: %s", op))
 	}
 }
 
-func (e *compiledBinaryExpr) emitGetter() {
-	if e.op == syntax.PIPE {
-		e.x.emitGetter()
-		e.c.emit(load(e.c.program.defineLit(Int(1))))
-		e.y.emitGetter()
-		e.c.emit(call)
-		return
-	}
-	e.x.emitGetter()
-	e.y.emitGetter()
-	switch e.op {
+// binaryOperator returns the instruction of the binary operator op, which
+// must not be a logical or pipe operator.
+func binaryOperator(op syntax.Token) instruction {
+	switch op {
 	case syntax.ADD:
-		e.c.emit(add)
+		return add
 	case syntax.SUB:
-		e.c.emit(sub)
+		return sub
 	case syntax.MUL:
-		e.c.emit(mul)
+		return mul
 	case syntax.QUO:
-		e.c.emit(div)
+		return div
 	case syntax.REM:
-		e.c.emit(mod)
+		return mod
 	case syntax.XOR:
-		e.c.emit(xor)
+		return xor
 	case syntax.SHL:
-		e.c.emit(shl)
+		return shl
 	case syntax.SHR:
-		e.c.emit(shr)
+		return shr
 	case syntax.EQL:
-		e.c.emit(eq)
+		return eq
 	case syntax.LSS:
-		e.c.emit(lt)
+		return lt
 	case syntax.GTR:
-		e.c.emit(gt)
+		return gt
 	case syntax.NEQ:
-		e.c.emit(neq)
+		return neq
 	case syntax.LEQ:
-		e.c.emit(lte)
+		return lte
 	case syntax.GEQ:
-		e.c.emit(gte)
+		return gte
 	default:
-		panic(fmt.Errorf("unknown binary operator: %s", e.op))
+		panic(fmt.Errorf("unknown binary operator: %s", op))
 	}
 }
 
+func (e *compiledUnaryExpr) emitGetter() {
+	e.x.emitGetter()
+	e.c.markPos(e.pos)
+	e.c.emit(unaryOperator(e.op))
+}
+
+func (e *compiledBinaryExpr) emitGetter() {
+	if e.op == syntax.PIPE {
+		e.x.emitGetter()
+		e.c.emit(load(e.c.program.defineLit(Int(1))))
+		e.y.emitGetter()
+		e.c.referenceSelection(e.y, true)
+		e.c.markPos(e.pos)
+		e.c.emit(call)
+		return
+	}
+	e.x.emitGetter()
+	e.y.emitGetter()
+	e.c.markPos(e.pos)
+	e.c.emit(binaryOperator(e.op))
+}
+
 func (e *compiledLogicalAnd) emitGetter() {
 	e.x.emitGetter()
 	j := len(e.c.program.code)
@@ -255,6 +303,8 @@ func (e *compiledLogicalOr) emitGetter() {
 func (e *compiledSelectorExpr) emitGetter() {
 	e.key.emitGetter()
 	e.expr.emitGetter()
+	e.c.referenceSelection(e, false)
+	e.c.markPos(e.pos)
 	e.c.emit(get)
 }
 
@@ -262,12 +312,16 @@ func (e *compiledSelectorExpr) emitSetter(valueExpr compiledExpr) {
 	valueExpr.emitGetter()
 	e.key.emitGetter()
 	e.expr.emitGetter()
+	e.c.referenceSelection(e, false)
+	e.c.markPos(e.pos)
 	e.c.emit(set)
 }
 
 func (e *compiledIndexExpr) emitGetter() {
 	e.index.emitGetter()
 	e.expr.emitGetter()
+	e.c.referenceSelection(e, false)
+	e.c.markPos(e.pos)
 	e.c.emit(get)
 }
 
@@ -275,20 +329,40 @@ func (e *compiledIndexExpr) emitSetter(valueExpr compiledExpr) {
 	valueExpr.emitGetter()
 	e.index.emitGetter()
 	e.expr.emitGetter()
+	e.c.referenceSelection(e, false)
+	e.c.markPos(e.pos)
 	e.c.emit(set)
 }
 
+func (e *compiledSliceExpr) emitGetter() {
+	if e.high != nil {
+		e.high.emitGetter()
+	} else {
+		e.c.emit(loadNull)
+	}
+	if e.low != nil {
+		e.low.emitGetter()
+	} else {
+		e.c.emit(loadNull)
+	}
+	e.expr.emitGetter()
+	e.c.markPos(e.pos)
+	e.c.emit(slice)
+}
+
 func (e *compiledCallExpr) emitGetter() {
 	for _, arg := range e.args {
 		arg.emitGetter()
 	}
 	e.c.emit(load(e.c.program.defineLit(Int(len(e.args)))))
 	e.fun.emitGetter()
+	e.c.referenceSelection(e.fun, true)
+	e.c.markPos(e.pos)
 	e.c.emit(call)
 }
 
 func (e *compiledVarDeclExpr) emitGetter() {
-	idx := e.c.scope.bindName(e.name)
+	idx := e.c.scope.bindName(e.name, e.pos)
 	if e.initializer != nil {
 		e.initializer.emitGetter()
 		e.c.emit(storeLocal(idx))
@@ -3,6 +3,7 @@ package gates
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/lujjjh/gates/syntax"
 )
@@ -10,6 +11,22 @@ import (
 type compiler struct {
 	program *Program
 	scope   *scope
+
+	// top is the top-level program of a script, where return statements
+	// halt the VM instead of returning from a function.
+	top *Program
+
+	// declared holds the names of the globals declared by the host when
+	// global identifiers are resolved at compile time.
+	declared map[string]bool
+
+	// references maps the global identifiers compiled so far to their
+	// uses, which are listed in the order they were found.
+	references    map[*compiledIdentExpr]*Reference
+	referenceList []*Reference
+
+	// definitions links the uses of local names to their declarations.
+	definitions []Definition
 }
 
 type CompilerError struct {
@@ -33,6 +50,46 @@ func (c *compiler) emit(instructions ...instruction) {
 	c.program.code = append(c.program.code, instructions...)
 }
 
+// markPos records pos as the source position of the instructions emitted
+// from now on.
+func (c *compiler) markPos(pos syntax.Pos) {
+	p := c.program
+	pc := len(p.code)
+	if n := len(p.srcMap); n > 0 {
+		if p.srcMap[n-1].pos == pos {
+			return
+		}
+		if p.srcMap[n-1].pc == pc {
+			p.srcMap[n-1].pos = pos
+			return
+		}
+	}
+	p.srcMap = append(p.srcMap, srcMapItem{pc: pc, pos: pos})
+}
+
+// markStmt records that the statement at pos starts with the instructions
+// emitted from now on.
+func (c *compiler) markStmt(pos syntax.Pos) {
+	p := c.program
+	pc := len(p.code)
+	if n := len(p.stmts); n > 0 && p.stmts[n-1].pc == pc {
+		// the previous statement emitted no instructions
+		p.stmts[n-1].pos = pos
+		return
+	}
+	p.stmts = append(p.stmts, srcMapItem{pc: pc, pos: pos})
+}
+
+// emitNewStash emits the creation of a stash for the current scope.
+func (c *compiler) emitNewStash() {
+	p := c.program
+	if p.stashNames == nil {
+		p.stashNames = make(map[int]map[string]uint32)
+	}
+	p.stashNames[len(p.code)] = c.scope.names
+	c.emit(newStash)
+}
+
 func (c *compiler) throwSyntaxError(pos syntax.Pos, format string, args ...interface{}) {
 	panic(&CompilerSyntaxError{
 		CompilerError: CompilerError{
@@ -43,6 +100,82 @@ func (c *compiler) throwSyntaxError(pos syntax.Pos, format string, args ...inter
 	})
 }
 
+// declareGlobals enables the resolution of global identifiers at compile
+// time. Besides names, the built-in globals are declared.
+func (c *compiler) declareGlobals(names []string) {
+	c.declared = make(map[string]bool, len(builtInFunctions)+len(names)+1)
+	for name := range builtInFunctions {
+		c.declared[name] = true
+	}
+	c.declared["strings"] = true
+	for _, name := range names {
+		c.declared[name] = true
+	}
+	c.program.globals = &globalSlots{index: make(map[string]int)}
+}
+
+// checkDeclared throws a syntax error if name is neither a local variable
+// nor a declared global.
+func (c *compiler) checkDeclared(name string, pos syntax.Pos) {
+	if c.declared[name] {
+		return
+	}
+	var candidates []string
+	for s := c.scope; s != nil; s = s.outer {
+		for local := range s.names {
+			candidates = append(candidates, local)
+		}
+	}
+	for global := range c.declared {
+		candidates = append(candidates, global)
+	}
+	if suggestion, ok := closestName(name, candidates); ok {
+		c.throwSyntaxError(pos, "undeclared identifier %s, did you mean %s?", name, suggestion)
+	}
+	c.throwSyntaxError(pos, "undeclared identifier %s", name)
+}
+
+// closestName returns the candidate with the smallest edit distance to
+// name, provided that it is close enough to be a likely misspelling.
+func closestName(name string, candidates []string) (string, bool) {
+	best, bestDistance := "", (len(name)+1)/2
+	for _, candidate := range candidates {
+		d := editDistance(name, candidate)
+		if d < bestDistance || d == bestDistance && best != "" && candidate < best {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best, best != ""
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	row := make([]int, len(y)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		diag := row[0]
+		row[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			next := diag + cost
+			if row[j]+1 < next {
+				next = row[j] + 1
+			}
+			if row[j-1]+1 < next {
+				next = row[j-1] + 1
+			}
+			diag, row[j] = row[j], next
+		}
+	}
+	return row[len(y)]
+}
+
 func (c *compiler) openScope() {
 	c.scope = newScope(c.scope)
 }
@@ -68,7 +201,32 @@ func (c *compiler) compileLetStmt(s *syntax.LetStmt) {
 }
 
 func (c *compiler) compileIfStmt(s *syntax.IfStmt) {
-	c.compileExpr(s.Test).emitGetter()
+	test := c.compileExpr(s.Test)
+	if lit, ok := test.(*compiledLit); ok {
+		// the branch that is never taken is still compiled, so that it
+		// is checked for errors, and removed by optimize as dead code
+		jmp := len(c.program.code)
+		c.emit(nil)
+		c.compileStmt(s.Consequent)
+		jmp2 := len(c.program.code)
+		if s.Alternate != nil {
+			c.emit(nil)
+			c.compileStmt(s.Alternate)
+		}
+		if lit.value.ToBool() {
+			c.program.code[jmp] = noop
+			if s.Alternate != nil {
+				c.program.code[jmp2] = jmp1(len(c.program.code) - jmp2)
+			}
+		} else {
+			c.program.code[jmp] = jmp1(jmp2 - jmp)
+			if s.Alternate != nil {
+				c.program.code[jmp2] = noop
+			}
+		}
+		return
+	}
+	test.emitGetter()
 	jmp := len(c.program.code)
 	c.emit(nil)
 	c.compileStmt(s.Consequent)
@@ -84,14 +242,15 @@ func (c *compiler) compileIfStmt(s *syntax.IfStmt) {
 }
 
 func (c *compiler) compileForStmt(s *syntax.ForStmt) {
-	if s.Initializer != nil {
+	if _, ok := s.Initializer.(*syntax.LetStmt); ok {
 		c.openScope()
-		c.emit(newStash)
+		c.emitNewStash()
 		defer func() {
 			c.emit(popStash)
 			c.closeScope()
 		}()
-
+	}
+	if s.Initializer != nil {
 		c.compileStmt(s.Initializer)
 	}
 
@@ -120,17 +279,44 @@ func (c *compiler) compileReturnStmt(s *syntax.ReturnStmt) {
 	} else {
 		c.compileExpr(s.Result).emitGetter()
 	}
+	if c.program == c.top {
+		c.emit(halt)
+		return
+	}
 	c.emit(ret)
 }
 
+func (c *compiler) compileYieldStmt(s *syntax.YieldStmt) {
+	if !c.program.generator {
+		c.throwSyntaxError(s.Yield, "yield outside generator function")
+	}
+	if s.Value == nil {
+		c.emit(loadNull)
+	} else {
+		c.compileExpr(s.Value).emitGetter()
+	}
+	c.markPos(s.Yield)
+	c.emit(yield)
+}
+
 func (c *compiler) compileStmt(s syntax.Stmt) {
+	if _, ok := s.(*syntax.BodyStmt); !ok {
+		c.markStmt(s.Pos())
+	}
 	switch s := s.(type) {
 	case *syntax.ExprStmt:
 		c.compileExpr(s.X).emitGetter()
 		c.emit(pop)
 	case *syntax.BodyStmt:
+		if !declaresNames(s.StmtList) {
+			// a block without let statements needs no stash of its own
+			for _, stmt := range s.StmtList {
+				c.compileStmt(stmt)
+			}
+			return
+		}
 		c.openScope()
-		c.emit(newStash)
+		c.emitNewStash()
 		for _, stmt := range s.StmtList {
 			c.compileStmt(stmt)
 		}
@@ -146,11 +332,26 @@ func (c *compiler) compileStmt(s syntax.Stmt) {
 		c.compileForStmt(s)
 	case *syntax.ReturnStmt:
 		c.compileReturnStmt(s)
+	case *syntax.YieldStmt:
+		c.compileYieldStmt(s)
+	case *syntax.BadStmt:
+		// the parser has reported the error; the statement is skipped
 	default:
 		panic(fmt.Errorf("unknown statement type: %T", s))
 	}
 }
 
+// declaresNames reports whether any of stmtList is a let statement, which
+// binds names in the scope of the enclosing block.
+func declaresNames(stmtList []syntax.Stmt) bool {
+	for _, stmt := range stmtList {
+		if _, ok := stmt.(*syntax.LetStmt); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *compiler) compileIdent(l *syntax.Ident) compiledExpr {
 	idExpr := &compiledIdentExpr{
 		name: l.Name,
@@ -163,15 +364,32 @@ func (c *compiler) compileLit(l *syntax.Lit) compiledExpr {
 	var v Value
 	switch l.Kind {
 	case syntax.NUMBER:
-		i, err := strconv.ParseInt(l.Value, 0, 64)
+		value := strings.Replace(l.Value, "_", "", -1)
+		// a d is a hexadecimal digit after 0x, and the decimal suffix
+		// only in decimal literals
+		prefixed := len(value) > 1 && value[0] == '0' && strings.ContainsRune("xXoObB", rune(value[1]))
+		if !prefixed && strings.HasSuffix(value, "d") {
+			d, ok := ParseDecimal(strings.TrimSuffix(value, "d"))
+			if !ok {
+				c.throwSyntaxError(l.ValuePos, "invalid decimal literal %s", l.Value)
+			}
+			v = d
+			break
+		}
+		i, err := strconv.ParseInt(value, 0, 64)
+		if err != nil && !prefixed {
+			// a legacy octal literal with an 8 or a 9, such as 09, is
+			// decimal
+			i, err = strconv.ParseInt(value, 10, 64)
+		}
 		if err == nil {
 			v = Int(i)
 		} else {
-			f, _ := strconv.ParseFloat(l.Value, 64)
+			f, _ := strconv.ParseFloat(value, 64)
 			v = Float(f)
 		}
 	case syntax.STRING:
-		s, _ := strconv.Unquote(l.Value)
+		s, _ := syntax.Unquote(l.Value)
 		v = String(s)
 	case syntax.BOOL:
 		v = Bool(l.Value == "true")
@@ -180,10 +398,14 @@ func (c *compiler) compileLit(l *syntax.Lit) compiledExpr {
 	default:
 		panic(fmt.Errorf("unknown token type %v", l.Kind))
 	}
+	return c.newLit(v, l.ValuePos)
+}
+
+func (c *compiler) newLit(v Value, pos syntax.Pos) *compiledLit {
 	lit := &compiledLit{
 		value: v,
 	}
-	lit.init(c, l.ValuePos)
+	lit.init(c, pos)
 	return lit
 }
 
@@ -249,9 +471,15 @@ func (c *compiler) toStashlessFunction(code []instruction) {
 }
 
 func (c *compiler) compileUnaryExpr(e *syntax.UnaryExpr) compiledExpr {
+	x := c.compileExpr(e.X)
+	if lit, ok := x.(*compiledLit); ok {
+		if v, ok := foldConstant(unaryOperator(e.Op), lit.value); ok {
+			return c.newLit(v, e.OpPos)
+		}
+	}
 	r := &compiledUnaryExpr{
 		op: e.Op,
-		x:  c.compileExpr(e.X),
+		x:  x,
 	}
 	r.init(c, e.OpPos)
 	return r
@@ -262,6 +490,13 @@ func (c *compiler) compileLogicalAnd(x, y syntax.Expr, pos syntax.Pos) compiledE
 		x: c.compileExpr(x),
 		y: c.compileExpr(y),
 	}
+	if lit, ok := r.x.(*compiledLit); ok {
+		if lit.value.ToBool() {
+			return r.y
+		}
+		c.discard(r.y)
+		return lit
+	}
 	r.init(c, pos)
 	return r
 }
@@ -271,10 +506,31 @@ func (c *compiler) compileLogicalOr(x, y syntax.Expr, pos syntax.Pos) compiledEx
 		x: c.compileExpr(x),
 		y: c.compileExpr(y),
 	}
+	if lit, ok := r.x.(*compiledLit); ok {
+		if lit.value.ToBool() {
+			c.discard(r.y)
+			return lit
+		}
+		return r.y
+	}
 	r.init(c, pos)
 	return r
 }
 
+// discard compiles e, which constant folding removed, into a program that
+// is thrown away, so that e is still checked for errors and the names it
+// uses are still found by References and Definitions.
+func (c *compiler) discard(e compiledExpr) {
+	saved := c.program
+	c.program = &Program{
+		src:       saved.src,
+		generator: saved.generator,
+		globals:   saved.globals,
+	}
+	e.emitGetter()
+	c.program = saved
+}
+
 func (c *compiler) compileBinaryExpr(e *syntax.BinaryExpr) compiledExpr {
 	switch e.Op {
 	case syntax.LAND:
@@ -288,18 +544,23 @@ func (c *compiler) compileBinaryExpr(e *syntax.BinaryExpr) compiledExpr {
 		op: e.Op,
 		y:  c.compileExpr(e.Y),
 	}
+	if e.Op != syntax.PIPE {
+		x, xOk := r.x.(*compiledLit)
+		y, yOk := r.y.(*compiledLit)
+		if xOk && yOk {
+			if v, ok := foldConstant(binaryOperator(e.Op), x.value, y.value); ok {
+				return c.newLit(v, e.OpPos)
+			}
+		}
+	}
 	r.init(c, e.OpPos)
 	return r
 }
 
 func (c *compiler) compileSelectorExpr(e syntax.Expr, key Value, pos syntax.Pos) compiledExpr {
-	lit := &compiledLit{
-		value: key,
-	}
-	lit.init(c, pos)
 	r := &compiledSelectorExpr{
 		expr: c.compileExpr(e),
-		key:  lit,
+		key:  c.newLit(key, pos),
 	}
 	r.init(c, pos)
 	return r
@@ -314,6 +575,20 @@ func (c *compiler) compileIndexExpr(e, index syntax.Expr, pos syntax.Pos) compil
 	return r
 }
 
+func (c *compiler) compileSliceExpr(e *syntax.SliceExpr) compiledExpr {
+	r := &compiledSliceExpr{
+		expr: c.compileExpr(e.X),
+	}
+	if e.Low != nil {
+		r.low = c.compileExpr(e.Low)
+	}
+	if e.High != nil {
+		r.high = c.compileExpr(e.High)
+	}
+	r.init(c, e.Lbrack)
+	return r
+}
+
 func (c *compiler) compileCallExpr(e *syntax.CallExpr) compiledExpr {
 	args := make([]compiledExpr, len(e.Args))
 	for i, argExpr := range e.Args {
@@ -331,6 +606,9 @@ func (c *compiler) compileVarDeclExpr(e *syntax.VarDeclExpr) compiledExpr {
 	var initializer compiledExpr
 	if e.Initializer != nil {
 		initializer = c.compileExpr(e.Initializer)
+		if f, ok := initializer.(*compiledFunctionLit); ok {
+			f.name = e.Name
+		}
 	}
 	r := &compiledVarDeclExpr{
 		name:        e.Name,
@@ -362,16 +640,57 @@ func (c *compiler) compileExpr(e syntax.Expr) compiledExpr {
 		return c.compileSelectorExpr(e.X, String(e.Sel.Name), e.Sel.NamePos)
 	case *syntax.IndexExpr:
 		return c.compileIndexExpr(e.X, e.Index, e.Lbrack)
+	case *syntax.SliceExpr:
+		return c.compileSliceExpr(e)
 	case *syntax.CallExpr:
 		return c.compileCallExpr(e)
 	case *syntax.VarDeclExpr:
 		return c.compileVarDeclExpr(e)
+	case *syntax.BadExpr:
+		// the parser has reported the error; the value is null
+		return c.newLit(Null, e.From)
 	default:
 		panic(fmt.Errorf("unknown expression type: %T", e))
 	}
 }
 
 func (c *compiler) compile(e syntax.Expr) {
+	c.markStmt(e.Pos())
 	c.compileExpr(e).emitGetter()
 	c.emit(halt)
+	optimize(c.program)
+	c.program.references = c.referenceList
+	c.program.definitions = c.definitions
+}
+
+func (c *compiler) compileScript(s *syntax.Script) {
+	c.top = c.program
+	c.program.script = true
+	c.openScope()
+
+	var result syntax.Expr
+	stmtList := s.StmtList
+	if n := len(stmtList); n > 0 {
+		if x, ok := stmtList[n-1].(*syntax.ExprStmt); ok {
+			// the value of the final expression statement is the result
+			result = x.X
+			stmtList = stmtList[:n-1]
+		}
+	}
+	for _, stmt := range stmtList {
+		c.compileStmt(stmt)
+	}
+	if result != nil {
+		c.markStmt(result.Pos())
+		c.compileExpr(result).emitGetter()
+	} else {
+		c.emit(loadNull)
+	}
+	c.emit(halt)
+	optimize(c.program)
+	c.program.references = c.referenceList
+	c.program.definitions = c.definitions
+
+	c.program.bindings = c.scope.names
+	c.closeScope()
 }
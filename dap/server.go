@@ -0,0 +1,486 @@
+// Package dap implements a Debug Adapter Protocol server for Gates
+// scripts, which lets editors run a script with breakpoints, step through
+// it and inspect its variables.
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/lujjjh/gates"
+	"github.com/lujjjh/gates/internal/framing"
+)
+
+type server struct {
+	in *bufio.Reader
+	d  *gates.Debugger
+
+	// wmu serializes the messages, which the program also sends.
+	wmu sync.Mutex
+	w   io.Writer
+	seq int
+
+	// the program starts once it is launched and the configuration is
+	// done, and done is closed when it ends
+	r          *gates.Runtime
+	path       string
+	program    *gates.Program
+	configured bool
+	started    bool
+	cancel     func()
+	done       chan struct{}
+
+	// aborted is closed when the program is aborted, which also releases
+	// a stop that has not been reported yet.
+	aborted  chan struct{}
+	aborting bool
+
+	// mu guards the state of a stopped program: its frames, innermost
+	// first, and the variables of the references handed out, which are
+	// valid until the program resumes.
+	mu      sync.Mutex
+	stopped bool
+	entry   bool
+	frames  []*gates.Frame
+	handles []func() []gates.Variable
+	resume  chan gates.StepMode
+}
+
+// Serve reads requests from r and writes responses and events to w,
+// typically the standard input and output of the adapter process. It
+// returns when the client disconnects or r is closed, aborting the
+// program if it is still running.
+func Serve(r io.Reader, w io.Writer) error {
+	s := newServer(r, w)
+	defer s.abort()
+	for {
+		req, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func newServer(r io.Reader, w io.Writer) *server {
+	s := &server{
+		in:      bufio.NewReader(r),
+		w:       w,
+		done:    make(chan struct{}),
+		aborted: make(chan struct{}),
+		resume:  make(chan gates.StepMode),
+	}
+	s.d = gates.NewDebugger(s.stop)
+	return s
+}
+
+// handle responds to a request and then takes the actions that must
+// follow the response. The error is set only if the output fails.
+func (s *server) handle(req *request) error {
+	body, then, err := s.dispatch(req)
+	resp := &response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	if err := s.send(resp); err != nil {
+		return err
+	}
+	if then != nil {
+		return then()
+	}
+	return nil
+}
+
+func (s *server) send(msg interface{}) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *response:
+		msg.Seq = s.seq
+	case *event:
+		msg.Seq = s.seq
+	}
+	return framing.Write(s.w, msg)
+}
+
+func (s *server) sendEvent(name string, body interface{}) error {
+	return s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+func (s *server) dispatch(req *request) (body interface{}, then func() error, err error) {
+	switch req.Command {
+	case "initialize":
+		body = map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsTerminateRequest":         true,
+			"supportsEvaluateForHovers":        true,
+		}
+		return body, func() error { return s.sendEvent("initialized", nil) }, nil
+	case "launch":
+		var args launchArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return nil, nil, err
+		}
+		return nil, s.start, s.launch(args)
+	case "configurationDone":
+		s.configured = true
+		return nil, s.start, nil
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return nil, nil, err
+		}
+		path := cleanPath(args.Source.Path)
+		s.d.ClearBreakpoints(path)
+		lines := s.statementLines(path)
+		breakpoints := []Breakpoint{}
+		for _, b := range args.Breakpoints {
+			if !lines[b.Line] {
+				breakpoints = append(breakpoints, Breakpoint{Line: b.Line, Message: "no statement on this line"})
+				continue
+			}
+			s.d.SetBreakpoint(path, b.Line)
+			breakpoints = append(breakpoints, Breakpoint{Verified: true, Line: b.Line})
+		}
+		return map[string]interface{}{"breakpoints": breakpoints}, nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil, nil
+
+	case "stackTrace":
+		var args stackTraceArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return nil, nil, err
+		}
+		return s.stackTrace(args)
+	case "scopes":
+		var args scopesArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return nil, nil, err
+		}
+		return s.scopes(args)
+	case "variables":
+		var args variablesArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return nil, nil, err
+		}
+		return s.variables(args)
+	case "evaluate":
+		var args evaluateArguments
+		if err := unmarshalArguments(req, &args); err != nil {
+			return nil, nil, err
+		}
+		return s.evaluate(args)
+
+	case "continue":
+		body = map[string]interface{}{"allThreadsContinued": true}
+		return body, func() error { s.resumeWith(gates.Continue); return nil }, nil
+	case "next":
+		return nil, func() error { s.resumeWith(gates.StepOver); return nil }, nil
+	case "stepIn":
+		return nil, func() error { s.resumeWith(gates.StepIn); return nil }, nil
+	case "stepOut":
+		return nil, func() error { s.resumeWith(gates.StepOut); return nil }, nil
+	case "pause":
+		s.d.Pause()
+		return nil, nil, nil
+	case "terminate":
+		return nil, func() error { s.abort(); return nil }, nil
+	case "disconnect":
+		s.abort()
+		return nil, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported command %s", req.Command)
+}
+
+func unmarshalArguments(req *request, v interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Arguments, v)
+}
+
+// cleanPath returns the absolute path of a file, which identifies it in
+// breakpoints.
+func cleanPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func (s *server) launch(args launchArguments) error {
+	if s.program != nil {
+		return errors.New("the program is already launched")
+	}
+	if args.Program == "" {
+		return errors.New("missing program")
+	}
+	path := cleanPath(args.Program)
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	program, err := gates.CompileScript(path, string(src))
+	if err != nil {
+		return err
+	}
+	s.r = gates.New()
+	s.path = path
+	if !args.NoDebug {
+		s.r.SetDebugHook(s.d)
+	}
+	if args.StopOnEntry {
+		s.entry = true
+		s.d.Pause()
+	}
+	s.program = program
+	return nil
+}
+
+// statementLines returns the lines of the script at path on which
+// statements start, where the program can stop.
+func (s *server) statementLines(path string) map[int]bool {
+	program := s.program
+	if program == nil || path != s.path {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		if program, err = gates.CompileScript(path, string(src)); err != nil {
+			return nil
+		}
+	}
+	lines := make(map[int]bool)
+	for _, line := range program.StatementLines() {
+		lines[line] = true
+	}
+	return lines
+}
+
+// start runs the program once it is launched and configured.
+func (s *server) start() error {
+	if s.program == nil || !s.configured || s.started {
+		return nil
+	}
+	s.started = true
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(ctx)
+	return nil
+}
+
+// run runs the program and reports its result.
+func (s *server) run(ctx context.Context) {
+	defer close(s.done)
+	v, err := s.r.RunProgram(ctx, s.program)
+	exitCode := 0
+	switch {
+	case ctx.Err() != nil:
+		// aborted by the client
+		exitCode = 1
+	case err != nil:
+		s.sendEvent("output", map[string]string{"category": "stderr", "output": err.Error() + "\n"})
+		exitCode = 1
+	default:
+		s.sendEvent("output", map[string]string{"category": "stdout", "output": v.ToString() + "\n"})
+	}
+	s.sendEvent("exited", map[string]int{"exitCode": exitCode})
+	s.sendEvent("terminated", nil)
+}
+
+// abort stops the program, if it is running, and waits until it ends.
+func (s *server) abort() {
+	if !s.started {
+		return
+	}
+	if !s.aborting {
+		s.aborting = true
+		s.cancel()
+		close(s.aborted)
+	}
+	s.resumeWith(gates.Continue)
+	<-s.done
+}
+
+// stop is called by the Debugger. It reports that the program stopped and
+// waits for a request to resume it, unless the program is aborted, which
+// may happen before the run notices that its context is canceled.
+func (s *server) stop(reason gates.StopReason, f *gates.Frame) gates.StepMode {
+	select {
+	case <-s.aborted:
+		return gates.Continue
+	default:
+	}
+	var frames []*gates.Frame
+	for ; f != nil; f = f.Caller() {
+		frames = append(frames, f)
+	}
+	s.mu.Lock()
+	s.stopped, s.frames, s.handles = true, frames, nil
+	description := reason.String()
+	if s.entry && reason == gates.StopPause {
+		description = "entry"
+	}
+	s.entry = false
+	s.mu.Unlock()
+
+	s.sendEvent("stopped", map[string]interface{}{
+		"reason":            description,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	})
+	select {
+	case mode := <-s.resume:
+		return mode
+	case <-s.aborted:
+		return gates.Continue
+	}
+}
+
+// resumeWith resumes a stopped program.
+func (s *server) resumeWith(mode gates.StepMode) {
+	s.mu.Lock()
+	stopped := s.stopped
+	s.stopped, s.frames, s.handles = false, nil, nil
+	s.mu.Unlock()
+	if stopped {
+		select {
+		case s.resume <- mode:
+		case <-s.aborted:
+		}
+	}
+}
+
+var errNotStopped = errors.New("the program is not stopped")
+
+// frame returns the frame with the given ID, which is its index plus one.
+// It must be called with s.mu held.
+func (s *server) frame(id int) (*gates.Frame, error) {
+	if !s.stopped {
+		return nil, errNotStopped
+	}
+	if id < 1 || id > len(s.frames) {
+		return nil, fmt.Errorf("unknown frame %d", id)
+	}
+	return s.frames[id-1], nil
+}
+
+// reference returns a reference to the variables returned by vars.
+func (s *server) reference(vars func() []gates.Variable) int {
+	s.handles = append(s.handles, vars)
+	return len(s.handles)
+}
+
+func (s *server) stackTrace(args stackTraceArguments) (interface{}, func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		return nil, nil, errNotStopped
+	}
+	frames := []StackFrame{}
+	for i, f := range s.frames {
+		if i < args.StartFrame || args.Levels > 0 && i >= args.StartFrame+args.Levels {
+			continue
+		}
+		frame := StackFrame{ID: i + 1, Name: f.Name()}
+		// the position of a frame calling a native function is unknown
+		if pos := f.Position(); pos.IsValid() {
+			frame.Source = &Source{Name: filepath.Base(pos.Filename), Path: pos.Filename}
+			frame.Line, frame.Column = pos.Line, pos.Column
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(s.frames)}, nil, nil
+}
+
+func (s *server) scopes(args scopesArguments) (interface{}, func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.frame(args.FrameID)
+	if err != nil {
+		return nil, nil, err
+	}
+	scopes := []Scope{
+		{Name: "Locals", VariablesReference: s.reference(f.Locals)},
+		{Name: "Globals", VariablesReference: s.reference(f.Globals)},
+	}
+	return map[string]interface{}{"scopes": scopes}, nil, nil
+}
+
+func (s *server) variables(args variablesArguments) (interface{}, func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		return nil, nil, errNotStopped
+	}
+	ref := args.VariablesReference
+	if ref < 1 || ref > len(s.handles) {
+		return nil, nil, fmt.Errorf("unknown variables reference %d", ref)
+	}
+	vars := []Variable{}
+	for _, v := range s.handles[ref-1]() {
+		vars = append(vars, Variable{
+			Name:               v.Name,
+			Value:              gates.Inspect(v.Value),
+			Type:               gates.Type(v.Value),
+			VariablesReference: s.members(v.Value),
+		})
+	}
+	return map[string]interface{}{"variables": vars}, nil, nil
+}
+
+// members returns a reference to the members of v, or 0 if it has none.
+func (s *server) members(v gates.Value) int {
+	if len(gates.Members(v)) == 0 {
+		return 0
+	}
+	return s.reference(func() []gates.Variable { return gates.Members(v) })
+}
+
+// evaluate looks up a variable or a member of it, see gates.Frame.Lookup.
+func (s *server) evaluate(args evaluateArguments) (interface{}, func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := args.FrameID
+	if id == 0 {
+		id = 1
+	}
+	f, err := s.frame(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, ok := f.Lookup(args.Expression)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not defined", args.Expression)
+	}
+	return map[string]interface{}{
+		"result":             gates.Inspect(v),
+		"type":               gates.Type(v),
+		"variablesReference": s.members(v),
+	}, nil, nil
+}
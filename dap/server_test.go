@@ -0,0 +1,290 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lujjjh/gates"
+	"github.com/lujjjh/gates/internal/framing"
+	"github.com/stretchr/testify/assert"
+)
+
+const src = `let total = 0;
+let m = { a: [1, 2] };
+let add = function (a, b) {
+  let sum = a + b;
+  return sum;
+};
+for (let i = 0; i < 2; i = i + 1) {
+  total = add(total, i);
+}
+total
+`
+
+// client drives a server running in the background.
+type client struct {
+	t    *testing.T
+	w    io.Writer
+	r    *bufio.Reader
+	seq  int
+	done chan error
+}
+
+func start(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		err := Serve(inR, outW)
+		outW.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *client) send(command string, args interface{}) {
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args}
+	assert.NoError(c.t, framing.Write(c.w, req))
+}
+
+// next reads the next message from the server.
+func (c *client) next() map[string]interface{} {
+	body, err := framing.Read(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var m map[string]interface{}
+	assert.NoError(c.t, json.Unmarshal(body, &m))
+	return m
+}
+
+// call sends a request and returns the body of the response, which must
+// succeed.
+func (c *client) call(command string, args interface{}) map[string]interface{} {
+	c.send(command, args)
+	resp := c.next()
+	assert.Equal(c.t, "response", resp["type"])
+	assert.Equal(c.t, command, resp["command"])
+	assert.Equal(c.t, float64(c.seq), resp["request_seq"])
+	assert.Equal(c.t, true, resp["success"], resp["message"])
+	body, _ := resp["body"].(map[string]interface{})
+	return body
+}
+
+// event reads an event, which must have the given name, and returns its
+// body.
+func (c *client) event(name string) map[string]interface{} {
+	e := c.next()
+	assert.Equal(c.t, "event", e["type"])
+	assert.Equal(c.t, name, e["event"])
+	body, _ := e["body"].(map[string]interface{})
+	return body
+}
+
+// toJSON marshals v, in which the keys of the maps are sorted.
+func toJSON(v interface{}) string {
+	var b strings.Builder
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.Encode(v)
+	return strings.TrimSpace(b.String())
+}
+
+func writeScript(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "script.gates")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestServer(t *testing.T) {
+	path, cleanup := writeScript(t)
+	defer cleanup()
+	c := start(t)
+
+	body := c.call("initialize", map[string]interface{}{"adapterID": "gates"})
+	assert.Equal(t, true, body["supportsConfigurationDoneRequest"])
+	c.event("initialized")
+	c.call("launch", map[string]interface{}{"program": path})
+	body = c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 4}, {"line": 6}, {"line": 10}},
+	})
+	assert.Equal(t, `[{"line":4,"verified":true},{"line":6,"message":"no statement on this line","verified":false},`+
+		`{"line":10,"verified":true}]`, toJSON(body["breakpoints"]))
+	body = c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 4}},
+	})
+	assert.Equal(t, `[{"line":4,"verified":true}]`, toJSON(body["breakpoints"]))
+	body = c.call("threads", nil)
+	assert.Equal(t, `[{"id":1,"name":"main"}]`, toJSON(body["threads"]))
+
+	c.call("configurationDone", nil)
+	body = c.event("stopped")
+	assert.Equal(t, `{"allThreadsStopped":true,"reason":"breakpoint","threadId":1}`, toJSON(body))
+
+	body = c.call("stackTrace", map[string]int{"threadId": 1})
+	assert.Equal(t, fmt.Sprintf(`[{"column":3,"id":1,"line":4,"name":"add","source":{"name":"script.gates","path":%q}},`+
+		`{"column":14,"id":2,"line":8,"name":"<script>","source":{"name":"script.gates","path":%[1]q}}]`, path),
+		toJSON(body["stackFrames"]))
+	assert.Equal(t, float64(2), body["totalFrames"])
+	body = c.call("stackTrace", map[string]int{"threadId": 1, "startFrame": 1, "levels": 1})
+	assert.Len(t, body["stackFrames"], 1)
+
+	body = c.call("scopes", map[string]int{"frameId": 1})
+	assert.Equal(t, `[{"expensive":false,"name":"Locals","variablesReference":1},`+
+		`{"expensive":false,"name":"Globals","variablesReference":2}]`, toJSON(body["scopes"]))
+	body = c.call("variables", map[string]int{"variablesReference": 1})
+	assert.Equal(t, `[{"name":"a","type":"number","value":"0","variablesReference":0},`+
+		`{"name":"add","type":"function","value":"function add","variablesReference":0},`+
+		`{"name":"b","type":"number","value":"0","variablesReference":0},`+
+		`{"name":"m","type":"map","value":"{a: [1, 2]}","variablesReference":3},`+
+		`{"name":"sum","type":"null","value":"null","variablesReference":0},`+
+		`{"name":"total","type":"number","value":"0","variablesReference":0}]`, toJSON(body["variables"]))
+	body = c.call("variables", map[string]int{"variablesReference": 3})
+	assert.Equal(t, `[{"name":"a","type":"array","value":"[1, 2]","variablesReference":4}]`, toJSON(body["variables"]))
+	body = c.call("variables", map[string]int{"variablesReference": 2})
+	assert.Equal(t, `[]`, toJSON(body["variables"]))
+	body = c.call("evaluate", map[string]interface{}{"expression": "m.a.1", "frameId": 1})
+	assert.Equal(t, "2", body["result"])
+	body = c.call("evaluate", map[string]interface{}{"expression": "i", "frameId": 2})
+	assert.Equal(t, "0", body["result"])
+
+	c.send("evaluate", map[string]interface{}{"expression": "i", "frameId": 1})
+	resp := c.next()
+	assert.Equal(t, false, resp["success"])
+	assert.Equal(t, "i is not defined", resp["message"])
+
+	c.call("next", map[string]int{"threadId": 1})
+	body = c.event("stopped")
+	assert.Equal(t, "step", body["reason"])
+	body = c.call("stackTrace", map[string]int{"threadId": 1})
+	assert.Equal(t, fmt.Sprintf(`{"column":3,"id":1,"line":5,"name":"add","source":{"name":"script.gates","path":%q}}`, path),
+		toJSON(body["stackFrames"].([]interface{})[0]))
+
+	c.call("continue", map[string]int{"threadId": 1})
+	body = c.event("stopped")
+	assert.Equal(t, "breakpoint", body["reason"])
+	body = c.call("evaluate", map[string]interface{}{"expression": "b"})
+	assert.Equal(t, "1", body["result"])
+
+	c.call("continue", map[string]int{"threadId": 1})
+	body = c.event("output")
+	assert.Equal(t, `{"category":"stdout","output":"1\n"}`, toJSON(body))
+	body = c.event("exited")
+	assert.Equal(t, float64(0), body["exitCode"])
+	c.event("terminated")
+
+	c.send("stackTrace", map[string]int{"threadId": 1})
+	resp = c.next()
+	assert.Equal(t, false, resp["success"])
+	assert.Equal(t, "the program is not stopped", resp["message"])
+
+	c.call("disconnect", nil)
+	assert.NoError(t, <-c.done)
+}
+
+func TestStopOnEntry(t *testing.T) {
+	path, cleanup := writeScript(t)
+	defer cleanup()
+	c := start(t)
+
+	c.call("launch", map[string]interface{}{"program": path, "stopOnEntry": true})
+	c.call("configurationDone", nil)
+	body := c.event("stopped")
+	assert.Equal(t, "entry", body["reason"])
+	c.call("stepIn", map[string]int{"threadId": 1})
+	body = c.event("stopped")
+	assert.Equal(t, "step", body["reason"])
+
+	// terminating aborts the program
+	c.call("terminate", nil)
+	body = c.event("exited")
+	assert.Equal(t, float64(1), body["exitCode"])
+	c.event("terminated")
+	c.call("disconnect", nil)
+	assert.NoError(t, <-c.done)
+}
+
+func TestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "invalid.gates")
+	assert.NoError(t, ioutil.WriteFile(invalid, []byte("let x = ;"), 0644))
+	failing := filepath.Join(dir, "failing.gates")
+	assert.NoError(t, ioutil.WriteFile(failing, []byte("let f = 1;\nmap = f"), 0644))
+
+	c := start(t)
+	for _, test := range []struct {
+		command string
+		args    interface{}
+	}{
+		{"launch", map[string]string{}},
+		{"launch", map[string]string{"program": filepath.Join(dir, "missing.gates")}},
+		{"launch", map[string]string{"program": invalid}},
+		{"scopes", map[string]int{"frameId": 1}},
+		{"variables", map[string]int{"variablesReference": 1}},
+		{"restart", nil},
+	} {
+		c.send(test.command, test.args)
+		resp := c.next()
+		assert.Equal(t, false, resp["success"], test.command)
+		assert.NotEmpty(t, resp["message"], test.command)
+	}
+
+	// the program fails without stopping, as it is run without debugging
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": failing},
+		"breakpoints": []map[string]int{{"line": 1}},
+	})
+	c.call("launch", map[string]interface{}{"program": failing, "noDebug": true})
+	c.call("configurationDone", nil)
+	body := c.event("output")
+	assert.Equal(t, "stderr", body["category"])
+	body = c.event("exited")
+	assert.Equal(t, float64(1), body["exitCode"])
+	c.event("terminated")
+
+	// closing the input ends the session
+	c.w.(io.Closer).Close()
+	assert.NoError(t, <-c.done)
+}
+
+func TestAbortBeforeStop(t *testing.T) {
+	s := newServer(strings.NewReader(""), ioutil.Discard)
+	s.started, s.cancel = true, func() {}
+	go func() {
+		// the program stops after its context is canceled
+		<-s.aborted
+		s.stop(gates.StopPause, nil)
+		close(s.done)
+	}()
+	aborted := make(chan struct{})
+	go func() {
+		s.abort()
+		close(aborted)
+	}()
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("abort is blocked by the stop")
+	}
+}
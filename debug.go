@@ -0,0 +1,494 @@
+package gates
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lujjjh/gates/syntax"
+)
+
+// A DebugHook is called by a running program at the start of each
+// statement, see Runtime.SetDebugHook. The program waits for the hook to
+// return.
+type DebugHook interface {
+	// Statement is called before the statement at the position of f is
+	// executed. f is the innermost frame of the call stack; it is only
+	// valid until Statement returns.
+	Statement(f *Frame)
+}
+
+// SetDebugHook sets the hook called at the start of each statement, or
+// removes it if h is nil. Programs run considerably slower with a hook,
+// see Debugger for a hook with breakpoints and stepping.
+func (r *Runtime) SetDebugHook(h DebugHook) {
+	r.vm.hook = h
+}
+
+// StatementLines returns the sorted lines on which the statements of p
+// and of its functions start, i.e. the lines where a Debugger can stop.
+// Statements removed by the compiler, such as those under if (false), are
+// not included.
+func (p *Program) StatementLines() []int {
+	seen := make(map[int]bool)
+	var lines []int
+	for _, program := range programList(p) {
+		if program.src == nil {
+			continue
+		}
+		for _, item := range program.stmts {
+			line := program.src.Position(item.pos).Line
+			if !seen[line] {
+				seen[line] = true
+				lines = append(lines, line)
+			}
+		}
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// A Frame is the top level of a program or a function call on the call
+// stack of a program stopped by a DebugHook.
+type Frame struct {
+	vm *vm
+
+	// depth is the number of frames below, i.e. the index in the call
+	// stack of the context saved by the call of the frame.
+	depth   int
+	program *Program
+	stash   *stash
+	bp      int
+	pos     syntax.Pos
+}
+
+// frame returns the innermost frame, which is at the statement at pos.
+func (vm *vm) frame(pos syntax.Pos) *Frame {
+	return &Frame{
+		vm:      vm,
+		depth:   len(vm.callStack),
+		program: vm.program,
+		stash:   vm.stash,
+		bp:      vm.bp,
+		pos:     pos,
+	}
+}
+
+// Caller returns the frame that called f, or nil if f is the bottom
+// frame.
+func (f *Frame) Caller() *Frame {
+	if f.depth == 0 {
+		return nil
+	}
+	ctx := f.vm.callStack[f.depth-1]
+	caller := &Frame{
+		vm:      f.vm,
+		depth:   f.depth - 1,
+		program: ctx.program,
+		stash:   ctx.stash,
+		bp:      ctx.bp,
+	}
+	// the saved pc follows the call; it is negative if the frame was
+	// called from a native function, whose position is unknown
+	if ctx.pc > 0 {
+		caller.pos = ctx.program.sourcePos(ctx.pc - 1)
+	}
+	return caller
+}
+
+// Depth returns the number of frames below f.
+func (f *Frame) Depth() int {
+	return f.depth
+}
+
+// Name returns the name of the function of f: the name it is bound to by
+// a let statement, "<anonymous>" if there is none, or "<script>" for the
+// top level of a program.
+func (f *Frame) Name() string {
+	switch {
+	case !f.program.fn.IsValid():
+		return "<script>"
+	case f.program.name != "":
+		return f.program.name
+	}
+	return "<anonymous>"
+}
+
+// Position returns the position of the statement about to be executed in
+// the innermost frame, or of the call in the other frames.
+func (f *Frame) Position() syntax.Position {
+	if f.program.src == nil || !f.pos.IsValid() {
+		return syntax.Position{}
+	}
+	return f.program.src.Position(f.pos)
+}
+
+// A Variable is a named value, e.g. a local of a Frame.
+type Variable struct {
+	Name  string
+	Value Value
+}
+
+// Locals returns the local names visible in f sorted by name, including
+// those of the enclosing functions. A name declared later in its block
+// is null.
+func (f *Frame) Locals() []Variable {
+	var vars []Variable
+	seen := make(map[string]bool)
+	add := func(names map[string]uint32, get func(idx uint32) Value) {
+		for name, idx := range names {
+			if !seen[name] {
+				seen[name] = true
+				vars = append(vars, Variable{Name: name, Value: get(idx)})
+			}
+		}
+	}
+
+	s := f.stash
+	if names := f.program.stackNames; names != nil {
+		// the locals of a function without a stash live on the stack,
+		// outside the stashes of its blocks
+		for ; s != nil && f.program.ownsNames(s.names); s = s.outer {
+			add(s.names, s.getByIdx)
+		}
+		add(names, func(idx uint32) Value {
+			return f.vm.stack.l[f.bp+int(idx)]
+		})
+	}
+	for ; s != nil; s = s.outer {
+		add(s.names, s.getByIdx)
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+	return vars
+}
+
+// ownsNames reports whether names are those of a stash created by p.
+func (p *Program) ownsNames(names map[string]uint32) bool {
+	if names == nil {
+		return false
+	}
+	ptr := reflect.ValueOf(names).Pointer()
+	for _, n := range p.stashNames {
+		if reflect.ValueOf(n).Pointer() == ptr {
+			return true
+		}
+	}
+	return false
+}
+
+// Globals returns the globals that the running program refers to sorted by
+// name, except for the built-in functions.
+func (f *Frame) Globals() []Variable {
+	root := f.vm.program
+	if len(f.vm.callStack) > 0 {
+		root = f.vm.callStack[0].program
+	}
+	scope := unref(f.vm.globals).(*globalScope)
+	var vars []Variable
+	seen := make(map[string]bool)
+	for _, ref := range root.references {
+		name := ref.Name
+		if _, ok := builtInFunctions[name]; ok || name == "strings" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if v, ok := scope.lookup(name); ok {
+			vars = append(vars, Variable{Name: name, Value: v})
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+	return vars
+}
+
+// Lookup returns the value of the local or global name visible in f, or
+// of a member of it selected by a path of keys separated by dots, e.g.
+// user.roles.0, see Members.
+func (f *Frame) Lookup(path string) (Value, bool) {
+	keys := strings.Split(path, ".")
+	v, ok := f.lookupName(keys[0])
+	for _, key := range keys[1:] {
+		if !ok {
+			break
+		}
+		ok = false
+		for _, member := range Members(v) {
+			if member.Name == key {
+				v, ok = member.Value, true
+				break
+			}
+		}
+	}
+	return v, ok
+}
+
+func (f *Frame) lookupName(name string) (Value, bool) {
+	for _, v := range f.Locals() {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+	return unref(f.vm.globals).(*globalScope).lookup(name)
+}
+
+// StepMode says how a program stopped by a Debugger resumes.
+type StepMode int
+
+const (
+	// Continue runs the program until it reaches a breakpoint.
+	Continue StepMode = iota
+	// StepIn stops at the next statement, which may be in a function
+	// called by the current one.
+	StepIn
+	// StepOver stops at the next statement of the current function or of
+	// its callers.
+	StepOver
+	// StepOut stops at the next statement of the callers of the current
+	// function.
+	StepOut
+)
+
+// StopReason says why a Debugger stopped a program.
+type StopReason int
+
+const (
+	StopBreakpoint StopReason = iota
+	StopStep
+	StopPause
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step"
+	case StopPause:
+		return "pause"
+	}
+	return "StopReason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// A Debugger is a DebugHook that stops a program at line breakpoints,
+// after steps and when paused. The program resumes as told by the stop
+// function passed to NewDebugger. The methods of a Debugger may be called
+// from other goroutines while the program runs.
+type Debugger struct {
+	stop func(reason StopReason, f *Frame) StepMode
+
+	mu          sync.Mutex
+	breakpoints map[string]map[int]bool
+	pause       bool
+
+	// mode is the step in progress and depth the depth of the frame where
+	// it started.
+	mode  StepMode
+	depth int
+
+	// file, line and lineDepth locate the previous statement, so that a
+	// breakpoint stops once each time its line is reached.
+	file      string
+	line      int
+	lineDepth int
+}
+
+// NewDebugger returns a Debugger that calls stop when the program stops.
+// The program waits for stop to return and then resumes as it tells.
+func NewDebugger(stop func(reason StopReason, f *Frame) StepMode) *Debugger {
+	return &Debugger{
+		stop:        stop,
+		breakpoints: make(map[string]map[int]bool),
+	}
+}
+
+// SetBreakpoint sets a breakpoint at a line of the file, which is
+// identified by the filename passed to CompileFile.
+func (d *Debugger) SetBreakpoint(filename string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := d.breakpoints[filename]
+	if lines == nil {
+		lines = make(map[int]bool)
+		d.breakpoints[filename] = lines
+	}
+	lines[line] = true
+}
+
+// ClearBreakpoint removes the breakpoint at a line of the file.
+func (d *Debugger) ClearBreakpoint(filename string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.breakpoints[filename], line)
+}
+
+// ClearBreakpoints removes the breakpoints of the file.
+func (d *Debugger) ClearBreakpoints(filename string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.breakpoints, filename)
+}
+
+// Breakpoints returns the lines of the breakpoints of the file in
+// increasing order.
+func (d *Debugger) Breakpoints(filename string) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var lines []int
+	for line := range d.breakpoints[filename] {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Pause stops the program at the next statement.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pause = true
+}
+
+// Statement implements DebugHook.
+func (d *Debugger) Statement(f *Frame) {
+	pos := f.Position()
+	depth := f.Depth()
+
+	d.mu.Lock()
+	stop, reason := true, StopStep
+	switch {
+	case d.pause:
+		d.pause = false
+		reason = StopPause
+	case d.mode == StepIn,
+		d.mode == StepOver && depth <= d.depth,
+		d.mode == StepOut && depth < d.depth:
+	case d.breakpoints[pos.Filename][pos.Line] &&
+		(pos.Filename != d.file || pos.Line != d.line || depth != d.lineDepth):
+		reason = StopBreakpoint
+	default:
+		stop = false
+	}
+	d.file, d.line, d.lineDepth = pos.Filename, pos.Line, depth
+	d.mu.Unlock()
+	if !stop {
+		return
+	}
+
+	mode := d.stop(reason, f)
+	d.mu.Lock()
+	d.mode, d.depth = mode, depth
+	d.mu.Unlock()
+}
+
+const (
+	inspectDepth = 3
+	inspectLen   = 20
+)
+
+// Inspect returns a description of v for debugging in the syntax of
+// literals, e.g. ["a", 1] for an array. Lazy arrays and sequences are not
+// evaluated, and large or deeply nested values are abbreviated.
+func Inspect(v Value) string {
+	var b strings.Builder
+	inspect(&b, v, 0)
+	return b.String()
+}
+
+func inspect(b *strings.Builder, v Value, depth int) {
+	switch v := v.(type) {
+	case String:
+		b.WriteString(strconv.Quote(string(v)))
+	case Decimal:
+		b.WriteString(v.ToString() + "d")
+	case Array:
+		if depth >= inspectDepth && len(v.values) > 0 {
+			b.WriteString("[...]")
+			return
+		}
+		b.WriteString("[")
+		for i, elem := range v.values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if i == inspectLen {
+				b.WriteString("...")
+				break
+			}
+			inspect(b, elem, depth+1)
+		}
+		b.WriteString("]")
+	case *lazyArray:
+		b.WriteString("[...]")
+	case *readOnlyValue:
+		inspect(b, v.v, depth)
+	case Map:
+		if depth >= inspectDepth && len(v) > 0 {
+			b.WriteString("{...}")
+			return
+		}
+		b.WriteString("{")
+		for i, key := range sortedKeys(v) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if i == inspectLen {
+				b.WriteString("...")
+				break
+			}
+			b.WriteString(inspectKey(key) + ": ")
+			inspect(b, v[key], depth+1)
+		}
+		b.WriteString("}")
+	case *literalFunction:
+		b.WriteString("function")
+		if v.program.name != "" {
+			b.WriteString(" " + v.program.name)
+		}
+	default:
+		switch t := Type(v); t {
+		case "null":
+			b.WriteString("null")
+		case "bool", "number":
+			b.WriteString(v.ToString())
+		case "":
+			b.WriteString("<value>")
+		default:
+			b.WriteString("<" + t + ">")
+		}
+	}
+}
+
+// inspectKey returns key as written in a map literal.
+func inspectKey(key string) string {
+	for i, r := range key {
+		if !(r == '_' || r == '$' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+// Members returns the elements of an array, named by their indexes, or the
+// entries of a map sorted by key. It returns nil for other values.
+func Members(v Value) []Variable {
+	var vars []Variable
+	switch v := underlying(v).(type) {
+	case Array:
+		for i, elem := range v.values {
+			vars = append(vars, Variable{Name: strconv.Itoa(i), Value: elem})
+		}
+	case Map:
+		for _, key := range sortedKeys(v) {
+			vars = append(vars, Variable{Name: key, Value: v[key]})
+		}
+	}
+	return vars
+}
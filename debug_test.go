@@ -0,0 +1,230 @@
+package gates
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const debugScript = `let total = 0;
+let add = function (a, b) {
+  let sum = a + b;
+  return sum;
+};
+for (let i = 0; i < 2; i = i + 1) {
+  total = add(total, i);
+}
+[total, rate] | map((x) => x * 2)
+`
+
+// debug runs debugScript and returns a line for each stop, with the locals
+// of the innermost frame and the positions of the callers.
+func debug(t *testing.T, setup func(d *Debugger), resume func(n int, f *Frame) StepMode) []string {
+	program, err := CompileScript("debug.gates", debugScript)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	r := New()
+	r.Global().Set("rate", Int(3))
+	var stops []string
+	d := NewDebugger(func(reason StopReason, f *Frame) StepMode {
+		pos := f.Position()
+		stop := fmt.Sprintf("%s %d:%d %s", reason, pos.Line, pos.Column, f.Name())
+		for _, v := range f.Locals() {
+			stop += fmt.Sprintf(" %s=%s", v.Name, Inspect(v.Value))
+		}
+		for caller := f.Caller(); caller != nil; caller = caller.Caller() {
+			stop += fmt.Sprintf(" <- %s %s", caller.Name(), caller.Position())
+		}
+		stops = append(stops, stop)
+		return resume(len(stops), f)
+	})
+	setup(d)
+	r.SetDebugHook(d)
+	v, err := r.RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.Equal(t, "[2, 6]", Inspect(v))
+	return stops
+}
+
+func TestDebuggerStepIn(t *testing.T) {
+	stops := debug(t, func(d *Debugger) { d.Pause() }, func(int, *Frame) StepMode { return StepIn })
+	assert.Equal(t, []string{
+		"pause 1:1 <script> add=null total=null",
+		"step 2:1 <script> add=null total=0",
+		"step 6:1 <script> add=function add total=0",
+		"step 6:6 <script> add=function add i=null total=0",
+		"step 7:3 <script> add=function add i=0 total=0",
+		"step 3:3 add a=0 add=function add b=0 sum=null total=0 <- <script> debug.gates:7:14",
+		"step 4:3 add a=0 add=function add b=0 sum=0 total=0 <- <script> debug.gates:7:14",
+		"step 6:24 <script> add=function add i=0 total=0",
+		"step 7:3 <script> add=function add i=1 total=0",
+		"step 3:3 add a=0 add=function add b=1 sum=null total=0 <- <script> debug.gates:7:14",
+		"step 4:3 add a=0 add=function add b=1 sum=1 total=0 <- <script> debug.gates:7:14",
+		"step 6:24 <script> add=function add i=1 total=1",
+		"step 9:1 <script> add=function add total=1",
+		// the callback is called by map, a native function
+		"step 9:28 <anonymous> add=function add total=1 x=1 <- <script> -",
+		"step 9:28 <anonymous> add=function add total=1 x=3 <- <script> -",
+	}, stops)
+}
+
+func TestDebuggerStepOverAndOut(t *testing.T) {
+	stops := debug(t, func(d *Debugger) { d.Pause() }, func(n int, f *Frame) StepMode {
+		if n == 5 {
+			return StepIn
+		}
+		if f.Name() == "add" {
+			return StepOut
+		}
+		return StepOver
+	})
+	assert.Equal(t, []string{
+		"pause 1:1 <script> add=null total=null",
+		"step 2:1 <script> add=null total=0",
+		"step 6:1 <script> add=function add total=0",
+		"step 6:6 <script> add=function add i=null total=0",
+		"step 7:3 <script> add=function add i=0 total=0",
+		"step 3:3 add a=0 add=function add b=0 sum=null total=0 <- <script> debug.gates:7:14",
+		"step 6:24 <script> add=function add i=0 total=0",
+		"step 7:3 <script> add=function add i=1 total=0",
+		"step 6:24 <script> add=function add i=1 total=1",
+		"step 9:1 <script> add=function add total=1",
+	}, stops)
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	stops := debug(t, func(d *Debugger) {
+		d.SetBreakpoint("debug.gates", 4)
+		d.SetBreakpoint("debug.gates", 9)
+		d.SetBreakpoint("debug.gates", 10)
+		d.SetBreakpoint("other.gates", 1)
+		d.ClearBreakpoint("debug.gates", 10)
+		assert.Equal(t, []int{4, 9}, d.Breakpoints("debug.gates"))
+	}, func(n int, f *Frame) StepMode {
+		if n == 1 {
+			v, ok := f.Lookup("sum")
+			assert.True(t, ok)
+			assert.Equal(t, Int(0), v)
+			v, ok = f.Lookup("rate")
+			assert.True(t, ok)
+			assert.Equal(t, Int(3), v)
+			_, ok = f.Lookup("i")
+			assert.False(t, ok)
+			_, ok = f.Lookup("sum.x")
+			assert.False(t, ok)
+			assert.Equal(t, []Variable{{Name: "rate", Value: Int(3)}}, f.Globals())
+		}
+		return Continue
+	})
+	assert.Equal(t, []string{
+		"breakpoint 4:3 add a=0 add=function add b=0 sum=0 total=0 <- <script> debug.gates:7:14",
+		"breakpoint 4:3 add a=0 add=function add b=1 sum=1 total=0 <- <script> debug.gates:7:14",
+		"breakpoint 9:1 <script> add=function add total=1",
+		// a breakpoint stops once in each frame reaching its line
+		"breakpoint 9:28 <anonymous> add=function add total=1 x=1 <- <script> -",
+	}, stops)
+}
+
+func TestStatementLines(t *testing.T) {
+	program, err := CompileScript("debug.gates", debugScript)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 6, 7, 9}, program.StatementLines())
+
+	program, err = CompileScript("folded.gates", "let x = 1;\nif (false) {\n  x = 2;\n}\n\nx")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 6}, program.StatementLines())
+}
+
+func TestDebuggerLocals(t *testing.T) {
+	program, err := CompileScript("locals.gates", `let x = 1;
+let f = function (y) {
+  if (y) {
+    let x = 2;
+    let g = () => x + y;
+    return g();
+  }
+};
+let h = function (x) {
+  if (x) {
+    let x = 4;
+    return x;
+  }
+};
+let m = { a: [1, { b: 2 }] };
+f(3) + h(m.a[0])
+`)
+	assert.NoError(t, err)
+	var locals [][]Variable
+	d := NewDebugger(func(reason StopReason, f *Frame) StepMode {
+		v, ok := f.Lookup("m.a.1.b")
+		assert.True(t, ok)
+		assert.Equal(t, Int(2), v)
+		for ; f != nil; f = f.Caller() {
+			locals = append(locals, f.Locals())
+		}
+		return Continue
+	})
+	d.SetBreakpoint("locals.gates", 6)
+	d.SetBreakpoint("locals.gates", 12)
+	r := New()
+	r.SetDebugHook(d)
+	v, err := r.RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(9), v)
+	// the inner x shadows the outer one, also where the parameter of h
+	// lives on the stack
+	m := Map{"a": NewArray([]Value{Int(1), Map{"b": Int(2)}})}
+	assert.Equal(t, [][]Variable{
+		{{"f", &literalFunction{}}, {"g", &literalFunction{}}, {"h", &literalFunction{}}, {"m", m}, {"x", Int(2)}, {"y", Int(3)}},
+		{{"f", &literalFunction{}}, {"h", &literalFunction{}}, {"m", m}, {"x", Int(1)}},
+		{{"f", &literalFunction{}}, {"h", &literalFunction{}}, {"m", m}, {"x", Int(4)}},
+		{{"f", &literalFunction{}}, {"h", &literalFunction{}}, {"m", m}, {"x", Int(1)}},
+	}, mapFunctions(locals))
+}
+
+// mapFunctions replaces the functions in locals by empty ones, so that
+// they compare equal.
+func mapFunctions(locals [][]Variable) [][]Variable {
+	for _, vars := range locals {
+		for i, v := range vars {
+			if _, ok := v.Value.(*literalFunction); ok {
+				vars[i].Value = &literalFunction{}
+			}
+		}
+	}
+	return locals
+}
+
+func TestInspect(t *testing.T) {
+	nested := NewArray([]Value{NewArray([]Value{NewArray([]Value{NewArray([]Value{Int(1)})})})})
+	long := make([]Value, 25)
+	for i := range long {
+		long[i] = Int(int64(i))
+	}
+	for _, test := range []struct {
+		v    Value
+		want string
+	}{
+		{Null, "null"},
+		{True, "true"},
+		{Float(1.5), "1.5"},
+		{Decimal{r: big.NewRat(3, 2)}, "1.5d"},
+		{String("a\"b\n"), `"a\"b\n"`},
+		{NewArray([]Value{Int(1), String("x"), Null}), `[1, "x", null]`},
+		{Map{"b": Int(2), "a b": Map{}, "_1": NewArray(nil)}, `{_1: [], "a b": {}, b: 2}`},
+		{nested, "[[[[...]]]]"},
+		{NewArray(long), "[0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, ...]"},
+		{builtInFunctions["map"], "<function>"},
+		{&literalFunction{program: &Program{name: "f"}}, "function f"},
+	} {
+		assert.Equal(t, test.want, Inspect(test.v))
+	}
+
+	assert.Equal(t, []Variable{{"0", Int(1)}, {"1", Int(2)}}, Members(NewArray([]Value{Int(1), Int(2)})))
+	assert.Equal(t, []Variable{{"a", Int(1)}, {"b", Int(2)}}, Members(Map{"b": Int(2), "a": Int(1)}))
+	assert.Nil(t, Members(Int(1)))
+}
@@ -0,0 +1,259 @@
+package gates
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an arbitrary-precision decimal number, written in scripts
+// with a d suffix, e.g. 19.99d. Addition, subtraction, multiplication and
+// remainders of decimals are exact; quotients are rounded as configured
+// by Runtime.SetDecimalRounding. An operation mixing a decimal with an int
+// or a finite float promotes the other operand to a decimal, a float by
+// its shortest representation, so that 0.1d + 0.2 is 0.3d. A NaN or an
+// infinite float turns the operation into a float one.
+type Decimal struct {
+	r *big.Rat
+}
+
+// A RoundingMode determines how a decimal quotient is rounded.
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds to the nearest neighbor, and ties to the even one.
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds to the nearest neighbor, and ties away from zero.
+	RoundHalfUp
+	// RoundDown rounds toward zero.
+	RoundDown
+	// RoundUp rounds away from zero.
+	RoundUp
+	// RoundFloor rounds toward negative infinity.
+	RoundFloor
+	// RoundCeiling rounds toward positive infinity.
+	RoundCeiling
+)
+
+// DefaultDecimalScale is the default number of digits after the decimal
+// point kept in decimal quotients.
+const DefaultDecimalScale = 16
+
+// maxDecimalExponent bounds the exponents of the decimals parsed, which
+// would otherwise take memory growing with the exponent, e.g. 1e999999d.
+const maxDecimalExponent = 1000
+
+// NewDecimal returns a Decimal with the value of x.
+func NewDecimal(x *big.Rat) Decimal {
+	return Decimal{r: new(big.Rat).Set(x)}
+}
+
+// ParseDecimal parses a decimal number such as "-12.5" or "1e3". Other
+// notations, such as fractions or hexadecimal numbers, are rejected, as
+// are exponents beyond 1000 in absolute value.
+func ParseDecimal(s string) (Decimal, bool) {
+	if strings.Trim(s, "+-.0123456789eE") != "" {
+		return Decimal{}, false
+	}
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil || exp > maxDecimalExponent || exp < -maxDecimalExponent {
+			return Decimal{}, false
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, false
+	}
+	return Decimal{r: r}, true
+}
+
+func (Decimal) number() {}
+
+func (Decimal) Type() string { return "decimal" }
+
+func (Decimal) IsString() bool   { return false }
+func (Decimal) IsInt() bool      { return false }
+func (Decimal) IsFloat() bool    { return false }
+func (Decimal) IsBool() bool     { return false }
+func (Decimal) IsFunction() bool { return false }
+
+func (d Decimal) rat() *big.Rat {
+	if d.r == nil {
+		return new(big.Rat)
+	}
+	return d.r
+}
+
+func (d Decimal) ToString() string {
+	r := d.rat()
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	// a finite decimal fraction has a denominator of the form 2^a * 5^b
+	// and needs max(a, b) digits after the decimal point
+	den := new(big.Int).Set(r.Denom())
+	two, five := big.NewInt(2), big.NewInt(5)
+	m := new(big.Int)
+	digits := 0
+	for a, b := 0, 0; ; {
+		if m.Mod(den, two).Sign() == 0 {
+			den.Quo(den, two)
+			a++
+		} else if m.Mod(den, five).Sign() == 0 {
+			den.Quo(den, five)
+			b++
+		} else {
+			if den.Cmp(big.NewInt(1)) != 0 {
+				return r.FloatString(DefaultDecimalScale)
+			}
+			digits = a
+			if b > digits {
+				digits = b
+			}
+			break
+		}
+	}
+	return r.FloatString(digits)
+}
+
+func (d Decimal) ToInt() int64 {
+	r := d.rat()
+	return new(big.Int).Quo(r.Num(), r.Denom()).Int64()
+}
+
+func (d Decimal) ToFloat() float64 {
+	f, _ := d.rat().Float64()
+	return f
+}
+
+func (d Decimal) ToNumber() Number   { return d }
+func (d Decimal) ToBool() bool       { return d.rat().Sign() != 0 }
+func (Decimal) ToFunction() Function { return _EmptyFunction }
+
+// ToNative returns the value as a *big.Rat, or as its string form if the
+// DecimalAsString option is given.
+func (d Decimal) ToNative(ops ...ToNativeOption) interface{} {
+	return d.toNative(nil, convertToNativeOption2BinaryOptions(ops))
+}
+
+func (d Decimal) toNative(seen map[interface{}]interface{}, options int) interface{} {
+	if checkToNativeOption(DecimalAsString, options) {
+		return d.ToString()
+	}
+	return new(big.Rat).Set(d.rat())
+}
+
+func (d Decimal) Equals(other Value) bool {
+	if o, ok := toDecimal(other); ok {
+		return d.rat().Cmp(o) == 0
+	}
+	switch {
+	case other.IsFloat():
+		return d.ToFloat() == other.ToFloat()
+	case other.IsString():
+		if o, ok := ParseDecimal(other.ToString()); ok {
+			return d.Equals(o)
+		}
+	case other.IsBool():
+		return d.Equals(Int(other.ToInt()))
+	}
+	return false
+}
+
+func (d Decimal) SameAs(other Value) bool {
+	o, ok := other.(Decimal)
+	return ok && d.rat().Cmp(o.rat()) == 0
+}
+
+// toDecimal converts an Int, a finite Float or a Decimal to a rational.
+func toDecimal(v Value) (*big.Rat, bool) {
+	switch v := v.(type) {
+	case Decimal:
+		return v.rat(), true
+	case Int:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case Float:
+		if d, ok := floatToDecimal(float64(v)); ok {
+			return d.rat(), true
+		}
+	}
+	return nil, false
+}
+
+// decimalOperands returns x and y as rationals if one of them is a Decimal
+// and the other one is an Int, a finite Float or a Decimal.
+func decimalOperands(x, y Value) (*big.Rat, *big.Rat, bool) {
+	_, xd := x.(Decimal)
+	_, yd := y.(Decimal)
+	if !xd && !yd {
+		return nil, nil, false
+	}
+	xr, ok := toDecimal(x)
+	if !ok {
+		return nil, nil, false
+	}
+	yr, ok := toDecimal(y)
+	if !ok {
+		return nil, nil, false
+	}
+	return xr, yr, true
+}
+
+// floatToDecimal converts f to the decimal of its shortest representation.
+func floatToDecimal(f float64) (Decimal, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Decimal{}, false
+	}
+	return ParseDecimal(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// remDecimal returns the remainder of x / y truncated toward zero, which
+// has the sign of x like the remainder of ints. y must not be zero.
+func remDecimal(x, y *big.Rat) *big.Rat {
+	q := new(big.Rat).Quo(x, y)
+	n := new(big.Int).Quo(q.Num(), q.Denom())
+	q.SetInt(n)
+	return q.Sub(x, q.Mul(q, y))
+}
+
+// roundDecimal rounds x to scale digits after the decimal point.
+func roundDecimal(x *big.Rat, scale int, mode RoundingMode) *big.Rat {
+	if scale < 0 {
+		scale = 0
+	}
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	num := new(big.Int).Mul(x.Num(), pow)
+	den := x.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if m.Sign() != 0 {
+		neg := num.Sign() < 0
+		half := new(big.Int).Abs(m)
+		half.Lsh(half, 1)
+		c := half.Cmp(den)
+		var away bool
+		switch mode {
+		case RoundHalfEven:
+			away = c > 0 || c == 0 && q.Bit(0) == 1
+		case RoundHalfUp:
+			away = c >= 0
+		case RoundDown:
+			away = false
+		case RoundUp:
+			away = true
+		case RoundFloor:
+			away = neg
+		case RoundCeiling:
+			away = !neg
+		}
+		if away {
+			if neg {
+				q.Sub(q, big.NewInt(1))
+			} else {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	return new(big.Rat).SetFrac(q, pow)
+}
@@ -0,0 +1,86 @@
+package gates
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecimal(t *testing.T) {
+	v := mustRunString(`[1.10d + 2, 0.1d * 0.1d]`)
+	x := v.ToNative().([]interface{})
+	assert.Equal(t, big.NewRat(31, 10), x[0])
+	assert.Equal(t, big.NewRat(1, 100), x[1])
+	assert.Equal(t, []interface{}{"3.1", "0.01"}, v.ToNative(DecimalAsString))
+
+	v = mustRunStringWithGlobal(`price * 2`, map[string]Value{
+		"price": ToValue(big.NewRat(1999, 100)),
+	})
+	assert.Equal(t, "39.98", v.ToString())
+	assert.EqualValues(t, 39, v.ToInt())
+	assert.Equal(t, 39.98, v.ToFloat())
+
+	_, err := Compile(`1.2.3d`)
+	assert.Error(t, err)
+
+	// d is a digit of hexadecimal literals
+	v = mustRunString(`[0x1d, 0xad, 0XDd, 0x1_d, 1_0d, 0o17, 0b11]`)
+	assert.Equal(t, []interface{}{int64(29), int64(173), int64(221), int64(29), big.NewRat(10, 1), int64(15), int64(3)}, v.ToNative())
+
+	for _, s := range []string{"1/3", "0x10", "1.5.", "", "abc", "1e"} {
+		_, ok := ParseDecimal(s)
+		assert.False(t, ok, s)
+	}
+	d, ok := ParseDecimal("-1.25e2")
+	assert.True(t, ok)
+	assert.Equal(t, "-125", d.ToString())
+	assert.Equal(t, Null, mustRunString(`decimal("1/3")`))
+
+	// exponents are bounded
+	for _, s := range []string{"1e1001", "1e-1001", "1e999999", "1e99999999999999999999"} {
+		_, ok := ParseDecimal(s)
+		assert.False(t, ok, s)
+	}
+	d, ok = ParseDecimal("1e1000")
+	assert.True(t, ok)
+	assert.Equal(t, 1001, len(d.ToString()))
+	_, err = Compile(`1e999999d`)
+	assert.Error(t, err)
+
+	// floats are promoted to decimals, and remainders are decimals
+	v = mustRunString(`[0.1d + 0.2 == 0.3, 0.1d + 0.2, 0.3 - 0.1d, 1.5 * 2d, 1d / 0.5, 0.1d < 0.2, 0.3d == 0.3, 7.5d % 2, -7.5d % 2, 7 % 2.5d, 0.3 % 0.1d]`)
+	assert.Equal(t, []interface{}{true, "0.3", "0.2", "3", "2", true, true, "1.5", "-1.5", "2", "0"}, v.ToNative(DecimalAsString))
+	for _, src := range []string{`0.1d + 0.2`, `5d % 3`, `1d * 1.5`} {
+		assert.Equal(t, "decimal", Type(mustRunString(src)), src)
+	}
+	// but NaN and infinities are not decimals
+	assert.Equal(t, "number", Type(mustRunString(`1d + 0 / 0`)))
+	assert.Equal(t, "number", Type(mustRunString(`1d % 0`)))
+}
+
+func TestDecimalRounding(t *testing.T) {
+	ctx := context.Background()
+	program, err := Compile(`[2d / 3, -2d / 3, 1d / 8, 3d / 8, -1d / 8]`)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		mode     RoundingMode
+		expected []interface{}
+	}{
+		{RoundHalfEven, []interface{}{"0.67", "-0.67", "0.12", "0.38", "-0.12"}},
+		{RoundHalfUp, []interface{}{"0.67", "-0.67", "0.13", "0.38", "-0.13"}},
+		{RoundDown, []interface{}{"0.66", "-0.66", "0.12", "0.37", "-0.12"}},
+		{RoundUp, []interface{}{"0.67", "-0.67", "0.13", "0.38", "-0.13"}},
+		{RoundFloor, []interface{}{"0.66", "-0.67", "0.12", "0.37", "-0.13"}},
+		{RoundCeiling, []interface{}{"0.67", "-0.66", "0.13", "0.38", "-0.12"}},
+	}
+	for _, test := range tests {
+		r := New()
+		r.SetDecimalRounding(2, test.mode)
+		v, err := r.RunProgram(ctx, program)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, v.ToNative(DecimalAsString), "mode %d", test.mode)
+	}
+}
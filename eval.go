@@ -0,0 +1,206 @@
+package gates
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// An Option configures the Runtime used by Eval and Program.Run.
+type Option func(*Runtime)
+
+// WithCyclesLimit limits the number of instructions a run may execute.
+func WithCyclesLimit(max int) Option {
+	return func(r *Runtime) {
+		r.SetCyclesLimit(max)
+	}
+}
+
+// WithDecimalRounding sets the rounding of decimal quotients.
+func WithDecimalRounding(scale int, mode RoundingMode) Option {
+	return func(r *Runtime) {
+		r.SetDecimalRounding(scale, mode)
+	}
+}
+
+// WithStrict enables the strict mode of the Runtime, in which scripts may
+// only assign to the globals in env and the given writable names.
+func WithStrict(writable ...string) Option {
+	return func(r *Runtime) {
+		r.SetStrict(true)
+		r.SetWritableGlobals(writable...)
+	}
+}
+
+// WithGlobal sets a global on the Runtime, e.g. a host library shared by
+// all runs.
+func WithGlobal(name string, value interface{}) Option {
+	return func(r *Runtime) {
+		r.Global().Set(name, ToValue(value))
+	}
+}
+
+// ErrResultType is returned by the typed evaluation helpers when the
+// result of a program does not have the requested type.
+type ErrResultType struct {
+	Expected string
+	Result   Value
+}
+
+func (e *ErrResultType) Error() string {
+	actual := Type(e.Result)
+	if actual == "" {
+		actual = fmt.Sprintf("%T", e.Result)
+	}
+	if e.Result.IsString() {
+		return fmt.Sprintf("%s result expected, got %s %q", e.Expected, actual, e.Result.ToString())
+	}
+	return fmt.Sprintf("%s result expected, got %s %s", e.Expected, actual, e.Result.ToString())
+}
+
+// Eval compiles the expression src and runs it with the variables in env.
+// The result is converted to its native Go form.
+func Eval(ctx context.Context, src string, env map[string]interface{}, opts ...Option) (interface{}, error) {
+	v, err := evalValue(ctx, src, env, opts)
+	if err != nil {
+		return nil, err
+	}
+	return v.ToNative(), nil
+}
+
+// EvalBool is like Eval but requires the result to be a bool.
+func EvalBool(ctx context.Context, src string, env map[string]interface{}, opts ...Option) (bool, error) {
+	v, err := evalValue(ctx, src, env, opts)
+	if err != nil {
+		return false, err
+	}
+	return resultBool(v)
+}
+
+// EvalInt is like Eval but requires the result to be an integer.
+func EvalInt(ctx context.Context, src string, env map[string]interface{}, opts ...Option) (int64, error) {
+	v, err := evalValue(ctx, src, env, opts)
+	if err != nil {
+		return 0, err
+	}
+	return resultInt(v)
+}
+
+// EvalFloat is like Eval but requires the result to be a number.
+func EvalFloat(ctx context.Context, src string, env map[string]interface{}, opts ...Option) (float64, error) {
+	v, err := evalValue(ctx, src, env, opts)
+	if err != nil {
+		return 0, err
+	}
+	return resultFloat(v)
+}
+
+// EvalString is like Eval but requires the result to be a string.
+func EvalString(ctx context.Context, src string, env map[string]interface{}, opts ...Option) (string, error) {
+	v, err := evalValue(ctx, src, env, opts)
+	if err != nil {
+		return "", err
+	}
+	return resultString(v)
+}
+
+func evalValue(ctx context.Context, src string, env map[string]interface{}, opts []Option) (Value, error) {
+	program, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return program.run(ctx, env, opts)
+}
+
+// Run runs the program with the variables in env and returns the result in
+// its native Go form. The variables are bound for this run only, so they
+// never leak from one run into another.
+func (p *Program) Run(ctx context.Context, env map[string]interface{}, opts ...Option) (interface{}, error) {
+	v, err := p.run(ctx, env, opts)
+	if err != nil {
+		return nil, err
+	}
+	return v.ToNative(), nil
+}
+
+// RunBool is like Run but requires the result to be a bool.
+func (p *Program) RunBool(ctx context.Context, env map[string]interface{}, opts ...Option) (bool, error) {
+	v, err := p.run(ctx, env, opts)
+	if err != nil {
+		return false, err
+	}
+	return resultBool(v)
+}
+
+// RunInt is like Run but requires the result to be an integer.
+func (p *Program) RunInt(ctx context.Context, env map[string]interface{}, opts ...Option) (int64, error) {
+	v, err := p.run(ctx, env, opts)
+	if err != nil {
+		return 0, err
+	}
+	return resultInt(v)
+}
+
+// RunFloat is like Run but requires the result to be a number.
+func (p *Program) RunFloat(ctx context.Context, env map[string]interface{}, opts ...Option) (float64, error) {
+	v, err := p.run(ctx, env, opts)
+	if err != nil {
+		return 0, err
+	}
+	return resultFloat(v)
+}
+
+// RunString is like Run but requires the result to be a string.
+func (p *Program) RunString(ctx context.Context, env map[string]interface{}, opts ...Option) (string, error) {
+	v, err := p.run(ctx, env, opts)
+	if err != nil {
+		return "", err
+	}
+	return resultString(v)
+}
+
+func (p *Program) run(ctx context.Context, env map[string]interface{}, opts []Option) (Value, error) {
+	r := New()
+	for _, opt := range opts {
+		opt(r)
+	}
+	bindings := NewGlobal()
+	for name, value := range env {
+		bindings.Set(name, ToValue(value))
+	}
+	return r.RunProgramWithBindings(ctx, p, bindings)
+}
+
+func resultBool(v Value) (bool, error) {
+	if !v.IsBool() {
+		return false, &ErrResultType{Expected: "bool", Result: v}
+	}
+	return v.ToBool(), nil
+}
+
+func resultInt(v Value) (int64, error) {
+	switch {
+	case v.IsInt():
+		return v.ToInt(), nil
+	case v.IsFloat():
+		f := v.ToFloat()
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), nil
+		}
+	}
+	return 0, &ErrResultType{Expected: "int", Result: v}
+}
+
+func resultFloat(v Value) (float64, error) {
+	if !v.IsInt() && !v.IsFloat() {
+		return 0, &ErrResultType{Expected: "number", Result: v}
+	}
+	return v.ToFloat(), nil
+}
+
+func resultString(v Value) (string, error) {
+	if !v.IsString() {
+		return "", &ErrResultType{Expected: "string", Result: v}
+	}
+	return v.ToString(), nil
+}
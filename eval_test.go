@@ -0,0 +1,66 @@
+package gates
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEval(t *testing.T) {
+	ctx := context.Background()
+	env := map[string]interface{}{
+		"user": map[string]interface{}{
+			"country": "NZ",
+			"age":     42,
+		},
+		"tags": []interface{}{"a", "b"},
+	}
+
+	v, err := Eval(ctx, `[user.country, tags[-1]]`, env)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"NZ", "b"}, v)
+
+	b, err := EvalBool(ctx, `user.age >= 18 && user.country == "NZ"`, env)
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	i, err := EvalInt(ctx, `user.age * 2 / 2`, env)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 42, i)
+
+	s, err := EvalString(ctx, `strings.to_lower(user.country)`, env)
+	assert.NoError(t, err)
+	assert.Equal(t, "nz", s)
+
+	_, err = EvalBool(ctx, `user.country`, env)
+	assert.EqualError(t, err, `bool result expected, got string "NZ"`)
+
+	_, err = EvalInt(ctx, `1.5`, nil)
+	assert.EqualError(t, err, "int result expected, got number 1.5")
+
+	_, err = EvalInt(ctx, `(`, nil)
+	assert.Error(t, err)
+
+	_, err = Eval(ctx, `(f => f(f))(f => f(f))`, nil, WithCyclesLimit(100))
+	assert.Error(t, err)
+}
+
+func TestProgramRun(t *testing.T) {
+	ctx := context.Background()
+	program, err := Compile(`x + 1`)
+	assert.NoError(t, err)
+
+	i, err := program.RunInt(ctx, map[string]interface{}{"x": 41})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 42, i)
+
+	// variables are bound per run
+	i, err = program.RunInt(ctx, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, i)
+
+	v, err := program.Run(ctx, nil, WithGlobal("x", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), v)
+}
@@ -0,0 +1,195 @@
+package gates
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrExecutionNested is returned when an Execution is run from a native
+// function while the Runtime is running a program.
+var ErrExecutionNested = errors.New("execution run inside a running program")
+
+// An Execution is a run of a program that can be paused after a number of
+// cycles and resumed later, possibly in another process, see Snapshot.
+// While paused, the Runtime is free to run other programs.
+type Execution struct {
+	r        *Runtime
+	program  *Program
+	bindings *Global
+	globals  Value
+
+	// top is the stash holding the top-level bindings of a script.
+	top   *stash
+	state execState
+
+	// pending is the result the execution is suspended on.
+	pending *Pending
+
+	done   bool
+	result Value
+	err    error
+}
+
+// execState holds the registers of a paused Execution.
+type execState struct {
+	program         *Program
+	stash           *stash
+	pc, bp          int
+	stack           []Value
+	callStack       []ctx
+	remainingCycles int
+}
+
+// NewExecution prepares program to run on r. If bindings is not nil, it is
+// layered over the Runtime's Global as in RunProgramWithBindings.
+func (r *Runtime) NewExecution(program *Program, bindings *Global) *Execution {
+	e := &Execution{
+		r:        r,
+		program:  program,
+		bindings: bindings,
+		globals:  r.vm.globals,
+	}
+	if bindings != nil {
+		e.globals = ref(&globalScope{base: r.global, bindings: bindings})
+	}
+	if program.script {
+		e.top = &stash{names: program.bindings}
+	}
+	e.state = execState{
+		program:         program,
+		stash:           e.top,
+		remainingCycles: r.vm.cyclesLimit,
+	}
+	return e
+}
+
+// Run runs the execution for at most cycles instructions, or until it
+// completes if cycles is 0. It reports whether the program completed; if
+// not, Run may be called again to resume it. The cycles limit of the
+// Runtime applies to the execution as a whole.
+//
+// Instructions executed by script functions called from native functions
+// count towards cycles, but the execution only pauses once they return.
+//
+// Run also returns early when the program calls a native function that
+// returns a Pending, see Pending. A later Run waits until it is settled.
+func (e *Execution) Run(ctx context.Context, cycles int) (done bool, err error) {
+	if e.done {
+		return true, e.err
+	}
+	vm := e.r.vm
+	if vm.depth != 0 {
+		return false, ErrExecutionNested
+	}
+
+	var rejected error
+	if p := e.pending; p != nil {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		e.pending = nil
+		if p.err != nil {
+			rejected = p.err
+		} else {
+			e.state.stack[len(e.state.stack)-1] = p.value
+		}
+	}
+
+	globals := vm.globals
+	vm.globals = e.globals
+	defer func() {
+		vm.globals = globals
+		vm.sliced = false
+		vm.async = false
+		vm.suspended = nil
+	}()
+	e.load(vm)
+	vm.ctx = ctx
+	vm.sliced = cycles > 0
+	vm.sliceRemaining = cycles
+	vm.async = true
+
+	if rejected != nil {
+		// report the error at the call
+		vm.pc--
+		err = rejected
+	} else {
+		err = vm.run()
+	}
+	switch {
+	case err == nil && vm.suspended != nil:
+		e.pending = vm.suspended
+		e.save(vm)
+		return false, nil
+	case err == nil:
+		e.done = true
+		e.result = vm.stack.Pop()
+	case err == errYield:
+		e.save(vm)
+		return false, nil
+	default:
+		e.done = true
+		if _, ok := err.(*RuntimeError); !ok {
+			err = vm.newRuntimeError(err)
+		}
+		e.err = err
+	}
+	e.state = execState{}
+	vm.init()
+	return true, e.err
+}
+
+func (e *Execution) load(vm *vm) {
+	vm.init()
+	for _, v := range e.state.stack {
+		vm.stack.Push(v)
+	}
+	vm.callStack = append(vm.callStack, e.state.callStack...)
+	vm.program = e.state.program
+	vm.stash = e.state.stash
+	vm.pc = e.state.pc
+	vm.bp = e.state.bp
+	vm.remainingCycles = e.state.remainingCycles
+}
+
+func (e *Execution) save(vm *vm) {
+	e.state = execState{
+		program:         vm.program,
+		stash:           vm.stash,
+		pc:              vm.pc,
+		bp:              vm.bp,
+		stack:           append([]Value(nil), vm.stack.l[:vm.stack.sp]...),
+		callStack:       append([]ctx(nil), vm.callStack...),
+		remainingCycles: vm.remainingCycles,
+	}
+	vm.init()
+}
+
+// Pending returns the result of a native function the execution is
+// suspended on, or nil. The host settles it, possibly from another
+// goroutine, and calls Run to resume the execution.
+func (e *Execution) Pending() *Pending { return e.pending }
+
+// Done reports whether the execution has completed.
+func (e *Execution) Done() bool { return e.done }
+
+// Result returns the result of a completed execution, or nil.
+func (e *Execution) Result() Value { return e.result }
+
+// Err returns the error that made the execution fail, if any.
+func (e *Execution) Err() error { return e.err }
+
+// Bindings returns the values of the top-level let bindings of a script
+// execution, as in RunScript.
+func (e *Execution) Bindings() Map {
+	bindings := make(Map, len(e.program.bindings))
+	if e.top == nil {
+		return bindings
+	}
+	for name, idx := range e.program.bindings {
+		bindings[name] = e.top.getByIdx(idx)
+	}
+	return bindings
+}
@@ -0,0 +1,190 @@
+package gates
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const executionSrc = `
+let fib = n => {
+  if (n == 0 || n == 1) {
+    return 1;
+  }
+  return fib(n - 1) + fib(n - 2);
+};
+
+let steps = [];
+for (let i = 0; i < 10; i = i + 1) {
+  steps = [...steps, { i: i, fib: fib(i) }];
+}
+strings.join(map(s => s.fib, steps), ",")
+`
+
+func TestExecution(t *testing.T) {
+	ctx := context.Background()
+	program, err := CompileScript("fib.gates", executionSrc)
+	assert.NoError(t, err)
+
+	e := New().NewExecution(program, nil)
+	runs := 0
+	for {
+		runs++
+		done, err := e.Run(ctx, 100)
+		assert.NoError(t, err)
+		if done {
+			break
+		}
+	}
+	assert.True(t, runs > 10)
+	assert.True(t, e.Done())
+	assert.Equal(t, "1,1,2,3,5,8,13,21,34,55", e.Result().ToString())
+	assert.EqualValues(t, 10, e.Bindings()["steps"].(Array).Length(nil))
+
+	// another program may run on the Runtime while an execution is paused
+	r := New()
+	e = r.NewExecution(program, nil)
+	done, err := e.Run(ctx, 100)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	v, err := r.RunString(`1 + 1`)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, v.ToInt())
+	done, err = e.Run(ctx, 0)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "1,1,2,3,5,8,13,21,34,55", e.Result().ToString())
+}
+
+func TestExecutionCyclesLimit(t *testing.T) {
+	program, err := CompileScript("fib.gates", executionSrc)
+	assert.NoError(t, err)
+
+	r := New()
+	r.SetCyclesLimit(500)
+	e := r.NewExecution(program, nil)
+	for {
+		done, err := e.Run(context.Background(), 100)
+		if done {
+			assert.Equal(t, ErrCyclesLimitExceeded, cause(err))
+			break
+		}
+		assert.NoError(t, err)
+	}
+}
+
+func TestExecutionSnapshot(t *testing.T) {
+	ctx := context.Background()
+	program, err := CompileScript("fib.gates", executionSrc)
+	assert.NoError(t, err)
+
+	bindings := NewGlobal()
+	e := New().NewExecution(program, bindings)
+	done, err := e.Run(ctx, 1000)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	snapshot, err := e.Snapshot()
+	assert.NoError(t, err)
+
+	// restore in a fresh Runtime from a program compiled again
+	program, err = CompileScript("fib.gates", executionSrc)
+	assert.NoError(t, err)
+	restored, err := New().RestoreExecution(program, nil, snapshot)
+	assert.NoError(t, err)
+	done, err = restored.Run(ctx, 0)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "1,1,2,3,5,8,13,21,34,55", restored.Result().ToString())
+
+	// the original execution is unaffected
+	done, err = e.Run(ctx, 0)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "1,1,2,3,5,8,13,21,34,55", e.Result().ToString())
+
+	other, err := CompileScript("other.gates", `1 + 1`)
+	assert.NoError(t, err)
+	_, err = New().RestoreExecution(other, nil, snapshot)
+	assert.Equal(t, ErrSnapshotMismatch, err)
+
+	host, err := CompileScript("host.gates", `let h = host; let x = 1; x + 1`)
+	assert.NoError(t, err)
+	r := New()
+	r.Global().Set("host", ToValue(struct{}{}))
+	e = r.NewExecution(host, nil)
+	_, err = e.Run(ctx, 3)
+	assert.NoError(t, err)
+	_, err = e.Snapshot()
+	assert.EqualError(t, err, "cannot snapshot struct {} value")
+}
+
+func TestExecutionSnapshotArrays(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		src  string
+		want int64
+	}{
+		// an array shared by two variables stays shared
+		{`let a = [0]; let b = a; let n = 0;
+for (let i = 0; i < 20; i = i + 1) { n = n + 1; }
+a[0] = 7;
+b[0]`, 7},
+		// an array containing itself
+		{`let a = [0, 5]; a[0] = a; let n = 0;
+for (let i = 0; i < 20; i = i + 1) { n = n + 1; }
+a[0][1] = 6;
+a[0][0][0][1]`, 6},
+	} {
+		program, err := CompileScript("arrays.gates", test.src)
+		assert.NoError(t, err)
+		e := New().NewExecution(program, nil)
+		done, err := e.Run(ctx, 30)
+		assert.NoError(t, err)
+		assert.False(t, done)
+		snapshot, err := e.Snapshot()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		restored, err := New().RestoreExecution(program, nil, snapshot)
+		assert.NoError(t, err)
+		done, err = restored.Run(ctx, 0)
+		assert.NoError(t, err)
+		assert.True(t, done)
+		assert.Equal(t, Int(test.want), restored.Result())
+	}
+}
+
+func TestExecutionSnapshotLazy(t *testing.T) {
+	ctx := context.Background()
+	program, err := CompileScript("lazy.gates", `let n = ([1, 2, 3] | map(x => tick(x)))[1]; n`)
+	assert.NoError(t, err)
+
+	calls := 0
+	r := New()
+	r.Global().Set("tick", FunctionFunc(func(fc FunctionCall) Value {
+		calls++
+		return fc.Args()[0]
+	}))
+	unsupported := false
+	for cycles := 1; ; cycles++ {
+		e := r.NewExecution(program, nil)
+		done, err := e.Run(ctx, cycles)
+		assert.NoError(t, err)
+		if done {
+			assert.Equal(t, Int(2), e.Result())
+			break
+		}
+		// the callbacks of a pending map are not run by Snapshot
+		before := calls
+		_, err = e.Snapshot()
+		assert.Equal(t, before, calls)
+		if err, ok := err.(*ErrSnapshotUnsupported); ok {
+			if _, ok := err.v.(*lazyArray); ok {
+				assert.EqualError(t, err, "cannot snapshot array while its elements are computed")
+				unsupported = true
+			}
+		}
+	}
+	assert.True(t, unsupported)
+}
@@ -0,0 +1,214 @@
+package gates
+
+import (
+	"context"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	valueType   = reflect.TypeOf((*Value)(nil)).Elem()
+)
+
+// ExportFunc sets the Go func variable pointed to by fnPtr, e.g. a
+// *func(order Order, limit int64) (bool, error), to a function that calls
+// the script function v.
+//
+// The arguments are converted with ToValue. If the first parameter is a
+// context.Context, it is used for the call instead of being passed to v.
+// The result of v is converted to the first result type of the func;
+// a trailing error result receives the script error or conversion error,
+// if any. Without an error result, a failing call panics.
+//
+// Like the Runtime itself, the exported func must not be called
+// concurrently.
+func (r *Runtime) ExportFunc(v Value, fnPtr interface{}) error {
+	ptr := reflect.ValueOf(fnPtr)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Func {
+		return &ErrTypeNotSupported{v: fnPtr}
+	}
+	if !v.IsFunction() {
+		return &ErrTypeMismatch{
+			expected: _EmptyFunction,
+			actual:   v,
+		}
+	}
+	fnType := ptr.Elem().Type()
+	resultType, hasErr, ok := exportedResults(fnType)
+	if !ok {
+		return &ErrTypeNotSupported{v: fnPtr}
+	}
+	f := v.ToFunction()
+	fn := reflect.MakeFunc(fnType, func(in []reflect.Value) []reflect.Value {
+		ctx := context.Background()
+		if fnType.NumIn() > 0 && fnType.In(0) == contextType {
+			if c, _ := in[0].Interface().(context.Context); c != nil {
+				ctx = c
+			}
+			in = in[1:]
+		}
+		if fnType.IsVariadic() && len(in) > 0 {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		args := make([]Value, len(in))
+		for i := range in {
+			args[i] = ToValue(in[i].Interface())
+		}
+
+		result, err := r.CallContext(ctx, f, args...)
+		var out reflect.Value
+		if err == nil && resultType != nil {
+			out, err = exportValue(r, result, resultType)
+		}
+		if err != nil && !hasErr {
+			panic(err)
+		}
+
+		results := make([]reflect.Value, 0, 2)
+		if resultType != nil {
+			if err != nil {
+				out = reflect.Zero(resultType)
+			}
+			results = append(results, out)
+		}
+		if hasErr {
+			errValue := reflect.Zero(errorType)
+			if err != nil {
+				errValue = reflect.ValueOf(&err).Elem()
+			}
+			results = append(results, errValue)
+		}
+		return results
+	})
+	ptr.Elem().Set(fn)
+	return nil
+}
+
+// exportedResults checks that the results of fnType are one of (), (T),
+// (error) or (T, error).
+func exportedResults(fnType reflect.Type) (resultType reflect.Type, hasErr bool, ok bool) {
+	switch fnType.NumOut() {
+	case 0:
+		return nil, false, true
+	case 1:
+		if fnType.Out(0) == errorType {
+			return nil, true, true
+		}
+		return fnType.Out(0), false, true
+	case 2:
+		if fnType.Out(1) == errorType {
+			return fnType.Out(0), true, true
+		}
+	}
+	return nil, false, false
+}
+
+// exportValue converts v to a Go value of type t.
+func exportValue(r *Runtime, v Value, t reflect.Type) (reflect.Value, error) {
+	if t == valueType {
+		return reflect.ValueOf(&v).Elem(), nil
+	}
+	if v == Null {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+	}
+	out := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := resultBool(v)
+		if err != nil {
+			return out, err
+		}
+		out.SetBool(b)
+		return out, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := resultInt(v)
+		if err != nil {
+			return out, err
+		}
+		if out.OverflowInt(n) {
+			return out, &ErrResultType{Expected: t.String(), Result: v}
+		}
+		out.SetInt(n)
+		return out, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := resultInt(v)
+		if err != nil {
+			return out, err
+		}
+		if n < 0 || out.OverflowUint(uint64(n)) {
+			return out, &ErrResultType{Expected: t.String(), Result: v}
+		}
+		out.SetUint(uint64(n))
+		return out, nil
+	case reflect.Float32, reflect.Float64:
+		f, err := resultFloat(v)
+		if err != nil {
+			return out, err
+		}
+		out.SetFloat(f)
+		return out, nil
+	case reflect.String:
+		s, err := resultString(v)
+		if err != nil {
+			return out, err
+		}
+		out.SetString(s)
+		return out, nil
+	case reflect.Slice:
+		if _, isRef := v.(Ref); isRef {
+			break
+		}
+		var values []Value
+		if err := convertValue(r, &values, v); err != nil {
+			return out, err
+		}
+		out = reflect.MakeSlice(t, len(values), len(values))
+		for i, value := range values {
+			elem, err := exportValue(r, value, t.Elem())
+			if err != nil {
+				return out, err
+			}
+			out.Index(i).Set(elem)
+		}
+		return out, nil
+	case reflect.Map:
+		if _, isRef := v.(Ref); isRef || t.Key().Kind() != reflect.String {
+			break
+		}
+		var values map[string]Value
+		if err := convertValue(r, &values, v); err != nil {
+			return out, err
+		}
+		out = reflect.MakeMapWithSize(t, len(values))
+		for key, value := range values {
+			elem, err := exportValue(r, value, t.Elem())
+			if err != nil {
+				return out, err
+			}
+			out.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+		}
+		return out, nil
+	case reflect.Func:
+		if _, isRef := v.(Ref); isRef {
+			break
+		}
+		fn := reflect.New(t)
+		if err := r.ExportFunc(v, fn.Interface()); err != nil {
+			return out, err
+		}
+		return fn.Elem(), nil
+	}
+	if native := reflect.ValueOf(v.ToNative()); native.IsValid() && native.Type().AssignableTo(t) {
+		out.Set(native)
+		return out, nil
+	}
+	return out, &ErrResultType{Expected: t.String(), Result: v}
+}
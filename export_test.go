@@ -0,0 +1,61 @@
+package gates
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type order struct {
+	Total int64
+}
+
+func (o *order) Get(r *Runtime, key Value) Value {
+	if key.ToString() == "total" {
+		return Int(o.Total)
+	}
+	return Null
+}
+
+func TestExportFunc(t *testing.T) {
+	r := New()
+	r.Global().Set("fail", FunctionFunc(func(fc FunctionCall) Value {
+		fc.Runtime().Throw(errors.New("rejected"))
+		return Null
+	}))
+
+	strategy, err := r.RunString(`function (o, limit) { return o.total <= limit || fail() }`)
+	assert.NoError(t, err)
+
+	var accept func(o *order, limit int64) (bool, error)
+	assert.NoError(t, r.ExportFunc(strategy, &accept))
+	ok, err := accept(&order{Total: 10}, 20)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = accept(&order{Total: 30}, 20)
+	assert.Error(t, err)
+	assert.EqualError(t, err.(*RuntimeError).Err, "rejected")
+
+	f, err := r.RunString(`function (a, b, c) { return map(function (x) { return x * 2 }, [a, b, c]) }`)
+	assert.NoError(t, err)
+	var double func(ctx context.Context, xs ...interface{}) []int
+	assert.NoError(t, r.ExportFunc(f, &double))
+	assert.Equal(t, []int{2, 4, 6}, double(context.Background(), 1, 2, 3))
+
+	answer, err := r.RunString(`function () { return 42 }`)
+	assert.NoError(t, err)
+	var name func() (string, error)
+	assert.NoError(t, r.ExportFunc(answer, &name))
+	_, err = name()
+	assert.EqualError(t, err, `string result expected, got number 42`)
+
+	var noResult func()
+	assert.NoError(t, r.ExportFunc(r.Global().Get("fail"), &noResult))
+	assert.Panics(t, noResult)
+
+	var bad func() (int, int)
+	assert.Error(t, r.ExportFunc(f, &bad))
+	assert.Error(t, r.ExportFunc(Int(1), &name))
+}
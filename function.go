@@ -33,6 +33,11 @@ var _EmptyFunction = FunctionFunc(func(FunctionCall) Value { return Null })
 
 type nativeFunction struct {
 	fun func(FunctionCall) Value
+
+	// streams is set for the built-in functions that consume lazy arrays
+	// element by element, such as map, which receive them as is. Other
+	// functions receive them materialized, see lazyArray.
+	streams bool
 }
 
 func (*nativeFunction) function() {}
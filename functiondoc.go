@@ -0,0 +1,72 @@
+package gates
+
+import "sort"
+
+// A FunctionDoc documents a built-in function for tools such as editors.
+type FunctionDoc struct {
+	Name      string // e.g. "map" or "strings.to_upper"
+	Signature string // e.g. "map(f, xs)"
+	Doc       string
+}
+
+// builtInFunctionDocs documents builtInFunctions. The curried functions
+// may be called with fewer arguments, which is how they are used as the
+// stages of pipelines.
+var builtInFunctionDocs = map[string]FunctionDoc{
+	"bool":            {Signature: "bool(v)", Doc: "Converts v to a bool."},
+	"int":             {Signature: "int(v)", Doc: "Converts v to an integer, truncating numbers."},
+	"number":          {Signature: "number(v)", Doc: "Converts v to a number."},
+	"decimal":         {Signature: "decimal(v)", Doc: "Converts v to a decimal, or returns null if it has no decimal representation."},
+	"string":          {Signature: "string(v)", Doc: "Converts v to a string."},
+	"type":            {Signature: "type(v)", Doc: "Returns the type of v: \"null\", \"bool\", \"number\", \"string\", \"array\", \"map\" or \"function\"."},
+	"curry":           {Signature: "curry(n, f)", Doc: "Returns f curried for n arguments."},
+	"map":             {Signature: "map(f, xs)", Doc: "Calls f(x, i) for the elements of xs and returns the results. Curried."},
+	"filter":          {Signature: "filter(f, xs)", Doc: "Returns the elements x of xs for which f(x, i) is truthy. Curried."},
+	"reduce":          {Signature: "reduce(f, initial, xs)", Doc: "Folds xs into a value by calling f(acc, x, i, xs), starting with initial. Curried."},
+	"find":            {Signature: "find(f, xs)", Doc: "Returns the first element x of xs for which f(x, i) is truthy, or null. Curried."},
+	"take":            {Signature: "take(n, xs)", Doc: "Returns the first n elements of xs. Curried."},
+	"find_index":      {Signature: "find_index(f, xs)", Doc: "Returns the index of the first element x of xs for which f(x, i) is truthy, or -1. Curried."},
+	"find_last":       {Signature: "find_last(f, xs)", Doc: "Returns the last element x of xs for which f(x, i) is truthy, or null. Curried."},
+	"find_last_index": {Signature: "find_last_index(f, xs)", Doc: "Returns the index of the last element x of xs for which f(x, i) is truthy, or -1. Curried."},
+	"to_array":        {Signature: "to_array(xs)", Doc: "Collects the values of the iterable xs into an array."},
+	"to_entries":      {Signature: "to_entries(object)", Doc: "Returns the [key, value] entries of object."},
+	"from_entries":    {Signature: "from_entries(entries)", Doc: "Returns a map of the [key, value] entries."},
+}
+
+// packageStringsDocs documents packageStrings.
+var packageStringsDocs = map[string]FunctionDoc{
+	"has_prefix":     {Signature: "strings.has_prefix(s, prefix)", Doc: "Reports whether s begins with prefix."},
+	"has_suffix":     {Signature: "strings.has_suffix(s, suffix)", Doc: "Reports whether s ends with suffix."},
+	"to_lower":       {Signature: "strings.to_lower(s)", Doc: "Returns s with all letters mapped to lower case."},
+	"to_upper":       {Signature: "strings.to_upper(s)", Doc: "Returns s with all letters mapped to upper case."},
+	"trim":           {Signature: "strings.trim(s, cutset?)", Doc: "Returns s without the leading and trailing characters in cutset, or white space by default."},
+	"trim_left":      {Signature: "strings.trim_left(s, cutset?)", Doc: "Returns s without the leading characters in cutset, or white space by default."},
+	"trim_right":     {Signature: "strings.trim_right(s, cutset?)", Doc: "Returns s without the trailing characters in cutset, or white space by default."},
+	"split":          {Signature: "strings.split(s, sep)", Doc: "Splits s into the substrings separated by sep."},
+	"join":           {Signature: "strings.join(a, sep)", Doc: "Concatenates the elements of a, placing sep between them."},
+	"match":          {Signature: "strings.match(expr, s)", Doc: "Matches s against the regular expression expr and returns a map whose group(i) returns a submatch, or null."},
+	"find_all":       {Signature: "strings.find_all(expr, s)", Doc: "Returns all the matches of the regular expression expr in s."},
+	"contains":       {Signature: "strings.contains(s, substr)", Doc: "Reports whether substr is within s."},
+	"contains_any":   {Signature: "strings.contains_any(s, chars)", Doc: "Reports whether any of the characters in chars is within s."},
+	"index":          {Signature: "strings.index(s, substr)", Doc: "Returns the index of the first substr in s, or -1."},
+	"index_any":      {Signature: "strings.index_any(s, chars)", Doc: "Returns the index of the first of the characters in chars in s, or -1."},
+	"last_index":     {Signature: "strings.last_index(s, substr)", Doc: "Returns the index of the last substr in s, or -1."},
+	"last_index_any": {Signature: "strings.last_index_any(s, chars)", Doc: "Returns the index of the last of the characters in chars in s, or -1."},
+	"repeat":         {Signature: "strings.repeat(s, count)", Doc: "Returns count copies of s."},
+}
+
+// BuiltInFunctionDocs returns the documentation of the built-in functions,
+// including the functions of the strings package, sorted by name.
+func BuiltInFunctionDocs() []FunctionDoc {
+	docs := make([]FunctionDoc, 0, len(builtInFunctionDocs)+len(packageStringsDocs))
+	for name, doc := range builtInFunctionDocs {
+		doc.Name = name
+		docs = append(docs, doc)
+	}
+	for name, doc := range packageStringsDocs {
+		doc.Name = "strings." + name
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs
+}
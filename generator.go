@@ -0,0 +1,121 @@
+package gates
+
+import "context"
+
+// generator runs the body of a generator function up to its next yield
+// statement each time a value is requested.
+type generator struct {
+	vm      *vm
+	program *Program
+	stash   *stash
+	pc      int
+
+	// stack holds the frame of the generator while it is suspended: the
+	// arguments, the argument count, the locals and any temporaries. bp is
+	// relative to the start of the frame.
+	stack []Value
+	bp    int
+
+	// base is the stack pointer at the start of the frame while the
+	// generator is running.
+	base    int
+	running bool
+	yielded bool
+	done    bool
+}
+
+// newGenerator creates a generator for a call of f with args.
+func newGenerator(vm *vm, f *literalFunction, args []Value) *generator {
+	stack := make([]Value, 0, len(args)+1+f.stackSize)
+	stack = append(stack, args...)
+	stack = append(stack, Int(len(args)))
+	for i := 0; i < f.stackSize; i++ {
+		stack = append(stack, Null)
+	}
+	return &generator{
+		vm:      vm,
+		program: f.program,
+		stash:   f.stash,
+		stack:   stack,
+		bp:      len(args) + 1,
+	}
+}
+
+// Next resumes the generator until it yields a value or returns. A
+// generator that is already running, e.g. because its body consumes
+// itself, reports the end of the sequence.
+func (g *generator) Next() (Value, bool) {
+	if g.done || g.running {
+		return nil, false
+	}
+	vm := g.vm
+	saved := ctxState{
+		ctx:       vm.ctx,
+		program:   vm.program,
+		stash:     vm.stash,
+		pc:        vm.pc,
+		bp:        vm.bp,
+		sp:        vm.stack.sp,
+		callStack: len(vm.callStack),
+	}
+	defer saved.restore(vm)
+
+	g.base = vm.stack.sp
+	for _, v := range g.stack {
+		vm.stack.Push(v)
+	}
+	vm.pc = -1
+	vm.pushCtx()
+	vm.program = g.program
+	vm.stash = g.stash
+	vm.bp = g.base + g.bp
+	vm.pc = g.pc
+	if vm.ctx == nil {
+		vm.ctx = context.Background()
+	}
+	if vm.depth == 0 {
+		vm.remainingCycles = vm.cyclesLimit
+	}
+
+	generator := vm.generator
+	vm.generator = g
+	g.running = true
+	err := vm.run()
+	g.running = false
+	vm.generator = generator
+	if err != nil {
+		g.done = true
+		g.stack = nil
+		if _, ok := err.(*RuntimeError); !ok {
+			err = vm.newRuntimeError(err)
+		}
+		vm.r.raise(err)
+	}
+	if !g.yielded {
+		g.done = true
+		g.stack = nil
+		return nil, false
+	}
+	g.yielded = false
+	return vm.stack.Pop(), true
+}
+
+type _yield struct{}
+
+var yield _yield
+
+// exec suspends the running generator: its frame is saved, the yielded
+// value is left on the stack and the nested run started by Next halts.
+func (_yield) exec(vm *vm) {
+	g := vm.generator
+	v := materialize(vm.stack.Pop())
+	g.stack = append(g.stack[:0], vm.stack.l[g.base:vm.stack.sp]...)
+	g.pc = vm.pc + 1
+	g.stash = vm.stash
+	g.bp = vm.bp - g.base
+	g.yielded = true
+	vm.stack.sp = g.base
+	vm.stack.Push(v)
+	vm.popCtx()
+	vm.halt = true
+}
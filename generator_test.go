@@ -0,0 +1,84 @@
+package gates
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator(t *testing.T) {
+	r := New()
+	v, err := r.RunString(`function* (n) { yield n; yield n + 1; return 0 }`)
+	assert.NoError(t, err)
+	seq, err := r.CallContext(context.Background(), v.ToFunction(), Int(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "sequence", Type(seq))
+
+	it, ok := GetIterator(seq)
+	assert.True(t, ok)
+	var values []int64
+	for {
+		v, ok := it.Next()
+		if !ok {
+			break
+		}
+		values = append(values, v.ToInt())
+	}
+	assert.Equal(t, []int64{1, 2}, values)
+	_, ok = it.Next()
+	assert.False(t, ok)
+}
+
+func TestGeneratorUnbounded(t *testing.T) {
+	program, err := CompileScript("naturals.gates", `
+let naturals = function* () {
+  for (let i = 0; true; i = i + 1) {
+    yield i;
+  }
+};
+naturals() | map(x => x * 2) | filter(x => x % 3 == 0) | take(100000) | reduce((acc, x) => acc + x, 0)
+`)
+	assert.NoError(t, err)
+	v, err := New().RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.EqualValues(t, int64(29999700000), v.ToInt())
+}
+
+func TestGeneratorError(t *testing.T) {
+	program, err := CompileScript("fail.gates", `
+let g = function* () {
+  yield 1;
+  yield 1 + missing();
+};
+[...g()]
+`)
+	assert.NoError(t, err)
+	r := New()
+	r.Global().Set("missing", FunctionFunc(func(fc FunctionCall) Value {
+		fc.Runtime().Throw(errors.New("missing"))
+		return Null
+	}))
+	_, err = r.RunProgram(context.Background(), program)
+	assert.EqualError(t, err, "missing at fail.gates:4:20")
+
+	r = New()
+	r.SetCyclesLimit(1000)
+	program, err = CompileScript("loop.gates", `
+let g = function* () {
+  for (let i = 0; true; i = i + 1) {
+    yield i;
+  }
+};
+g() | find(x => x < 0)
+`)
+	assert.NoError(t, err)
+	_, err = r.RunProgram(context.Background(), program)
+	if cause(err) != ErrCyclesLimitExceeded {
+		t.Errorf("cycles limit exceeded expected, got %v", err)
+	}
+
+	_, err = CompileScript("yield.gates", `let f = function () { yield 1 };`)
+	assert.EqualError(t, err, "SyntaxError: yield outside generator function at yield.gates:1:23")
+}
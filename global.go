@@ -1,5 +1,11 @@
 package gates
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 var builtInFunctions = map[string]Function{
 	"bool": FunctionFunc(func(fc FunctionCall) Value {
 		var v Bool
@@ -25,6 +31,29 @@ var builtInFunctions = map[string]Function{
 		return v.ToNumber()
 	}),
 
+	"decimal": FunctionFunc(func(fc FunctionCall) Value {
+		var v Value
+		if NewArgumentScanner(fc).Scan(&v) != nil {
+			return Decimal{}
+		}
+		switch {
+		case v.IsString():
+			if d, ok := ParseDecimal(strings.TrimSpace(v.ToString())); ok {
+				return d
+			}
+			return Null
+		case v.IsFloat():
+			if d, ok := floatToDecimal(v.ToFloat()); ok {
+				return d
+			}
+			return Null
+		}
+		if d, ok := toDecimal(v.ToNumber()); ok {
+			return Decimal{r: d}
+		}
+		return Null
+	}),
+
 	"string": FunctionFunc(func(fc FunctionCall) Value {
 		var v String
 		if NewArgumentScanner(fc).Scan(&v) != nil {
@@ -50,10 +79,22 @@ var builtInFunctions = map[string]Function{
 		return Curry(f.ToFunction(), int(n))
 	}),
 
-	"map": CurriedFunctionFunc(2, func(fc FunctionCall) Value {
+	"map": curriedStreamFunc(2, func(fc FunctionCall) Value {
 		var f Callback
+		var baseValue Value
+		if NewArgumentScanner(fc).Scan(&f, &baseValue) != nil {
+			return Null
+		}
+		if it, ok := sequenceOf(baseValue); ok {
+			return NewSequence(&mapIterator{f: f, it: it})
+		}
+		if src, ok := arrayIterator(baseValue); ok {
+			return newLazyArray(func() Iterator {
+				return &mapIterator{f: f, it: src()}
+			})
+		}
 		var base []Value
-		if NewArgumentScanner(fc).Scan(&f, &base) != nil {
+		if convertValue(fc.Runtime(), &base, baseValue) != nil {
 			return Null
 		}
 		result := make([]Value, len(base))
@@ -63,10 +104,22 @@ var builtInFunctions = map[string]Function{
 		return NewArray(result)
 	}),
 
-	"filter": CurriedFunctionFunc(2, func(fc FunctionCall) Value {
+	"filter": curriedStreamFunc(2, func(fc FunctionCall) Value {
 		var f Callback
+		var baseValue Value
+		if NewArgumentScanner(fc).Scan(&f, &baseValue) != nil {
+			return Null
+		}
+		if it, ok := sequenceOf(baseValue); ok {
+			return NewSequence(&filterIterator{f: f, it: it})
+		}
+		if src, ok := arrayIterator(baseValue); ok {
+			return newLazyArray(func() Iterator {
+				return &filterIterator{f: f, it: src()}
+			})
+		}
 		var base []Value
-		if NewArgumentScanner(fc).Scan(&f, &base) != nil {
+		if convertValue(fc.Runtime(), &base, baseValue) != nil {
 			return Null
 		}
 		result := make([]Value, 0)
@@ -78,28 +131,52 @@ var builtInFunctions = map[string]Function{
 		return NewArray(result)
 	}),
 
-	"reduce": CurriedFunctionFunc(3, func(fc FunctionCall) Value {
+	"reduce": curriedStreamFunc(3, func(fc FunctionCall) Value {
 		var f Callback
 		var initial Value
 		var base Value
 		if NewArgumentScanner(fc).Scan(&f, &initial, &base) != nil {
 			return Null
 		}
+		acc := initial
+		if it, ok := streamOf(base); ok {
+			for i := 0; ; i++ {
+				v, ok := it.Next()
+				if !ok {
+					return acc
+				}
+				acc = f(acc, v, Int(i), base)
+			}
+		}
 		var baseArray []Value
 		if convertValue(fc.Runtime(), &baseArray, base) != nil {
 			return Null
 		}
-		acc := initial
 		for i := 0; i < len(baseArray); i++ {
 			acc = f(acc, baseArray[i], Int(i), base)
 		}
 		return acc
 	}),
 
-	"find": CurriedFunctionFunc(2, func(fc FunctionCall) Value {
+	"find": curriedStreamFunc(2, func(fc FunctionCall) Value {
 		var f Callback
+		var baseValue Value
+		if NewArgumentScanner(fc).Scan(&f, &baseValue) != nil {
+			return Null
+		}
+		if it, ok := streamOf(baseValue); ok {
+			for i := 0; ; i++ {
+				v, ok := it.Next()
+				if !ok {
+					return Null
+				}
+				if f(v, Int(i)).ToBool() {
+					return v
+				}
+			}
+		}
 		var base []Value
-		if NewArgumentScanner(fc).Scan(&f, &base) != nil {
+		if convertValue(fc.Runtime(), &base, baseValue) != nil {
 			return Null
 		}
 		for i := 0; i < len(base); i++ {
@@ -110,10 +187,52 @@ var builtInFunctions = map[string]Function{
 		return Null
 	}),
 
-	"find_index": CurriedFunctionFunc(2, func(fc FunctionCall) Value {
+	"take": curriedStreamFunc(2, func(fc FunctionCall) Value {
+		var n int64
+		var baseValue Value
+		if NewArgumentScanner(fc).Scan(&n, &baseValue) != nil {
+			return Null
+		}
+		if it, ok := sequenceOf(baseValue); ok {
+			return NewSequence(&takeIterator{it: it, n: n})
+		}
+		if src, ok := arrayIterator(baseValue); ok {
+			return newLazyArray(func() Iterator {
+				return &takeIterator{it: src(), n: n}
+			})
+		}
+		var base []Value
+		if convertValue(fc.Runtime(), &base, baseValue) != nil {
+			return Null
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n < int64(len(base)) {
+			base = base[:n]
+		}
+		return NewArray(append([]Value(nil), base...))
+	}),
+
+	"find_index": curriedStreamFunc(2, func(fc FunctionCall) Value {
 		var f Callback
+		var baseValue Value
+		if NewArgumentScanner(fc).Scan(&f, &baseValue) != nil {
+			return Int(-1)
+		}
+		if it, ok := streamOf(baseValue); ok {
+			for i := 0; ; i++ {
+				v, ok := it.Next()
+				if !ok {
+					return Int(-1)
+				}
+				if f(v, Int(i)).ToBool() {
+					return Int(i)
+				}
+			}
+		}
 		var base []Value
-		if NewArgumentScanner(fc).Scan(&f, &base) != nil {
+		if convertValue(fc.Runtime(), &base, baseValue) != nil {
 			return Int(-1)
 		}
 		for i := 0; i < len(base); i++ {
@@ -152,12 +271,20 @@ var builtInFunctions = map[string]Function{
 		return Int(-1)
 	}),
 
+	"to_array": FunctionFunc(func(fc FunctionCall) Value {
+		var base Value
+		if NewArgumentScanner(fc).Scan(&base) != nil {
+			return Null
+		}
+		return toArray(base)
+	}),
+
 	"to_entries": FunctionFunc(func(fc FunctionCall) Value {
 		var v Value
 		if NewArgumentScanner(fc).Scan(&v) != nil {
 			return Null
 		}
-		it, ok := GetIterator(v)
+		it, ok := getIterator(fc.Runtime(), v)
 		if !ok {
 			return Null
 		}
@@ -177,7 +304,7 @@ var builtInFunctions = map[string]Function{
 		if NewArgumentScanner(fc).Scan(&v) != nil {
 			return Null
 		}
-		it, ok := GetIterator(v)
+		it, ok := getIterator(fc.Runtime(), v)
 		if !ok {
 			return Null
 		}
@@ -196,8 +323,20 @@ var builtInFunctions = map[string]Function{
 	}),
 }
 
+// Global holds the variables visible to programs as global identifiers.
+// A Global may also be used as a per-run binding layer, see
+// Runtime.RunProgramWithBindings.
 type Global struct {
-	m Map
+	m    Map
+	lazy map[string]func() Value
+
+	// readOnly holds the names of the globals that scripts cannot assign
+	// to.
+	readOnly map[string]bool
+
+	// version is incremented whenever a global is set or deleted, which
+	// invalidates the global slots cached by the VM.
+	version uint64
 }
 
 func NewGlobal() *Global {
@@ -207,27 +346,201 @@ func NewGlobal() *Global {
 }
 
 func (g *Global) Set(name string, value Value) {
+	delete(g.lazy, name)
 	g.m[name] = value
+	g.version++
+}
+
+// SetLazy sets a global whose value is computed by f the first time it is
+// looked up. The computed value is cached in g.
+func (g *Global) SetLazy(name string, f func() Value) {
+	delete(g.m, name)
+	if g.lazy == nil {
+		g.lazy = make(map[string]func() Value)
+	}
+	g.lazy[name] = f
+	g.version++
 }
 
 func (g *Global) Get(name string) Value {
-	return g.m[name]
+	v, _ := g.lookup(name)
+	return v
+}
+
+// SetReadOnly sets a global that scripts cannot assign to.
+func (g *Global) SetReadOnly(name string, value Value) {
+	g.Set(name, value)
+	g.Freeze(name)
+}
+
+// Freeze makes the globals with the given names read-only for scripts.
+// Scripts cannot assign to the members of their maps and arrays either,
+// at any depth, but may copy them, e.g. with a spread. The host may still
+// Set or Delete them.
+func (g *Global) Freeze(names ...string) {
+	if g.readOnly == nil {
+		g.readOnly = make(map[string]bool)
+	}
+	for _, name := range names {
+		g.readOnly[name] = true
+	}
+}
+
+// has reports whether the global name is defined.
+func (g *Global) has(name string) bool {
+	_, ok := g.m[name]
+	if !ok {
+		_, ok = g.lazy[name]
+	}
+	return ok
+}
+
+// IsReadOnly reports whether scripts cannot assign to the global name.
+func (g *Global) IsReadOnly(name string) bool {
+	return g.readOnly[name]
+}
+
+// Delete removes a global.
+func (g *Global) Delete(name string) {
+	delete(g.m, name)
+	delete(g.lazy, name)
+	delete(g.readOnly, name)
+	g.version++
+}
+
+// Names returns the names of the globals in sorted order, e.g. to declare
+// them to CompileWithGlobals.
+func (g *Global) Names() []string {
+	names := make([]string, 0, len(g.m)+len(g.lazy))
+	for name := range g.m {
+		names = append(names, name)
+	}
+	for name := range g.lazy {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Range calls f for each global in the order of their names until f
+// returns false. Lazy globals are computed as they are visited.
+func (g *Global) Range(f func(name string, value Value) bool) {
+	for _, name := range g.Names() {
+		v, ok := g.lookup(name)
+		if !ok {
+			continue
+		}
+		if !f(name, v) {
+			return
+		}
+	}
+}
+
+func (g *Global) lookup(name string) (Value, bool) {
+	if v, ok := g.m[name]; ok {
+		return v, true
+	}
+	if f, ok := g.lazy[name]; ok {
+		delete(g.lazy, name)
+		v := ToValue(f())
+		g.m[name] = v
+		return v, true
+	}
+	return nil, false
+}
+
+// globalScope resolves global identifiers, consulting the per-run bindings
+// before the Runtime's Global. Assignments go to the bindings if present.
+type globalScope struct {
+	base, bindings *Global
+}
+
+func (s *globalScope) Get(r *Runtime, key Value) Value {
+	if v, ok := s.lookup(key.ToString()); ok {
+		return v
+	}
+	return Null
+}
+
+// lookup returns the value of the global name, if it is defined. Maps and
+// arrays of read-only globals are returned as read-only views.
+func (s *globalScope) lookup(name string) (Value, bool) {
+	g := s.base
+	if s.bindings != nil && s.bindings.has(name) {
+		g = s.bindings
+	}
+	v, ok := g.lookup(name)
+	if ok && g.IsReadOnly(name) {
+		// scripts read the maps and arrays of read-only globals through
+		// read-only views
+		v = readOnlyView(name, v)
+	}
+	return v, ok
+}
+
+func (s *globalScope) Set(r *Runtime, key, value Value) {
+	name := key.ToString()
+	if s.base.IsReadOnly(name) || s.bindings != nil && s.bindings.IsReadOnly(name) {
+		r.Throw(&ErrReadOnlyGlobal{Name: name})
+	}
+	if r.strict && !r.writable[name] && !s.hasBinding(name) {
+		r.Throw(&ErrUndeclaredGlobal{Name: name})
+	}
+	if s.bindings != nil {
+		s.bindings.Set(name, value)
+		return
+	}
+	s.base.Set(name, value)
+}
+
+// version changes whenever a global visible through s is set or deleted.
+func (s *globalScope) version() uint64 {
+	if s.bindings != nil {
+		return s.base.version + s.bindings.version
+	}
+	return s.base.version
+}
+
+// hasBinding reports whether name is defined in the per-run bindings,
+// which scripts may assign to even in strict mode.
+func (s *globalScope) hasBinding(name string) bool {
+	return s.bindings != nil && s.bindings.has(name)
+}
+
+// ErrReadOnlyGlobal is raised when a script assigns to a read-only global,
+// e.g. a built-in function.
+type ErrReadOnlyGlobal struct {
+	Name string
+}
+
+func (e *ErrReadOnlyGlobal) Error() string {
+	return fmt.Sprintf("cannot assign to read-only global %s", e.Name)
+}
+
+// ErrUndeclaredGlobal is raised in strict mode when a script assigns to a
+// global that is not writable, see Runtime.SetStrict.
+type ErrUndeclaredGlobal struct {
+	Name string
+}
+
+func (e *ErrUndeclaredGlobal) Error() string {
+	return fmt.Sprintf("assignment to undeclared global %s", e.Name)
 }
 
 func Curry(f Function, n int) Function {
 	var curriedF Function
-	curriedF = FunctionFunc(func(fc FunctionCall) Value {
+	curriedF = &nativeFunction{fun: func(fc FunctionCall) Value {
 		args := fc.Args()
 		argc := len(args)
 		if argc >= n {
-			return fc.Runtime().Call(f, args...)
+			return fc.Runtime().invoke(f, args...)
 		}
 		args = make([]Value, argc)
 		copy(args, fc.Args())
-		return Curry(FunctionFunc(func(fc FunctionCall) Value {
-			return fc.Runtime().Call(f, append(args, fc.Args()...)...)
-		}), n-argc)
-	})
+		return Curry(&nativeFunction{fun: func(fc FunctionCall) Value {
+			return fc.Runtime().invoke(f, append(args, fc.Args()...)...)
+		}, streams: streams(f)}, n-argc)
+	}, streams: streams(f)}
 	return curriedF
 }
 
@@ -235,8 +548,20 @@ func CurriedFunctionFunc(n int, f func(FunctionCall) Value) Function {
 	return Curry(FunctionFunc(f), n)
 }
 
+// curriedStreamFunc is like CurriedFunctionFunc, but the function and its
+// partial applications receive lazy arrays as is.
+func curriedStreamFunc(n int, f func(FunctionCall) Value) Function {
+	return Curry(&nativeFunction{fun: f, streams: true}, n)
+}
+
+// streams reports whether f receives lazy arrays as is.
+func streams(f Function) bool {
+	nf, ok := f.(*nativeFunction)
+	return ok && nf.streams
+}
+
 func (g *Global) initBuiltInFunctions() {
 	for name, f := range builtInFunctions {
-		g.Set(name, f)
+		g.SetReadOnly(name, f)
 	}
 }
@@ -0,0 +1,271 @@
+package gates
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobal(t *testing.T) {
+	g := NewGlobal()
+	g.Set("a", Int(1))
+	calls := 0
+	g.SetLazy("b", func() Value {
+		calls++
+		return Int(2)
+	})
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, Int(2), g.Get("b"))
+	assert.Equal(t, Int(2), g.Get("b"))
+	assert.Equal(t, 1, calls)
+
+	var names []string
+	g.Range(func(name string, value Value) bool {
+		names = append(names, name)
+		return true
+	})
+	assert.Equal(t, []string{"a", "b"}, names)
+
+	g.Delete("a")
+	assert.Nil(t, g.Get("a"))
+}
+
+func TestRunProgramWithBindings(t *testing.T) {
+	ctx := context.Background()
+	r := New()
+	r.Global().Set("base", Int(40))
+	program, err := CompileScript("", `
+		result = base + x;
+		base = 0;
+		result
+	`)
+	assert.NoError(t, err)
+
+	bindings := NewGlobal()
+	bindings.Set("x", Int(2))
+	v, err := r.RunProgramWithBindings(ctx, program, bindings)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(42), v)
+	assert.Equal(t, Int(42), bindings.Get("result"))
+	assert.Equal(t, Int(0), bindings.Get("base"))
+
+	// the base Global is untouched
+	assert.Equal(t, Int(40), r.Global().Get("base"))
+	assert.Nil(t, r.Global().Get("result"))
+
+	bindings = NewGlobal()
+	calls := 0
+	bindings.SetLazy("x", func() Value {
+		calls++
+		return Int(1)
+	})
+	v, err = r.RunProgramWithBindings(ctx, program, bindings)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(41), v)
+	assert.Equal(t, 1, calls)
+
+	program, err = Compile(`x`)
+	assert.NoError(t, err)
+	v, err = r.RunProgram(ctx, program)
+	assert.NoError(t, err)
+	assert.Equal(t, Null, v)
+}
+
+func TestReadOnlyGlobals(t *testing.T) {
+	ctx := context.Background()
+	r := New()
+	r.Global().SetReadOnly("limit", Int(10))
+
+	for _, src := range []string{`map = null`, `strings = {}`, `limit = 0`} {
+		program, err := CompileScript("assign.gates", src+";")
+		assert.NoError(t, err)
+		_, err = r.RunProgram(ctx, program)
+		if assert.Error(t, err, src) {
+			_, ok := cause(err).(*ErrReadOnlyGlobal)
+			assert.True(t, ok, src)
+		}
+	}
+	assert.True(t, r.Global().Get("map").IsFunction())
+	assert.Equal(t, Int(10), r.Global().Get("limit"))
+
+	// bindings do not lift the protection either
+	program, err := CompileScript("assign.gates", "\nmap = 1;")
+	assert.NoError(t, err)
+	_, err = r.RunProgramWithBindings(ctx, program, NewGlobal())
+	assert.EqualError(t, err, "cannot assign to read-only global map at assign.gates:2:1")
+
+	// so are the members of the packages
+	for _, src := range []string{`strings.to_lower = null`, `let s = strings; s.x = 1`} {
+		program, err := CompileScript("assign.gates", src+";")
+		assert.NoError(t, err)
+		_, err = r.RunProgram(ctx, program)
+		if assert.Error(t, err, src) {
+			_, ok := cause(err).(*ErrReadOnlyGlobal)
+			assert.True(t, ok, src)
+		}
+	}
+	v, err := r.RunString(`strings.to_lower("ABC")`)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", v.ToString())
+
+	// and the members of their members
+	cfg := Map{
+		"limits": Map{"max": Int(5)},
+		"items":  NewArray([]Value{Map{"x": Int(1)}}),
+	}
+	r.Global().SetReadOnly("cfg", cfg)
+	for src, name := range map[string]string{
+		`cfg.limits.max = 0`:                           "cfg.limits.max",
+		`let l = cfg.limits; l.max = 0`:                "cfg.limits.max",
+		`cfg.items[0] = 1`:                             "cfg.items.0",
+		`cfg.items[0].x = 0`:                           "cfg.items.0.x",
+		`[...cfg.items][0].x = 0`:                      "cfg.items.0.x",
+		`cfg.items[0:1][0].x = 0`:                      "cfg.items.0.x",
+		`let c = { ...cfg }; c.limits.max = 0`:         "cfg.limits.max",
+		`(cfg | to_entries)[0].value[0].x = 0`:         "cfg.items.0.x",
+		`let f = (m) => { m.max = 0; }; f(cfg.limits)`: "cfg.limits.max",
+	} {
+		program, err := CompileScript("assign.gates", src+";")
+		assert.NoError(t, err)
+		_, err = r.RunProgram(ctx, program)
+		if assert.Error(t, err, src) {
+			e, ok := cause(err).(*ErrReadOnlyGlobal)
+			if assert.True(t, ok, src) {
+				assert.Equal(t, name, e.Name, src)
+			}
+		}
+	}
+	program, err = CompileScript("read.gates", `
+		let c = { ...cfg };
+		c.extra = 1;
+		let items = cfg.items[:];
+		items[0] = 2;
+		[
+			cfg.limits.max,
+			cfg.items.length,
+			type(cfg),
+			cfg.limits == { max: 5 },
+			{ max: 5 } == cfg.limits,
+			cfg.items | map(x => x.x),
+			to_array(cfg.items).length,
+			c.extra,
+			items[0]
+		]`)
+	assert.NoError(t, err)
+	v, err = r.RunProgram(ctx, program)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		int64(5), int64(1), "map", true, true, []interface{}{int64(1)}, int64(1), int64(1), int64(2),
+	}, v.ToNative())
+	assert.Equal(t, Map{
+		"limits": Map{"max": Int(5)},
+		"items":  NewArray([]Value{Map{"x": Int(1)}}),
+	}, cfg)
+
+	// the host may still replace them
+	r.Global().Set("limit", Int(20))
+	assert.True(t, r.Global().IsReadOnly("limit"))
+	r.Global().Delete("limit")
+	assert.False(t, r.Global().IsReadOnly("limit"))
+}
+
+func TestStrictGlobals(t *testing.T) {
+	ctx := context.Background()
+	r := New()
+	r.SetStrict(true)
+	r.SetWritableGlobals("result")
+
+	program, err := CompileScript("strict.gates", `let x = 1; x = 2; result = x;`)
+	assert.NoError(t, err)
+	_, err = r.RunProgram(ctx, program)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(2), r.Global().Get("result"))
+
+	program, err = CompileScript("strict.gates", `let x = 1; y = x;`)
+	assert.NoError(t, err)
+	_, err = r.RunProgram(ctx, program)
+	assert.EqualError(t, err, "assignment to undeclared global y at strict.gates:1:12")
+	assert.Nil(t, r.Global().Get("y"))
+
+	// names defined in the bindings of a run are assignable
+	bindings := NewGlobal()
+	bindings.Set("y", Null)
+	_, err = r.RunProgramWithBindings(ctx, program, bindings)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1), bindings.Get("y"))
+
+	_, err = Eval(ctx, `(function () { total = n + 1; return total; })()`, map[string]interface{}{"n": 1}, WithStrict())
+	assert.EqualError(t, err, "assignment to undeclared global total at 1:16")
+	v, err := Eval(ctx, `(function () { total = n + 1; return total; })()`, map[string]interface{}{"n": 1}, WithStrict("total"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, v)
+}
+
+func TestCompileWithGlobals(t *testing.T) {
+	ctx := context.Background()
+	r := New()
+	r.Global().Set("rate", Int(2))
+	r.Global().Set("count", Int(0))
+
+	program, err := CompileScriptWithGlobals("slots.gates", `
+		let f = function (x) { return x * rate; };
+		count = count + 1;
+		[f(21), count] | map(string)
+	`, r.Global().Names())
+	assert.NoError(t, err)
+	v, err := r.RunProgram(ctx, program)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"42", "1"}, v.ToNative())
+
+	// the slots see the changes made by the host between runs
+	r.Global().Set("rate", Int(3))
+	v, err = r.RunProgram(ctx, program)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"63", "2"}, v.ToNative())
+
+	bindings := NewGlobal()
+	bindings.Set("rate", Int(1))
+	v, err = r.RunProgramWithBindings(ctx, program, bindings)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"21", "3"}, v.ToNative())
+
+	for src, expected := range map[string]string{
+		`rat * 2`:                        "SyntaxError: undeclared identifier rat, did you mean rate? at 1:1",
+		`(function (value) { valeu })()`: "SyntaxError: undeclared identifier valeu, did you mean value? at 1:21",
+		`(function () { lenght = 1 })`:   "SyntaxError: undeclared identifier lenght at 1:16",
+		`[1] | filtr(x => x)`:            "SyntaxError: undeclared identifier filtr, did you mean filter? at 1:7",
+	} {
+		_, err := CompileWithGlobals(src, []string{"rate"})
+		assert.EqualError(t, err, expected, src)
+	}
+
+	program, err = CompileWithGlobals(`strings.to_upper("ok") + rate`, nil)
+	assert.Nil(t, program)
+	assert.EqualError(t, err, "SyntaxError: undeclared identifier rate at 1:26")
+}
+
+func TestBuiltInFunctionDocs(t *testing.T) {
+	docs := make(map[string]FunctionDoc)
+	for _, doc := range BuiltInFunctionDocs() {
+		assert.NotEmpty(t, doc.Doc, doc.Name)
+		docs[doc.Name] = doc
+	}
+
+	// every built-in function is documented
+	var names []string
+	for name := range builtInFunctions {
+		names = append(names, name)
+	}
+	for name := range packageStrings() {
+		names = append(names, "strings."+name)
+	}
+	assert.Len(t, docs, len(names))
+	for _, name := range names {
+		doc, ok := docs[name]
+		if assert.True(t, ok, name) {
+			assert.True(t, strings.HasPrefix(doc.Signature, name+"("), doc.Signature)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package framing reads and writes the messages of the Language Server and
+// the Debug Adapter protocols, which are JSON bodies preceded by headers
+// that give their Content-Length.
+package framing
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Read reads the headers of a message and returns its body. It returns
+// io.EOF if r ends before the message starts.
+func Read(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for headers := 0; ; headers++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && (line != "" || headers > 0) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:i]), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", line[i+1:])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Write writes v encoded as JSON, framed by a Content-Length header.
+func Write(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
@@ -0,0 +1,49 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, map[string]int{"a": 1}))
+	assert.NoError(t, Write(&buf, []string{"b"}))
+	assert.Equal(t, "Content-Length: 7\r\n\r\n{\"a\":1}Content-Length: 5\r\n\r\n[\"b\"]", buf.String())
+
+	r := bufio.NewReader(&buf)
+	body, err := Read(r)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(body))
+	body, err = Read(r)
+	assert.NoError(t, err)
+	assert.Equal(t, `["b"]`, string(body))
+	_, err = Read(r)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadHeaders(t *testing.T) {
+	for _, test := range []struct {
+		src, body, err string
+	}{
+		{"content-length: 2\nContent-Type: application/json\n\n{}", "{}", ""},
+		{"Content-Length: 2\r\n\r\n{", "", "unexpected EOF"},
+		{"Content-Length: 2\r\n", "", "unexpected EOF"},
+		{"Content-Length\r\n\r\n", "", `invalid header "Content-Length"`},
+		{"Content-Length: -1\r\n\r\n", "", `invalid Content-Length " -1"`},
+		{"Content-Type: application/json\r\n\r\n", "", "missing Content-Length header"},
+	} {
+		body, err := Read(bufio.NewReader(strings.NewReader(test.src)))
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.src)
+			continue
+		}
+		assert.NoError(t, err, test.src)
+		assert.Equal(t, test.body, string(body), test.src)
+	}
+}
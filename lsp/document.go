@@ -0,0 +1,319 @@
+package lsp
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lujjjh/gates"
+	"github.com/lujjjh/gates/syntax"
+	"github.com/lujjjh/gates/syntax/printer"
+)
+
+// builtInDocs holds the documentation of the built-in functions by name,
+// e.g. "map" or "strings.to_upper".
+var builtInDocs = func() map[string]gates.FunctionDoc {
+	docs := make(map[string]gates.FunctionDoc)
+	for _, doc := range gates.BuiltInFunctionDocs() {
+		docs[doc.Name] = doc
+	}
+	return docs
+}()
+
+var keywords = []string{"let", "function", "if", "else", "for", "return", "yield", "true", "false", "null"}
+
+// A document is the text of an open document. The text is analyzed on
+// demand, as the documents are small.
+type document struct {
+	uri      string
+	filename string
+	text     string
+}
+
+func newDocument(uri, text string) *document {
+	filename := uri
+	if u, err := url.Parse(uri); err == nil && u.Path != "" {
+		filename = path.Base(u.Path)
+	}
+	return &document{uri: uri, filename: filename, text: text}
+}
+
+// offset returns the byte offset of pos, whose character is counted in
+// UTF-16 code units.
+func (d *document) offset(pos Position) int {
+	i := 0
+	for line := 0; line < pos.Line; line++ {
+		j := strings.IndexByte(d.text[i:], '\n')
+		if j < 0 {
+			return len(d.text)
+		}
+		i += j + 1
+	}
+	for n := 0; i < len(d.text) && n < pos.Character && d.text[i] != '\n'; {
+		r, w := utf8.DecodeRuneInString(d.text[i:])
+		n += utf16Len(r)
+		i += w
+	}
+	return i
+}
+
+// position returns the position of the byte offset.
+func (d *document) position(offset int) Position {
+	text := d.text[:offset]
+	start := strings.LastIndexByte(text, '\n') + 1
+	n := 0
+	for _, r := range text[start:] {
+		n += utf16Len(r)
+	}
+	return Position{Line: strings.Count(text, "\n"), Character: n}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// rangeOf returns the range of n bytes at offset.
+func (d *document) rangeOf(offset, n int) Range {
+	return Range{Start: d.position(offset), End: d.position(offset + n)}
+}
+
+func isIdentChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '_' || ch == '$' || ch >= utf8.RuneSelf
+}
+
+// tokenLen returns the length of the word or the character at offset, so
+// that a diagnostic covers it.
+func (d *document) tokenLen(offset int) int {
+	end := offset
+	for end < len(d.text) && isIdentChar(d.text[end]) {
+		end++
+	}
+	if end == offset && offset < len(d.text) && d.text[offset] != '\n' {
+		_, w := utf8.DecodeRuneInString(d.text[offset:])
+		end += w
+	}
+	return end - offset
+}
+
+// parse parses the document, which may result in a partial syntax tree.
+func (d *document) parse() (*syntax.FileSet, *syntax.Script, error) {
+	fset := syntax.NewFileSet()
+	s, err := syntax.Config{ErrorLimit: -1}.ParseFile(fset, d.filename, d.text)
+	return fset, s, err
+}
+
+// compile compiles the document, skipping the parts that have syntax
+// errors, or returns nil if it cannot be compiled.
+func (d *document) compile() *gates.Program {
+	fset, s, _ := d.parse()
+	if s == nil {
+		return nil
+	}
+	program, err := gates.CompileTree(fset, s)
+	if err != nil {
+		return nil
+	}
+	return program
+}
+
+// diagnostics returns the syntax errors found by the parser or, if there
+// are none, by the compiler.
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+	add := func(offset int, msg string) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.rangeOf(offset, d.tokenLen(offset)),
+			Severity: SeverityError,
+			Source:   "gates",
+			Message:  msg,
+		})
+	}
+
+	_, _, err := d.parse()
+	if list, ok := err.(syntax.ErrorList); ok {
+		for _, e := range list {
+			add(e.Pos.Offset, e.Msg)
+		}
+		return diagnostics
+	}
+	_, err = gates.CompileFile(syntax.NewFileSet(), d.filename, d.text)
+	if e, ok := err.(*gates.CompilerSyntaxError); ok && e.File != nil {
+		add(e.File.Offset(e.Pos), e.Message)
+	}
+	return diagnostics
+}
+
+// identAt returns the identifier at offset and the identifier it is
+// selected from, if any, e.g. strings for strings.to_upper.
+func (d *document) identAt(offset int) (fset *syntax.FileSet, ident, from *syntax.Ident) {
+	fset, s, _ := d.parse()
+	if s == nil {
+		return nil, nil, nil
+	}
+	base := fset.File(s.FileStart).Base()
+	contains := func(n syntax.Node) bool {
+		return int(n.Pos())-base <= offset && offset < int(n.End())-base
+	}
+	keys := make(map[syntax.Expr]bool)
+	syntax.Inspect(s, func(n syntax.Node) bool {
+		if n == nil || ident != nil || !contains(n) {
+			return false
+		}
+		switch n := n.(type) {
+		case *syntax.MapLit:
+			for _, entry := range n.Entries {
+				if !entry.Computed {
+					keys[entry.Key] = true
+				}
+			}
+		case *syntax.SelectorExpr:
+			if contains(n.Sel) {
+				ident = n.Sel
+				from, _ = n.X.(*syntax.Ident)
+				return false
+			}
+		case *syntax.Ident:
+			if !keys[n] {
+				ident = n
+			}
+		}
+		return true
+	})
+	return fset, ident, from
+}
+
+// isLocal reports whether the identifier at offset is a use of a local
+// name.
+func isLocal(program *gates.Program, offset int) bool {
+	if program == nil {
+		return false
+	}
+	for _, def := range program.Definitions() {
+		if def.Use.Offset == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// hover documents the built-in function at offset.
+func (d *document) hover(offset int) *Hover {
+	fset, ident, from := d.identAt(offset)
+	if ident == nil {
+		return nil
+	}
+	name := ident.Name
+	identOffset := fset.Position(ident.NamePos).Offset
+	program := d.compile()
+	if from != nil {
+		if from.Name != "strings" || isLocal(program, fset.Position(from.NamePos).Offset) {
+			return nil
+		}
+		name = "strings." + name
+	} else if isLocal(program, identOffset) {
+		return nil
+	}
+	doc, ok := builtInDocs[name]
+	if !ok {
+		return nil
+	}
+	r := d.rangeOf(identOffset, len(ident.Name))
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: "```gates\n" + doc.Signature + "\n```\n\n" + doc.Doc,
+		},
+		Range: &r,
+	}
+}
+
+// definition returns the declaration of the local name at offset.
+func (d *document) definition(offset int) []Location {
+	program := d.compile()
+	if program == nil {
+		return nil
+	}
+	for _, def := range program.Definitions() {
+		if def.Use.Offset <= offset && offset < def.Use.Offset+len(def.Name) {
+			return []Location{{URI: d.uri, Range: d.rangeOf(def.Decl.Offset, len(def.Name))}}
+		}
+	}
+	return nil
+}
+
+// completion returns the members of strings after "strings." and the
+// globals and keywords otherwise.
+func (d *document) completion(offset int) []CompletionItem {
+	items := []CompletionItem{}
+	start := offset
+	for start > 0 && isIdentChar(d.text[start-1]) {
+		start--
+	}
+
+	if start > 0 && d.text[start-1] == '.' {
+		end := start - 1
+		from := end
+		for from > 0 && isIdentChar(d.text[from-1]) {
+			from--
+		}
+		if d.text[from:end] != "strings" {
+			return items
+		}
+		for _, doc := range gates.BuiltInFunctionDocs() {
+			if strings.HasPrefix(doc.Name, "strings.") {
+				items = append(items, functionItem(strings.TrimPrefix(doc.Name, "strings."), doc))
+			}
+		}
+		return items
+	}
+
+	for _, doc := range gates.BuiltInFunctionDocs() {
+		if !strings.Contains(doc.Name, ".") {
+			items = append(items, functionItem(doc.Name, doc))
+		}
+	}
+	items = append(items, CompletionItem{Label: "strings", Kind: completionModule, Detail: "string functions"})
+
+	// the globals that the document uses, e.g. the inputs of a rule
+	if program := d.compile(); program != nil {
+		seen := make(map[string]bool)
+		for _, ref := range program.References().Globals {
+			if _, ok := builtInDocs[ref.Name]; ok || ref.Name == "strings" || seen[ref.Name] {
+				continue
+			}
+			seen[ref.Name] = true
+			items = append(items, CompletionItem{Label: ref.Name, Kind: completionVariable, Detail: "global"})
+		}
+	}
+
+	for _, keyword := range keywords {
+		items = append(items, CompletionItem{Label: keyword, Kind: completionKeyword})
+	}
+	return items
+}
+
+func functionItem(label string, doc gates.FunctionDoc) CompletionItem {
+	return CompletionItem{
+		Label:         label,
+		Kind:          completionFunction,
+		Detail:        doc.Signature,
+		Documentation: &MarkupContent{Kind: "markdown", Value: doc.Doc},
+	}
+}
+
+// format returns the edits that format the document, or nil if it has
+// syntax errors.
+func (d *document) format() []TextEdit {
+	res, err := printer.Source(d.filename, []byte(d.text))
+	if err != nil {
+		return nil
+	}
+	if string(res) == d.text {
+		return []TextEdit{}
+	}
+	return []TextEdit{{Range: d.rangeOf(0, len(d.text)), NewText: string(res)}}
+}
@@ -0,0 +1,25 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+
+	"github.com/lujjjh/gates/internal/framing"
+)
+
+// readMessage reads a message framed by a Content-Length header. A body
+// that is not a valid request is reported as a *responseError.
+func readMessage(r *bufio.Reader) (*request, error) {
+	body, err := framing.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	if req.Method == "" {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "missing method"}
+	}
+	return &req, nil
+}
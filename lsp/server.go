@@ -0,0 +1,209 @@
+// Package lsp implements a Language Server Protocol server for Gates
+// scripts. It reports the syntax errors of the open documents and offers
+// hover documentation of the built-in functions, go-to-definition of
+// local names, completion and formatting.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lujjjh/gates/internal/framing"
+)
+
+// ErrNoShutdown is returned by Serve if the client asks the server to
+// exit before shutting it down.
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+type server struct {
+	w        io.Writer
+	docs     map[string]*document
+	shutdown bool
+}
+
+// Serve reads requests from r and writes responses and notifications to
+// w, typically the standard input and output of the server process. It
+// returns when the client sends the exit notification or r is closed.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{
+		w:    w,
+		docs: make(map[string]*document),
+	}
+	in := bufio.NewReader(r)
+	for {
+		req, err := readMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		if e, ok := err.(*responseError); ok {
+			if err := framing.Write(w, &response{JSONRPC: "2.0", Error: e}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a request or a notification. The error is set only if
+// the output fails.
+func (s *server) handle(req *request) error {
+	result, err := s.dispatch(req)
+	if req.ID == nil {
+		// a notification has no response
+		if _, ok := err.(*responseError); ok {
+			return nil
+		}
+		return err
+	}
+	resp := &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+	if err != nil {
+		e, ok := err.(*responseError)
+		if !ok {
+			return err
+		}
+		resp.Result, resp.Error = nil, e
+	}
+	return framing.Write(s.w, resp)
+}
+
+func (s *server) dispatch(req *request) (interface{}, error) {
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shut down"}
+	}
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // full
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"completionProvider":         map[string]interface{}{"triggerCharacters": []string{"."}},
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "gates"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc := newDocument(params.TextDocument.URI, params.TextDocument.Text)
+		s.docs[doc.uri] = doc
+		return nil, s.publishDiagnostics(doc)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		n := len(params.ContentChanges)
+		if n == 0 {
+			return nil, nil
+		}
+		// the changes replace the whole text, see textDocumentSync
+		doc := newDocument(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		s.docs[doc.uri] = doc
+		return nil, s.publishDiagnostics(doc)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, framing.Write(s.w, &notification{
+			JSONRPC: "2.0",
+			Method:  "textDocument/publishDiagnostics",
+			Params:  &publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}},
+		})
+
+	case "textDocument/hover":
+		doc, offset, err := s.documentPosition(req)
+		if err != nil {
+			return nil, err
+		}
+		return doc.hover(offset), nil
+	case "textDocument/definition":
+		doc, offset, err := s.documentPosition(req)
+		if err != nil {
+			return nil, err
+		}
+		return doc.definition(offset), nil
+	case "textDocument/completion":
+		doc, offset, err := s.documentPosition(req)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completion(offset), nil
+	case "textDocument/formatting":
+		var params formattingParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.format(), nil
+	}
+
+	if req.ID == nil {
+		// notifications such as initialized may be ignored
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+}
+
+func unmarshalParams(req *request, v interface{}) error {
+	if err := json.Unmarshal(req.Params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown document %s", uri)}
+	}
+	return doc, nil
+}
+
+// documentPosition returns the document and the byte offset of the
+// position of a request.
+func (s *server) documentPosition(req *request) (*document, int, error) {
+	var params textDocumentPositionParams
+	if err := unmarshalParams(req, &params); err != nil {
+		return nil, 0, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, doc.offset(params.Position), nil
+}
+
+func (s *server) publishDiagnostics(doc *document) error {
+	return framing.Write(s.w, &notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  &publishDiagnosticsParams{URI: doc.uri, Diagnostics: doc.diagnostics()},
+	})
+}
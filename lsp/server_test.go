@@ -0,0 +1,227 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/lujjjh/gates/internal/framing"
+	"github.com/stretchr/testify/assert"
+)
+
+const uri = "file:///rules/rule.gates"
+
+const src = `let total = 0;
+let upper = strings.to_upper("x");
+for (let i = 0; i < 3; i = i + 1) {
+  total = total + i;
+}
+[total, upper] | map(string)
+`
+
+// session runs the server on the messages and returns what it writes.
+func session(t *testing.T, messages ...interface{}) []map[string]interface{} {
+	var in bytes.Buffer
+	for _, m := range messages {
+		assert.NoError(t, framing.Write(&in, m))
+	}
+	var out bytes.Buffer
+	assert.NoError(t, Serve(&in, &out))
+
+	var results []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for {
+		body, err := framing.Read(r)
+		if err == io.EOF {
+			return results
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		assert.NoError(t, json.Unmarshal(body, &m))
+		results = append(results, m)
+	}
+}
+
+func call(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notify(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+func open(text string) map[string]interface{} {
+	return notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "version": 1, "text": text},
+	})
+}
+
+func at(id int, method string, line, character int) map[string]interface{} {
+	return call(id, method, map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     Position{Line: line, Character: character},
+	})
+}
+
+func toJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func TestServer(t *testing.T) {
+	out := session(t,
+		call(1, "initialize", map[string]interface{}{}),
+		notify("initialized", map[string]interface{}{}),
+		open(src),
+		at(2, "textDocument/hover", 5, 18),
+		at(3, "textDocument/hover", 1, 22),
+		at(4, "textDocument/hover", 3, 3),
+		at(5, "textDocument/definition", 3, 11),
+		at(6, "textDocument/definition", 5, 2),
+		call(7, "shutdown", nil),
+		notify("exit", nil),
+	)
+	if !assert.Len(t, out, 8) {
+		return
+	}
+
+	caps := out[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	assert.Equal(t, true, caps["hoverProvider"])
+	assert.Equal(t, true, caps["documentFormattingProvider"])
+
+	assert.Equal(t, "textDocument/publishDiagnostics", out[1]["method"])
+	assert.Equal(t, `{"diagnostics":[],"uri":"file:///rules/rule.gates"}`, toJSON(out[1]["params"]))
+
+	// map in the pipeline and strings.to_upper
+	assert.Equal(t, `{"contents":{"kind":"markdown","value":"`+"```gates\\nmap(f, xs)\\n```"+`\n\nCalls f(x, i) for the elements of xs and returns the results. Curried."},"range":{"end":{"character":20,"line":5},"start":{"character":17,"line":5}}}`, toJSON(out[2]["result"]))
+	assert.Contains(t, toJSON(out[3]["result"]), "strings.to_upper(s)")
+	// a local name has no documentation
+	assert.Nil(t, out[4]["result"])
+
+	assert.Equal(t, `[{"range":{"end":{"character":9,"line":0},"start":{"character":4,"line":0}},"uri":"file:///rules/rule.gates"}]`, toJSON(out[5]["result"]))
+	assert.Equal(t, `[{"range":{"end":{"character":9,"line":0},"start":{"character":4,"line":0}},"uri":"file:///rules/rule.gates"}]`, toJSON(out[6]["result"]))
+
+	assert.Contains(t, out[7], "result")
+	assert.Nil(t, out[7]["result"])
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	// the statements that parse are resolved in spite of the errors
+	out := session(t,
+		open("let total = 0;\nlet x = (1;\nlet map = (f, xs) => xs;\ntotal = total + 1;\nmap(string, [total]);\n"),
+		at(1, "textDocument/definition", 3, 9),
+		at(2, "textDocument/hover", 4, 1),
+		at(3, "textDocument/hover", 4, 5),
+	)
+	if !assert.Len(t, out, 4) {
+		return
+	}
+	assert.Equal(t, `[{"range":{"end":{"character":9,"line":0},"start":{"character":4,"line":0}},"uri":"file:///rules/rule.gates"}]`, toJSON(out[1]["result"]))
+	// map is declared by the script, so it is not the built-in
+	assert.Nil(t, out[2]["result"])
+	assert.Contains(t, toJSON(out[3]["result"]), "string(v)")
+}
+
+func TestDiagnostics(t *testing.T) {
+	out := session(t,
+		open("let a = ;\nlet b = (1;\nc = 1 = 2;\n"),
+		notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []map[string]string{{"text": "let a = 1;\n1 = a;\n"}},
+		}),
+		notify("textDocument/didClose", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+		}),
+	)
+	if !assert.Len(t, out, 3) {
+		return
+	}
+	var diagnostics []string
+	for _, m := range out {
+		var params publishDiagnosticsParams
+		b, _ := json.Marshal(m["params"])
+		assert.NoError(t, json.Unmarshal(b, &params))
+		var list []string
+		for _, d := range params.Diagnostics {
+			list = append(list, fmt.Sprintf("%d:%d-%d:%d %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Message))
+		}
+		diagnostics = append(diagnostics, fmt.Sprint(list))
+	}
+	assert.Equal(t, []string{
+		"[0:8-0:9 expected operand, found ';' 1:10-1:11 expected ')', found ';' 2:6-2:7 expected ';', found '=']",
+		"[1:0-1:1 not a valid left-value expression]",
+		"[]",
+	}, diagnostics)
+}
+
+func TestCompletion(t *testing.T) {
+	text := "let x = strings.to\nx + price * \n"
+	out := session(t,
+		open(text),
+		at(1, "textDocument/completion", 0, 18),
+		at(2, "textDocument/completion", 1, 12),
+	)
+	if !assert.Len(t, out, 3) {
+		return
+	}
+	var labels []string
+	for _, item := range out[1]["result"].([]interface{}) {
+		labels = append(labels, item.(map[string]interface{})["label"].(string))
+	}
+	assert.Contains(t, labels, "to_upper")
+	assert.Contains(t, labels, "has_prefix")
+	assert.NotContains(t, labels, "map")
+
+	labels = nil
+	for _, item := range out[2]["result"].([]interface{}) {
+		labels = append(labels, item.(map[string]interface{})["label"].(string))
+	}
+	assert.Contains(t, labels, "map")
+	assert.Contains(t, labels, "strings")
+	assert.Contains(t, labels, "let")
+	assert.NotContains(t, labels, "to_upper")
+}
+
+func TestFormatting(t *testing.T) {
+	format := func(id int) map[string]interface{} {
+		return call(id, "textDocument/formatting", map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"options":      map[string]interface{}{"tabSize": 2, "insertSpaces": true},
+		})
+	}
+	out := session(t,
+		open("let a=[1,2]; // list\na"),
+		format(1),
+		open("let a = ;"),
+		format(2),
+		call(3, "textDocument/unknown", nil),
+	)
+	if !assert.Len(t, out, 5) {
+		return
+	}
+	assert.Equal(t, `[{"newText":"let a = [ 1, 2 ]; // list\na\n","range":{"end":{"character":1,"line":1},"start":{"character":0,"line":0}}}]`, toJSON(out[1]["result"]))
+	assert.Nil(t, out[3]["result"])
+	assert.Equal(t, float64(codeMethodNotFound), out[4]["error"].(map[string]interface{})["code"])
+}
+
+func TestPositions(t *testing.T) {
+	d := newDocument(uri, "a\n\U0001F600b = 1\n")
+	assert.Equal(t, "rule.gates", d.filename)
+	for offset, pos := range map[int]Position{
+		0:  {0, 0},
+		2:  {1, 0},
+		6:  {1, 2},
+		7:  {1, 3},
+		12: {2, 0},
+	} {
+		assert.Equal(t, pos, d.position(offset), offset)
+		assert.Equal(t, offset, d.offset(pos), pos)
+	}
+	// positions past the end of a line are clamped
+	assert.Equal(t, 1, d.offset(Position{0, 10}))
+}
@@ -3,7 +3,6 @@ package gates
 import (
 	"math"
 	"reflect"
-	"sort"
 )
 
 type Map map[string]Value
@@ -54,7 +53,7 @@ func (m Map) toNative(seen map[interface{}]interface{}, options int) interface{}
 }
 
 func (m Map) Equals(other Value) bool {
-	o, ok := other.(Map)
+	o, ok := underlying(other).(Map)
 	if !ok {
 		return false
 	}
@@ -77,13 +76,19 @@ func (m Map) Set(r *Runtime, key, value Value) {
 	m[key.ToString()] = value
 }
 
-func (m Map) Iterator() Iterator {
-	var keys []string
-	for k := range m {
-		keys = append(keys, k)
+func (m Map) Keys(r *Runtime) []Value {
+	keys := sortedKeys(m)
+	values := make([]Value, len(keys))
+	for i, k := range keys {
+		values[i] = String(k)
 	}
-	sort.Strings(keys)
-	return &mapIter{m: m, i: 0, keys: keys}
+	return values
+}
+
+func (m Map) Length(r *Runtime) int { return len(m) }
+
+func (m Map) Iterator() Iterator {
+	return &mapIter{m: m, i: 0, keys: sortedKeys(m)}
 }
 
 func (m *mapIter) Next() (Value, bool) {
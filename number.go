@@ -31,6 +31,9 @@ func (i Int) ToFunction() Function                   { return _EmptyFunction }
 func (i Int) ToNative(...ToNativeOption) interface{} { return i.ToInt() }
 
 func (i Int) Equals(other Value) bool {
+	if d, ok := other.(Decimal); ok {
+		return d.Equals(i)
+	}
 	switch {
 	case other.IsInt():
 		return i.ToInt() == other.ToInt()
@@ -67,6 +70,9 @@ func (f Float) ToFunction() Function                   { return _EmptyFunction }
 func (f Float) ToNative(...ToNativeOption) interface{} { return f.ToFloat() }
 
 func (f Float) Equals(other Value) bool {
+	if d, ok := other.(Decimal); ok {
+		return d.Equals(f)
+	}
 	switch {
 	case other.IsInt():
 		return f.ToFloat() == other.ToFloat()
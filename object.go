@@ -1,11 +1,48 @@
 package gates
 
-type getter interface {
-	Get(*Runtime, Value) Value
+import "sort"
+
+// The following interfaces may be implemented by Go types to act as
+// objects in scripts. Values wrapped in a Ref (see ToValue) that implement
+// them are used in selectors, index expressions, spreads and calls like the
+// built-in types.
+
+// Getter is implemented by objects whose properties can be read, as in
+// x.key or x[key].
+type Getter interface {
+	Get(r *Runtime, key Value) Value
+}
+
+// Setter is implemented by objects whose properties can be assigned.
+type Setter interface {
+	Set(r *Runtime, key, value Value)
+}
+
+// Keyer is implemented by objects that can enumerate their properties.
+// Objects that implement Keyer and Getter but not Iterable are iterated
+// as a list of { key, value } entries, like maps.
+type Keyer interface {
+	Keys(r *Runtime) []Value
+}
+
+// Lengther is implemented by objects that have a length, which is used for
+// x.length unless the object implements Getter.
+type Lengther interface {
+	Length(r *Runtime) int
+}
+
+// Callable is implemented by objects that can be called like functions.
+type Callable interface {
+	Call(fc FunctionCall) Value
+}
+
+// Typer is implemented by objects that report their type tag, see Type.
+type Typer interface {
+	Type() string
 }
 
-type setter interface {
-	Set(*Runtime, Value, Value)
+type slicer interface {
+	Slice(r *Runtime, low, high Value) Value
 }
 
 type getterFunc func(*Runtime, Value) Value
@@ -14,23 +51,114 @@ func (g getterFunc) Get(r *Runtime, v Value) Value { return g(r, v) }
 
 func objectGet(r *Runtime, base interface{}, key Value) Value {
 	base = unref(base)
-	g, ok := base.(getter)
+	g, ok := base.(Getter)
 	if !ok {
-		m, ok := base.(map[string]Value)
-		if !ok {
-			return Null
+		if m, ok := base.(map[string]Value); ok {
+			return Map(m).Get(r, key)
+		}
+		if l, ok := base.(Lengther); ok && key.IsString() && key.ToString() == "length" {
+			return Int(l.Length(r))
 		}
-		g = Map(m)
+		return Null
 	}
 	return g.Get(r, key)
 }
 
 func objectSet(r *Runtime, base interface{}, key, value Value) {
 	base = unref(base)
-	g, ok := base.(setter)
+	g, ok := base.(Setter)
 	if !ok {
 		return
 	}
 	g.Set(r, key, value)
 	return
 }
+
+// keyerIter iterates the properties of an object implementing Keyer and
+// Getter as { key, value } entries.
+type keyerIter struct {
+	r    *Runtime
+	base Getter
+	keys []Value
+	i    int
+}
+
+func (it *keyerIter) Next() (Value, bool) {
+	if it.i >= len(it.keys) {
+		return Null, false
+	}
+	k := it.keys[it.i]
+	it.i++
+	return Map{
+		"key":   k,
+		"value": it.base.Get(it.r, k),
+	}, true
+}
+
+type keyerIterable struct {
+	r    *Runtime
+	base interface {
+		Keyer
+		Getter
+	}
+}
+
+func (i keyerIterable) Iterator() Iterator {
+	return &keyerIter{r: i.r, base: i.base, keys: i.base.Keys(i.r)}
+}
+
+func sortedKeys(m Map) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func objectSlice(r *Runtime, base interface{}, low, high Value) Value {
+	base = unref(base)
+	s, ok := base.(slicer)
+	if !ok {
+		return Null
+	}
+	return s.Slice(r, low, high)
+}
+
+// sliceBounds resolves the bounds of a slice expression against a sequence
+// of the given length. Null bounds are omitted, negative bounds count from
+// the end, and out-of-range bounds are clamped.
+func sliceBounds(low, high Value, length int) (int, int, bool) {
+	resolve := func(v Value, omitted int) (int, bool) {
+		if v == Null {
+			return omitted, true
+		}
+		n := v.ToNumber()
+		if !n.IsInt() {
+			return 0, false
+		}
+		i := n.ToInt()
+		if i < 0 {
+			i += int64(length)
+		}
+		switch {
+		case i < 0:
+			return 0, true
+		case i > int64(length):
+			return length, true
+		}
+		return int(i), true
+	}
+	l, ok := resolve(low, 0)
+	if !ok {
+		return 0, 0, false
+	}
+	h, ok := resolve(high, length)
+	if !ok {
+		return 0, 0, false
+	}
+	if h < l {
+		h = l
+	}
+	return l, h, true
+}
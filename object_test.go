@@ -0,0 +1,96 @@
+package gates
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type hostObject struct {
+	props map[string]Value
+}
+
+func (o *hostObject) Type() string { return "host" }
+
+func (o *hostObject) Get(r *Runtime, key Value) Value {
+	if v, ok := o.props[key.ToString()]; ok {
+		return v
+	}
+	return Null
+}
+
+func (o *hostObject) Set(r *Runtime, key, value Value) { o.props[key.ToString()] = value }
+
+func (o *hostObject) Keys(r *Runtime) []Value {
+	var keys []string
+	for k := range o.props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]Value, len(keys))
+	for i, k := range keys {
+		values[i] = String(k)
+	}
+	return values
+}
+
+type hostCallable struct{}
+
+func (hostCallable) Call(fc FunctionCall) Value {
+	return Int(len(fc.Args()))
+}
+
+type hostList []int
+
+func (l hostList) Length(r *Runtime) int { return len(l) }
+
+func (l hostList) Iterator() Iterator {
+	values := make([]Value, len(l))
+	for i, v := range l {
+		values[i] = Int(v)
+	}
+	return NewArray(values).Iterator()
+}
+
+func TestHostObjects(t *testing.T) {
+	o := &hostObject{props: map[string]Value{"foo": Int(42)}}
+	r := New()
+	r.Global().Set("o", ToValue(o))
+	r.Global().Set("f", ToValue(hostCallable{}))
+	r.Global().Set("l", ToValue(hostList{1, 2, 3}))
+
+	program, err := CompileScript("", `
+		o.bar = o["foo"] + 1;
+		[
+			type(o),
+			o.bar,
+			{ ...o },
+			to_entries(o),
+			type(f),
+			f(1, 2),
+			[1, 2] | f,
+			l.length,
+			[...l]
+		]
+	`)
+	assert.NoError(t, err)
+	v, err := r.RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		"host",
+		int64(43),
+		map[string]interface{}{"foo": int64(42), "bar": int64(43)},
+		[]interface{}{
+			map[string]interface{}{"key": "bar", "value": int64(43)},
+			map[string]interface{}{"key": "foo", "value": int64(42)},
+		},
+		"function",
+		int64(2),
+		int64(1),
+		int64(3),
+		[]interface{}{int64(1), int64(2), int64(3)},
+	}, v.ToNative())
+	assert.Equal(t, Int(43), o.props["bar"])
+}
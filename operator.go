@@ -0,0 +1,124 @@
+package gates
+
+// The following interfaces may be implemented by Go types to overload the
+// operators of scripts. They are consulted when an operand is a Ref
+// wrapping such a type (see ToValue). The binary operators are tried on
+// the left operand first; if reversed is true, the receiver is the right
+// operand. An ok result of false falls back to the default behavior.
+
+// Adder overloads the + operator.
+type Adder interface {
+	Add(other Value, reversed bool) (result Value, ok bool)
+}
+
+// Subtracter overloads the - operator.
+type Subtracter interface {
+	Sub(other Value, reversed bool) (result Value, ok bool)
+}
+
+// Multiplier overloads the * operator.
+type Multiplier interface {
+	Mul(other Value, reversed bool) (result Value, ok bool)
+}
+
+// Divider overloads the / operator.
+type Divider interface {
+	Div(other Value, reversed bool) (result Value, ok bool)
+}
+
+// Negater overloads the unary - operator.
+type Negater interface {
+	Negate() Value
+}
+
+// Comparer overloads the <, <=, > and >= operators. Compare returns a
+// negative number, zero or a positive number if the receiver is less than,
+// equal to or greater than other.
+type Comparer interface {
+	Compare(other Value) (result int, ok bool)
+}
+
+// Equaler overloads the == and != operators.
+type Equaler interface {
+	Equal(other Value) bool
+}
+
+func hostOperand(v Value) interface{} {
+	if r, ok := v.(Ref); ok {
+		return r.v
+	}
+	return nil
+}
+
+func hostAdd(x, y Value) (Value, bool) {
+	if o, ok := hostOperand(x).(Adder); ok {
+		if v, ok := o.Add(y, false); ok {
+			return v, true
+		}
+	}
+	if o, ok := hostOperand(y).(Adder); ok {
+		return o.Add(x, true)
+	}
+	return nil, false
+}
+
+func hostSub(x, y Value) (Value, bool) {
+	if o, ok := hostOperand(x).(Subtracter); ok {
+		if v, ok := o.Sub(y, false); ok {
+			return v, true
+		}
+	}
+	if o, ok := hostOperand(y).(Subtracter); ok {
+		return o.Sub(x, true)
+	}
+	return nil, false
+}
+
+func hostMul(x, y Value) (Value, bool) {
+	if o, ok := hostOperand(x).(Multiplier); ok {
+		if v, ok := o.Mul(y, false); ok {
+			return v, true
+		}
+	}
+	if o, ok := hostOperand(y).(Multiplier); ok {
+		return o.Mul(x, true)
+	}
+	return nil, false
+}
+
+func hostDiv(x, y Value) (Value, bool) {
+	if o, ok := hostOperand(x).(Divider); ok {
+		if v, ok := o.Div(y, false); ok {
+			return v, true
+		}
+	}
+	if o, ok := hostOperand(y).(Divider); ok {
+		return o.Div(x, true)
+	}
+	return nil, false
+}
+
+func hostCompare(x, y Value) (int, bool) {
+	if o, ok := hostOperand(x).(Comparer); ok {
+		if c, ok := o.Compare(y); ok {
+			return c, true
+		}
+	}
+	if o, ok := hostOperand(y).(Comparer); ok {
+		if c, ok := o.Compare(x); ok {
+			return -c, true
+		}
+	}
+	return 0, false
+}
+
+// equals reports whether x == y, consulting Equaler on either operand.
+func equals(x, y Value) bool {
+	if o, ok := hostOperand(x).(Equaler); ok {
+		return o.Equal(y)
+	}
+	if o, ok := hostOperand(y).(Equaler); ok {
+		return o.Equal(x)
+	}
+	return x.Equals(y)
+}
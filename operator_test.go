@@ -0,0 +1,86 @@
+package gates
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type money int64
+
+func (m money) ToString() string { return fmt.Sprintf("$%d.%02d", m/100, m%100) }
+
+func toMoney(v Value) (money, bool) {
+	switch {
+	case v.IsInt():
+		return money(v.ToInt() * 100), true
+	default:
+		m, ok := unref(v).(money)
+		return m, ok
+	}
+}
+
+func (m money) Add(other Value, reversed bool) (Value, bool) {
+	o, ok := toMoney(other)
+	if !ok {
+		return nil, false
+	}
+	return ToValue(m + o), true
+}
+
+func (m money) Sub(other Value, reversed bool) (Value, bool) {
+	o, ok := toMoney(other)
+	if !ok {
+		return nil, false
+	}
+	if reversed {
+		return ToValue(o - m), true
+	}
+	return ToValue(m - o), true
+}
+
+func (m money) Mul(other Value, reversed bool) (Value, bool) {
+	if !other.IsInt() {
+		return nil, false
+	}
+	return ToValue(m * money(other.ToInt())), true
+}
+
+func (m money) Negate() Value { return ToValue(-m) }
+
+func (m money) Compare(other Value) (int, bool) {
+	o, ok := toMoney(other)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case m < o:
+		return -1, true
+	case m > o:
+		return 1, true
+	}
+	return 0, true
+}
+
+func (m money) Equal(other Value) bool {
+	o, ok := toMoney(other)
+	return ok && m == o
+}
+
+func TestOperatorOverloading(t *testing.T) {
+	global := map[string]Value{"price": ToValue(money(1050))}
+	run := func(s string) interface{} {
+		return mustRunStringWithGlobal(s, global).ToNative()
+	}
+
+	assert.Equal(t, money(2100), run(`price + price`))
+	assert.Equal(t, money(1150), run(`1 + price`))
+	assert.Equal(t, money(50), run(`11 - price`))
+	assert.Equal(t, money(-1050), run(`-price`))
+	assert.Equal(t, money(3150), run(`3 * price`))
+	assert.Equal(t, true, run(`price > 10 && price < 11 && 10 <= price && price != 10`))
+	assert.Equal(t, true, run(`price == price && price + 1 == 1 + price && 10 != price && price - 10 != 0`))
+	assert.Equal(t, false, run(`price == "10.50"`))
+	assert.Equal(t, true, run(`[price] == [price]`))
+}
@@ -0,0 +1,223 @@
+package gates
+
+// foldConstant evaluates the operator instruction ins with the given
+// constant operands on a scratch VM, so that folded expressions have
+// exactly the semantics of the instructions they replace. Operations that
+// depend on the configuration of the runtime, i.e. the division of
+// decimals, are not folded.
+func foldConstant(ins instruction, operands ...Value) (v Value, ok bool) {
+	if ins == instruction(div) {
+		for _, operand := range operands {
+			if _, isDecimal := operand.(Decimal); isDecimal {
+				return nil, false
+			}
+		}
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			v, ok = nil, false
+		}
+	}()
+	vm := &vm{decimalScale: DefaultDecimalScale}
+	for _, operand := range operands {
+		vm.stack.Push(operand)
+	}
+	ins.exec(vm)
+	if vm.stack.sp != 1 {
+		return nil, false
+	}
+	return vm.stack.Pop(), true
+}
+
+// jumpTarget returns the pc that the jump instruction at pc may transfer
+// control to.
+func jumpTarget(code []instruction, pc int) (target int, ok bool) {
+	switch ins := code[pc].(type) {
+	case jmp1:
+		return pc + int(ins), true
+	case jne:
+		return pc + int(ins), true
+	case jeq1:
+		return pc + int(ins), true
+	case jneq1:
+		return pc + int(ins), true
+	}
+	return 0, false
+}
+
+// setJumpTarget makes the jump instruction at pc transfer control to
+// target.
+func setJumpTarget(code []instruction, pc, target int) {
+	switch code[pc].(type) {
+	case jmp1:
+		code[pc] = jmp1(target - pc)
+	case jne:
+		code[pc] = jne(target - pc)
+	case jeq1:
+		code[pc] = jeq1(target - pc)
+	case jneq1:
+		code[pc] = jneq1(target - pc)
+	}
+}
+
+// threadJump follows the chain of jumps starting at the target of the
+// jump at pc and returns its final destination.
+func threadJump(code []instruction, pc int) int {
+	target, _ := jumpTarget(code, pc)
+	for n := 0; n < len(code); n++ {
+		var next int
+		switch ins := code[target].(type) {
+		case jmp1:
+			next = target + int(ins)
+		case jeq1:
+			// a conditional jump that peeks at the same value either
+			// jumps again or falls through
+			switch code[pc].(type) {
+			case jeq1:
+				next = target + int(ins)
+			case jneq1:
+				next = target + 1
+			default:
+				return target
+			}
+		case jneq1:
+			switch code[pc].(type) {
+			case jneq1:
+				next = target + int(ins)
+			case jeq1:
+				next = target + 1
+			default:
+				return target
+			}
+		default:
+			return target
+		}
+		if next == target {
+			return target
+		}
+		target = next
+	}
+	return target
+}
+
+// reachable marks the instructions of code that may be executed.
+func reachable(code []instruction) []bool {
+	live := make([]bool, len(code))
+	work := []int{0}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		for pc < len(code) && !live[pc] {
+			live[pc] = true
+			if target, ok := jumpTarget(code, pc); ok {
+				if _, ok := code[pc].(jmp1); ok {
+					pc = target
+					continue
+				}
+				work = append(work, target)
+			}
+			switch code[pc].(type) {
+			case _ret, _halt:
+				pc = len(code)
+			default:
+				pc++
+			}
+		}
+	}
+	return live
+}
+
+// optimize rewrites the code of p in place: jump chains are threaded and
+// unreachable code, noops and jumps to the next instruction are removed.
+// Jump offsets, the source map and the debugging tables are adjusted
+// accordingly.
+func optimize(p *Program) {
+	code := p.code
+	if len(code) == 0 {
+		return
+	}
+	for pc := range code {
+		if _, ok := jumpTarget(code, pc); ok {
+			setJumpTarget(code, pc, threadJump(code, pc))
+		}
+	}
+
+	keep := reachable(code)
+	for pc, ins := range code {
+		if ins == instruction(noop) {
+			keep[pc] = false
+		}
+	}
+
+	// newIndex[pc] is the new pc of the first kept instruction at or
+	// after pc; removing a jump may make another jump redundant
+	newIndex := make([]int, len(code)+1)
+	for {
+		n := 0
+		for pc := range code {
+			newIndex[pc] = n
+			if keep[pc] {
+				n++
+			}
+		}
+		newIndex[len(code)] = n
+
+		changed := false
+		for pc, ins := range code {
+			if j, ok := ins.(jmp1); ok && keep[pc] && newIndex[pc+int(j)] == newIndex[pc]+1 {
+				keep[pc] = false
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	optimized := make([]instruction, 0, newIndex[len(code)])
+	for pc, ins := range code {
+		if !keep[pc] {
+			continue
+		}
+		optimized = append(optimized, ins)
+		if target, ok := jumpTarget(code, pc); ok {
+			setJumpTarget(optimized, len(optimized)-1, newIndex[target])
+		}
+	}
+	p.code = optimized
+
+	srcMap := p.srcMap[:0]
+	for _, item := range p.srcMap {
+		item.pc = newIndex[item.pc]
+		if n := len(srcMap); n > 0 && srcMap[n-1].pc == item.pc {
+			srcMap[n-1].pos = item.pos
+		} else {
+			srcMap = append(srcMap, item)
+		}
+		if n := len(srcMap); n > 1 && srcMap[n-2].pos == srcMap[n-1].pos {
+			srcMap = srcMap[:n-1]
+		}
+	}
+	p.srcMap = srcMap
+
+	stmts := p.stmts[:0]
+	for _, item := range p.stmts {
+		item.pc = newIndex[item.pc]
+		if n := len(stmts); n > 0 && stmts[n-1].pc == item.pc {
+			stmts[n-1].pos = item.pos
+		} else {
+			stmts = append(stmts, item)
+		}
+	}
+	p.stmts = stmts
+
+	if p.stashNames != nil {
+		stashNames := make(map[int]map[string]uint32, len(p.stashNames))
+		for pc, names := range p.stashNames {
+			if keep[pc] {
+				stashNames[newIndex[pc]] = names
+			}
+		}
+		p.stashNames = stashNames
+	}
+}
@@ -0,0 +1,107 @@
+package gates
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected Value
+	}{
+		{`1 + 2 * 3`, Int(7)},
+		{`"a" + 1 + 2`, String("a12")},
+		{`9223372036854775807 * 2`, Float(18446744073709551614)},
+		{`7 % 0 == 7 % 0`, False},
+		{`-(2 - 5)`, Int(3)},
+		{`!0`, True},
+		{`1 << 4 ^ 3`, Int(19)},
+		{`"b" < "a" || 0 && x`, Int(0)},
+		{`1 && "y"`, String("y")},
+		{`0.1d + 0.2d == 0.3d`, True},
+	}
+	for _, test := range tests {
+		program, err := Compile(test.src)
+		if !assert.NoError(t, err, test.src) {
+			continue
+		}
+		assert.Equal(t, 2, program.InstructionNumber(), test.src)
+		v, err := New().RunProgram(context.Background(), program)
+		assert.NoError(t, err, test.src)
+		assert.Equal(t, test.expected, v, test.src)
+	}
+
+	// the division of decimals depends on the rounding of the runtime
+	program, err := Compile(`1d / 3d`)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, program.InstructionNumber())
+}
+
+func TestOptimize(t *testing.T) {
+	program, err := CompileScript("optimize.gates", `
+		let x = 1;
+		if (false) {
+			x = f(x);
+		} else {
+			x = x + 1;
+		}
+		if (true) {
+			x = x * 10;
+		}
+		x
+	`)
+	assert.NoError(t, err)
+	for _, ins := range program.code {
+		switch ins.(type) {
+		case _newStash, _popStash, _noop, _call, jmp1, jne:
+			t.Errorf("unexpected instruction %#v", ins)
+		}
+	}
+	v, err := New().RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 20, v.ToInt())
+
+	// code after a return is dead, and chains of logical operators jump
+	// straight to their end
+	program, err = Compile(`function (a, b, c) { return a || b || c; x(); }`)
+	assert.NoError(t, err)
+	f := program.code[0].(*newFunc).program
+	for i, ins := range f.code {
+		if _, ok := ins.(_ret); ok {
+			assert.Equal(t, len(f.code)-1, i)
+		}
+		if j, ok := ins.(jeq1); ok {
+			_, isRet := f.code[i+int(j)].(_ret)
+			assert.True(t, isRet, "jeq1 at %d is not threaded", i)
+		}
+	}
+
+	r := New()
+	for src, expected := range map[string]int64{
+		`(function (a, b, c) { return a || b || c; })(0, 0, 3)`:                              3,
+		`(function (a, b, c) { return a && b && c; })(1, 0, 3)`:                              0,
+		`(function (a, b) { return (a || b) && 5; })(0, 0)`:                                  0,
+		`(function (n) { let s = 0; for (;n > 0;) { s = s + n; n = n - 1; } return s; })(4)`: 10,
+	} {
+		v, err := r.RunString(src)
+		assert.NoError(t, err, src)
+		assert.EqualValues(t, expected, v.ToInt(), src)
+	}
+
+	// errors still report the position of the failing instruction
+	r.Global().Set("fail", FunctionFunc(func(fc FunctionCall) Value {
+		fc.Runtime().Throw(errors.New("fail"))
+		return Null
+	}))
+	program, err = CompileScript("pos.gates", "if (1) {\n  1 + 2 + fail();\n}")
+	assert.NoError(t, err)
+	_, err = r.RunProgram(context.Background(), program)
+	assert.EqualError(t, err, "fail at pos.gates:2:15")
+
+	_, err = CompileScript("dead.gates", `if (false) { 1 = 2; }`)
+	assert.EqualError(t, err, "SyntaxError: not a valid left-value expression at dead.gates:1:14")
+}
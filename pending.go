@@ -0,0 +1,101 @@
+package gates
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// Pending is the result of a native function that is not available yet,
+// e.g. a database lookup running in another goroutine. A native function
+// returns a Pending and settles it later with Resolve or Reject.
+//
+// An Execution suspends at the call until the Pending is settled, see
+// Execution.Pending. Elsewhere, the run waits for the result.
+type Pending struct {
+	once  sync.Once
+	done  chan struct{}
+	value Value
+	err   error
+}
+
+// NewPending returns a Pending that is not settled yet.
+func NewPending() *Pending {
+	return &Pending{done: make(chan struct{})}
+}
+
+// Resolve settles p with the value v. Only the first settlement of p has
+// an effect.
+func (p *Pending) Resolve(v Value) {
+	p.once.Do(func() {
+		p.value = v
+		close(p.done)
+	})
+}
+
+// Reject settles p with err, which fails the program waiting for p. Only
+// the first settlement of p has an effect.
+func (p *Pending) Reject(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.done)
+	})
+}
+
+// Done returns a channel that is closed once p is settled.
+func (p *Pending) Done() <-chan struct{} { return p.done }
+
+// wait waits until p is settled and returns its result.
+func (p *Pending) wait(ctx context.Context) (Value, error) {
+	select {
+	case <-p.done:
+		return p.value, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (*Pending) Type() string { return "pending" }
+
+func (*Pending) IsString() bool   { return false }
+func (*Pending) IsInt() bool      { return false }
+func (*Pending) IsFloat() bool    { return false }
+func (*Pending) IsBool() bool     { return false }
+func (*Pending) IsFunction() bool { return false }
+
+func (*Pending) ToString() string                         { return "[object Pending]" }
+func (*Pending) ToInt() int64                             { return 0 }
+func (*Pending) ToFloat() float64                         { return math.NaN() }
+func (*Pending) ToNumber() Number                         { return Float(math.NaN()) }
+func (*Pending) ToBool() bool                             { return true }
+func (*Pending) ToFunction() Function                     { return _EmptyFunction }
+func (p *Pending) ToNative(...ToNativeOption) interface{} { return p }
+
+func (p *Pending) Equals(other Value) bool {
+	return (interface{})(p) == (interface{})(other)
+}
+
+func (p *Pending) SameAs(other Value) bool { return p.Equals(other) }
+
+// AsyncFunctionFunc returns a native function that runs f in a new
+// goroutine and returns its result as a Pending. f receives the context of
+// the run and a copy of the arguments; it must not use the Runtime.
+func AsyncFunctionFunc(f func(ctx context.Context, args []Value) (Value, error)) Function {
+	return FunctionFunc(func(fc FunctionCall) Value {
+		ctx := fc.Runtime().Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		args := append([]Value(nil), fc.Args()...)
+		p := NewPending()
+		go func() {
+			v, err := f(ctx, args)
+			if err != nil {
+				p.Reject(err)
+				return
+			}
+			p.Resolve(ToValue(v))
+		}()
+		return p
+	})
+}
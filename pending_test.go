@@ -0,0 +1,82 @@
+package gates
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingExecution(t *testing.T) {
+	ctx := context.Background()
+	var calls []*Pending
+	r := New()
+	r.Global().Set("lookup", FunctionFunc(func(fc FunctionCall) Value {
+		p := NewPending()
+		calls = append(calls, p)
+		return p
+	}))
+
+	program, err := CompileScript("lookup.gates", `
+let a = lookup("a");
+let b = lookup("b");
+a + b
+`)
+	assert.NoError(t, err)
+	e := r.NewExecution(program, nil)
+
+	done, err := e.Run(ctx, 0)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Len(t, calls, 1)
+	assert.Equal(t, calls[0], e.Pending())
+	calls[0].Resolve(Int(1))
+
+	done, err = e.Run(ctx, 0)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Len(t, calls, 2)
+	go calls[1].Resolve(Int(2))
+
+	done, err = e.Run(ctx, 0)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Nil(t, e.Pending())
+	assert.EqualValues(t, 3, e.Result().ToInt())
+
+	e = r.NewExecution(program, nil)
+	_, err = e.Run(ctx, 0)
+	assert.NoError(t, err)
+	e.Pending().Reject(errors.New("not found"))
+	done, err = e.Run(ctx, 0)
+	assert.True(t, done)
+	assert.EqualError(t, err, "not found at lookup.gates:2:15")
+
+	e = r.NewExecution(program, nil)
+	_, err = e.Run(ctx, 0)
+	assert.NoError(t, err)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	done, err = e.Run(canceled, 0)
+	assert.False(t, done)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestAsyncFunctionFunc(t *testing.T) {
+	r := New()
+	r.Global().Set("double", AsyncFunctionFunc(func(ctx context.Context, args []Value) (Value, error) {
+		if args[0].ToInt() < 0 {
+			return nil, errors.New("negative")
+		}
+		return Int(args[0].ToInt() * 2), nil
+	}))
+
+	// outside an Execution the run waits for the result
+	v, err := r.RunString(`map(double, [1, 2, 3])[-1] + double(4)`)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 14, v.ToInt())
+
+	_, err = r.RunString(`map(double, [1, -2])`)
+	assert.EqualError(t, err, "negative at 1:4")
+}
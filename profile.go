@@ -0,0 +1,364 @@
+package gates
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrProfiling is returned by Runtime.StartProfile if a profile is already
+// being recorded.
+var ErrProfiling = errors.New("profiling already in progress")
+
+// defaultProfilePeriod is the number of cycles between two samples of the
+// call stack.
+const defaultProfilePeriod = 100
+
+// StartProfile starts recording a profile of the programs run by r, which
+// StopProfile writes to w in the pprof format, so that it can be viewed
+// with go tool pprof. The profile attributes the cycles, sampled every 100
+// cycles, and the objects created by the instructions of the programs,
+// such as arrays, maps, closures and concatenated strings, to the stacks
+// of script functions and source lines where they happen. Objects created
+// by native functions are not counted.
+func (r *Runtime) StartProfile(w io.Writer) error {
+	if r.vm.profile != nil {
+		return ErrProfiling
+	}
+	r.vm.profile = newProfiler(w, defaultProfilePeriod)
+	return nil
+}
+
+// StopProfile stops the current profile, if any, and writes it.
+func (r *Runtime) StopProfile() error {
+	p := r.vm.profile
+	if p == nil {
+		return nil
+	}
+	r.vm.profile = nil
+	return p.write()
+}
+
+type profiler struct {
+	w      io.Writer
+	start  time.Time
+	period int
+
+	// remaining is the number of cycles until the next sample.
+	remaining int
+
+	// samples holds the sampled stacks, keyed by the IDs of their
+	// locations.
+	samples map[string]*profileSample
+	order   []*profileSample
+
+	// locations assigns the IDs of the locations at each pc, which are
+	// shared by the pcs of the same line of a function, and functions
+	// those of the programs.
+	pcs       map[programPC]uint64
+	locations map[profileLine]uint64
+	lines     []profileLine
+	functions map[*Program]uint64
+	programs  []*Program
+
+	// stack and key are reused to collect the stacks.
+	stack []uint64
+	key   []byte
+}
+
+type programPC struct {
+	program *Program
+	pc      int
+}
+
+type profileLine struct {
+	function uint64
+	line     int
+}
+
+type profileSample struct {
+	locations   []uint64
+	cycles      int64
+	allocations int64
+}
+
+func newProfiler(w io.Writer, period int) *profiler {
+	return &profiler{
+		w:         w,
+		start:     time.Now(),
+		period:    period,
+		remaining: period,
+		samples:   make(map[string]*profileSample),
+		pcs:       make(map[programPC]uint64),
+		locations: make(map[profileLine]uint64),
+		functions: make(map[*Program]uint64),
+	}
+}
+
+// step is called before each instruction is executed.
+func (p *profiler) step(vm *vm) {
+	if p.remaining--; p.remaining <= 0 {
+		p.remaining = p.period
+		p.sample(vm).cycles += int64(p.period)
+	}
+	if allocates(vm) {
+		p.sample(vm).allocations++
+	}
+}
+
+// allocates reports whether the instruction about to be executed creates
+// an object.
+func allocates(vm *vm) bool {
+	switch vm.program.code[vm.pc].(type) {
+	case newArray, _arrayConcat, newMap, _mapConcat, *newFunc, _newStash:
+		return true
+	case _add:
+		x, y := vm.stack.l[vm.stack.sp-2], vm.stack.l[vm.stack.sp-1]
+		return x.IsString() || y.IsString()
+	}
+	return false
+}
+
+// sample returns the sample of the current stack.
+func (p *profiler) sample(vm *vm) *profileSample {
+	p.stack = append(p.stack[:0], p.location(vm.program, vm.pc))
+	for i := len(vm.callStack) - 1; i >= 0; i-- {
+		ctx := vm.callStack[i]
+		// the saved pc follows the call, see Frame.Caller
+		p.stack = append(p.stack, p.location(ctx.program, ctx.pc-1))
+	}
+
+	p.key = p.key[:0]
+	for _, id := range p.stack {
+		p.key = appendVarint(p.key, id)
+	}
+	s, ok := p.samples[string(p.key)]
+	if !ok {
+		s = &profileSample{locations: append([]uint64(nil), p.stack...)}
+		p.samples[string(p.key)] = s
+		p.order = append(p.order, s)
+	}
+	return s
+}
+
+// location returns the ID of the location of the instruction at pc. pc is
+// negative if the program called a native function that called back; the
+// line of the call is unknown then, and that of the function literal, if
+// any, is used instead.
+func (p *profiler) location(program *Program, pc int) uint64 {
+	key := programPC{program, pc}
+	if id, ok := p.pcs[key]; ok {
+		return id
+	}
+	line := profileLine{function: p.function(program)}
+	if program.src != nil {
+		// the instructions preceding the first statement of a function
+		// are on the line of its literal
+		pos := program.fn
+		if pc >= 0 {
+			if p := program.linePos(pc); p.IsValid() {
+				pos = p
+			}
+		}
+		if pos.IsValid() {
+			line.line = program.src.Position(pos).Line
+		}
+	}
+	id, ok := p.locations[line]
+	if !ok {
+		p.lines = append(p.lines, line)
+		id = uint64(len(p.lines))
+		p.locations[line] = id
+	}
+	p.pcs[key] = id
+	return id
+}
+
+func (p *profiler) function(program *Program) uint64 {
+	if id, ok := p.functions[program]; ok {
+		return id
+	}
+	p.programs = append(p.programs, program)
+	id := uint64(len(p.programs))
+	p.functions[program] = id
+	return id
+}
+
+// profileName returns the name of the function of program in profiles. It
+// is like Frame.Name without the angle brackets, which pprof strips, and
+// tells anonymous functions apart by their position.
+func profileName(program *Program) string {
+	switch {
+	case !program.fn.IsValid():
+		return "script"
+	case program.name != "":
+		return program.name
+	case program.src != nil:
+		pos := program.src.Position(program.fn)
+		return fmt.Sprintf("anonymous:%d:%d", pos.Line, pos.Column)
+	}
+	return "anonymous"
+}
+
+// write writes the profile as a gzipped profile.proto message, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto.
+func (p *profiler) write() error {
+	var b protoBuffer
+	indexes := map[string]int64{"": 0}
+	stringTable := []string{""}
+	str := func(s string) int64 {
+		if i, ok := indexes[s]; ok {
+			return i
+		}
+		indexes[s] = int64(len(stringTable))
+		stringTable = append(stringTable, s)
+		return indexes[s]
+	}
+	valueType := func(typ, unit string) []byte {
+		var b protoBuffer
+		b.int64(1, str(typ))
+		b.int64(2, str(unit))
+		return b.bytes()
+	}
+
+	// profile.sample_type
+	b.message(1, valueType("cycles", "count"))
+	b.message(1, valueType("allocations", "count"))
+	for _, s := range p.order {
+		var sample protoBuffer
+		sample.uint64s(1, s.locations)
+		sample.int64s(2, []int64{s.cycles, s.allocations})
+		b.message(2, sample.bytes())
+	}
+	// profile.mapping: a single mapping telling pprof that the locations
+	// are symbolized
+	var mapping protoBuffer
+	mapping.uint64(1, 1)
+	mapping.int64(5, str("gates"))
+	mapping.bool(7, true)
+	mapping.bool(8, true)
+	mapping.bool(9, true)
+	b.message(3, mapping.bytes())
+	for i, line := range p.lines {
+		var l protoBuffer
+		l.uint64(1, line.function)
+		l.int64(2, int64(line.line))
+		var location protoBuffer
+		location.uint64(1, uint64(i+1))
+		location.uint64(2, 1)
+		location.message(4, l.bytes())
+		b.message(4, location.bytes())
+	}
+	for i, program := range p.programs {
+		var function protoBuffer
+		function.uint64(1, uint64(i+1))
+		name := str(profileName(program))
+		function.int64(2, name)
+		function.int64(3, name)
+		if program.src != nil {
+			function.int64(4, str(program.src.Name()))
+			if program.fn.IsValid() {
+				function.int64(5, int64(program.src.Position(program.fn).Line))
+			}
+		}
+		b.message(5, function.bytes())
+	}
+	b.int64(9, p.start.UnixNano())
+	b.int64(10, int64(time.Since(p.start)))
+	b.message(11, valueType("cycles", "count"))
+	b.int64(12, int64(p.period))
+	// profile.default_sample_type, so that pprof shows the cycles rather
+	// than the last sample type
+	b.int64(14, str("cycles"))
+	// the string table is written last, as it collects the strings above
+	var table protoBuffer
+	for _, s := range stringTable {
+		table.string(6, s)
+	}
+
+	zw := gzip.NewWriter(p.w)
+	if _, err := zw.Write(b.bytes()); err != nil {
+		return err
+	}
+	if _, err := zw.Write(table.bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuffer encodes the fields of a protocol buffer message. Fields with
+// the default value are omitted.
+type protoBuffer struct {
+	buf bytes.Buffer
+}
+
+func (b *protoBuffer) bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *protoBuffer) key(field int, wireType byte) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	var buf [10]byte
+	b.buf.Write(appendVarint(buf[:0], x))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bool(field int, x bool) {
+	if x {
+		b.uint64(field, 1)
+	}
+}
+
+func (b *protoBuffer) message(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.buf.Write(data)
+}
+
+// string encodes s even if it is empty, as it is an element of a
+// repeated field.
+func (b *protoBuffer) string(field int, s string) {
+	b.message(field, []byte(s))
+}
+
+// uint64s encodes a packed repeated field.
+func (b *protoBuffer) uint64s(field int, x []uint64) {
+	var packed []byte
+	for _, x := range x {
+		packed = appendVarint(packed, x)
+	}
+	b.message(field, packed)
+}
+
+func (b *protoBuffer) int64s(field int, x []int64) {
+	var packed []byte
+	for _, x := range x {
+		packed = appendVarint(packed, uint64(x))
+	}
+	b.message(field, packed)
+}
+
+func appendVarint(b []byte, x uint64) []byte {
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
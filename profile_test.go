@@ -0,0 +1,171 @@
+package gates
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// protoReader decodes a protocol buffer message.
+type protoReader []byte
+
+func (r *protoReader) varint() uint64 {
+	var x uint64
+	for shift := uint(0); ; shift += 7 {
+		c := (*r)[0]
+		*r = (*r)[1:]
+		x |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return x
+		}
+	}
+}
+
+// protoFields decodes the fields of a message, keeping the varints and
+// the bytes of the length-delimited fields.
+func protoFields(t *testing.T, b []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	r := protoReader(b)
+	for len(r) > 0 {
+		key := r.varint()
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			fields[field] = append(fields[field], r.varint())
+		case 2:
+			n := r.varint()
+			fields[field] = append(fields[field], []byte(r[:n]))
+			r = r[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func protoUint(fields map[int][]interface{}, field int) uint64 {
+	if len(fields[field]) == 0 {
+		return 0
+	}
+	return fields[field][0].(uint64)
+}
+
+// packed decodes the varints of a packed repeated field.
+func packed(b []byte) []uint64 {
+	var x []uint64
+	for r := protoReader(b); len(r) > 0; {
+		x = append(x, r.varint())
+	}
+	return x
+}
+
+// decodeProfile returns the sample types and the values of each stack of
+// a profile, written as "function:line" from the leaf.
+func decodeProfile(t *testing.T, b []byte) (types []string, stacks map[string][]int64) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	b, err = ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	profile := protoFields(t, b)
+
+	var table []string
+	for _, s := range profile[6] {
+		table = append(table, string(s.([]byte)))
+	}
+	for _, v := range profile[1] {
+		valueType := protoFields(t, v.([]byte))
+		types = append(types, table[protoUint(valueType, 1)]+"/"+table[protoUint(valueType, 2)])
+	}
+	functions := make(map[uint64]string)
+	for _, v := range profile[5] {
+		f := protoFields(t, v.([]byte))
+		functions[protoUint(f, 1)] = table[protoUint(f, 2)]
+		assert.Equal(t, "profile.gates", table[protoUint(f, 4)])
+	}
+	locations := make(map[uint64]string)
+	for _, v := range profile[4] {
+		l := protoFields(t, v.([]byte))
+		line := protoFields(t, l[4][0].([]byte))
+		locations[protoUint(l, 1)] = fmt.Sprintf("%s:%d", functions[protoUint(line, 1)], protoUint(line, 2))
+	}
+	stacks = make(map[string][]int64)
+	for _, v := range profile[2] {
+		s := protoFields(t, v.([]byte))
+		var stack []string
+		for _, id := range packed(s[1][0].([]byte)) {
+			stack = append(stack, locations[id])
+		}
+		var values []int64
+		for _, v := range packed(s[2][0].([]byte)) {
+			values = append(values, int64(v))
+		}
+		stacks[strings.Join(stack, " <- ")] = values
+	}
+	return types, stacks
+}
+
+func TestProfile(t *testing.T) {
+	program, err := CompileScript("profile.gates", `let square = function (x) {
+  return x * x;
+};
+let list = [1, 2, 3] | map((x) => square(x));
+let s = "a";
+[...list, s + "b"]
+`)
+	assert.NoError(t, err)
+	r := New()
+	var buf bytes.Buffer
+	assert.NoError(t, r.StartProfile(&buf))
+	assert.Equal(t, ErrProfiling, r.StartProfile(&buf))
+	// sample every cycle
+	r.vm.profile.period, r.vm.profile.remaining = 1, 1
+	v, err := r.RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.Equal(t, `[1, 4, 9, "ab"]`, Inspect(v))
+	assert.NoError(t, r.StopProfile())
+	assert.NoError(t, r.StopProfile())
+
+	types, stacks := decodeProfile(t, buf.Bytes())
+	assert.Equal(t, []string{"cycles/count", "allocations/count"}, types)
+	// the callback is called by map, a native function, so the line of
+	// the call is unknown
+	assert.Equal(t, map[string][]int64{
+		"script:1":                     {2, 1},
+		"script:4":                     {16, 2},
+		"script:5":                     {2, 0},
+		"script:6":                     {8, 3},
+		"anonymous:4:28:4 <- script:0": {21, 0},
+		"square:1 <- anonymous:4:28:4 <- script:0": {6, 0},
+		"square:2 <- anonymous:4:28:4 <- script:0": {12, 0},
+	}, stacks)
+
+	// pprof itself reads the profile, and shows the cycles by default
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool pprof is not available")
+	}
+	f, err := ioutil.TempFile("", "profile")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf.Bytes())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	out, err := exec.Command(goTool, "tool", "pprof", "-raw", f.Name()).CombinedOutput()
+	if !assert.NoError(t, err, string(out)) {
+		return
+	}
+	assert.Contains(t, string(out), "cycles/count[dflt] allocations/count")
+	assert.Regexp(t, `(?m)^\s+\d+: 0x0 M=1 square profile.gates:2:0 s=1$`, string(out))
+}
@@ -1,11 +1,76 @@
 package gates
 
-import "github.com/lujjjh/gates/syntax"
+import (
+	"sort"
+
+	"github.com/lujjjh/gates/syntax"
+)
 
 type Program struct {
 	src    *syntax.File
 	code   []instruction
 	values []Value
+
+	// script is set for programs compiled by CompileScript, whose
+	// top-level bindings live in a stash created for each run.
+	script   bool
+	bindings map[string]uint32
+
+	srcMap []srcMapItem
+
+	// stmts maps the first instruction of each statement to the position
+	// of the statement, see DebugHook.
+	stmts []srcMapItem
+
+	// stashNames holds the names bound in the stash created by the
+	// newStash instruction at each pc, and stackNames those of a function
+	// whose locals live on the stack, so that a debugger can resolve the
+	// locals by name.
+	stashNames map[int]map[string]uint32
+	stackNames map[string]uint32
+
+	// fn is the position of the literal of a function, and name the name
+	// it is bound to by a let statement, if any.
+	fn   syntax.Pos
+	name string
+
+	// generator is set for the programs of generator functions.
+	generator bool
+
+	// globals holds the global identifiers resolved at compile time, see
+	// CompileWithGlobals. It is shared by a program and its functions.
+	globals *globalSlots
+
+	// references holds the uses of globals in the program and its
+	// functions, see References.
+	references []*Reference
+
+	// definitions holds the uses of local names in the program and its
+	// functions, see Definitions.
+	definitions []Definition
+}
+
+// globalSlots assigns slot indexes to the global identifiers referenced
+// by a program.
+type globalSlots struct {
+	names []string
+	index map[string]int
+}
+
+func (s *globalSlots) slot(name string) int {
+	if idx, ok := s.index[name]; ok {
+		return idx
+	}
+	idx := len(s.names)
+	s.names = append(s.names, name)
+	s.index[name] = idx
+	return idx
+}
+
+// srcMapItem maps the instructions starting at pc to a source position.
+type srcMapItem struct {
+	pc  int
+	pos syntax.Pos
 }
 
 func (p *Program) defineLit(v Value) uint {
@@ -22,3 +87,41 @@ func (p *Program) defineLit(v Value) uint {
 func (p *Program) InstructionNumber() int {
 	return len(p.code)
 }
+
+// sourcePos returns the source position of the instruction at pc.
+func (p *Program) sourcePos(pc int) syntax.Pos {
+	i := sort.Search(len(p.srcMap), func(i int) bool {
+		return p.srcMap[i].pc > pc
+	}) - 1
+	if i < 0 {
+		return syntax.NoPos
+	}
+	return p.srcMap[i].pos
+}
+
+// linePos returns the position of the statement or expression closest
+// before pc, which also locates the instructions without a source
+// position of their own.
+func (p *Program) linePos(pc int) syntax.Pos {
+	pos, last := syntax.NoPos, -1
+	for _, items := range [][]srcMapItem{p.srcMap, p.stmts} {
+		i := sort.Search(len(items), func(i int) bool {
+			return items[i].pc > pc
+		}) - 1
+		if i >= 0 && items[i].pc > last {
+			pos, last = items[i].pos, items[i].pc
+		}
+	}
+	return pos
+}
+
+// stmtPos returns the position of the statement starting at pc, if any.
+func (p *Program) stmtPos(pc int) (syntax.Pos, bool) {
+	i := sort.Search(len(p.stmts), func(i int) bool {
+		return p.stmts[i].pc >= pc
+	})
+	if i < len(p.stmts) && p.stmts[i].pc == pc {
+		return p.stmts[i].pos, true
+	}
+	return syntax.NoPos, false
+}
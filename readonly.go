@@ -0,0 +1,116 @@
+package gates
+
+import "strconv"
+
+// readOnlyValue is the view of a Map or an Array held by a read-only
+// global, see Global.Freeze. Scripts read the value through it, but
+// cannot assign to its members, and the maps and arrays it contains are
+// read through read-only views as well, so that no script can change a
+// read-only global at any depth and affect the later runs. Host objects
+// are not wrapped: they decide themselves what scripts may change.
+type readOnlyValue struct {
+	// path names the value in errors, e.g. cfg.limits.
+	path string
+	v    Value
+}
+
+// readOnlyView returns the read-only view of v named path if v is a Map or
+// an Array, and v otherwise.
+func readOnlyView(path string, v Value) Value {
+	switch v.(type) {
+	case Map, Array:
+		return &readOnlyValue{path: path, v: v}
+	}
+	return v
+}
+
+// underlying returns the value shown by v if v is a read-only view, and v
+// otherwise.
+func underlying(v Value) Value {
+	if ro, ok := v.(*readOnlyValue); ok {
+		return ro.v
+	}
+	return v
+}
+
+func (v *readOnlyValue) Type() string { return Type(v.v) }
+
+func (*readOnlyValue) IsString() bool   { return false }
+func (*readOnlyValue) IsInt() bool      { return false }
+func (*readOnlyValue) IsFloat() bool    { return false }
+func (*readOnlyValue) IsBool() bool     { return false }
+func (*readOnlyValue) IsFunction() bool { return false }
+
+func (v *readOnlyValue) ToString() string     { return v.v.ToString() }
+func (v *readOnlyValue) ToInt() int64         { return v.v.ToInt() }
+func (v *readOnlyValue) ToFloat() float64     { return v.v.ToFloat() }
+func (v *readOnlyValue) ToNumber() Number     { return v.v.ToNumber() }
+func (v *readOnlyValue) ToBool() bool         { return v.v.ToBool() }
+func (v *readOnlyValue) ToFunction() Function { return v.v.ToFunction() }
+func (v *readOnlyValue) Equals(o Value) bool  { return v.v.Equals(underlying(o)) }
+func (v *readOnlyValue) SameAs(o Value) bool  { return v.v.SameAs(underlying(o)) }
+
+// member returns the path of the member key of v.
+func (v *readOnlyValue) member(key string) string { return v.path + "." + key }
+
+func (v *readOnlyValue) ToNative(ops ...ToNativeOption) interface{} {
+	return v.v.ToNative(ops...)
+}
+
+func (v *readOnlyValue) toNative(seen map[interface{}]interface{}, ops int) interface{} {
+	return toNative(seen, v.v, ops)
+}
+
+func (v *readOnlyValue) Get(r *Runtime, key Value) Value {
+	return readOnlyView(v.member(key.ToString()), objectGet(r, v.v, key))
+}
+
+func (v *readOnlyValue) Set(r *Runtime, key, value Value) {
+	r.Throw(&ErrReadOnlyGlobal{Name: v.member(key.ToString())})
+}
+
+// Slice returns a copy of the elements, which may be assigned to, while
+// the maps and arrays among them stay read-only.
+func (v *readOnlyValue) Slice(r *Runtime, low, high Value) Value {
+	a, ok := v.v.(Array)
+	if !ok {
+		return Null
+	}
+	l, h, ok := sliceBounds(low, high, len(a.values))
+	if !ok {
+		return Null
+	}
+	values := make([]Value, h-l)
+	for i := range values {
+		values[i] = readOnlyView(v.member(strconv.Itoa(l+i)), a.values[l+i])
+	}
+	return NewArray(values)
+}
+
+// Iterator iterates the elements of an array, or the entries of a map,
+// whose values are read-only.
+func (v *readOnlyValue) Iterator() Iterator {
+	return &readOnlyIter{v: v, it: v.v.(Iterable).Iterator()}
+}
+
+type readOnlyIter struct {
+	v  *readOnlyValue
+	it Iterator
+	i  int
+}
+
+func (it *readOnlyIter) Next() (Value, bool) {
+	elem, ok := it.it.Next()
+	if !ok {
+		return elem, false
+	}
+	if _, ok := it.v.v.(Map); ok {
+		// the entry is a new map, whose value is replaced by its view
+		entry := elem.(Map)
+		entry["value"] = readOnlyView(it.v.member(entry["key"].ToString()), entry["value"])
+		return entry, true
+	}
+	i := it.i
+	it.i++
+	return readOnlyView(it.v.member(strconv.Itoa(i)), elem), true
+}
@@ -14,8 +14,11 @@ func (Ref) IsFloat() bool  { return false }
 func (Ref) IsBool() bool   { return false }
 
 func (r Ref) IsFunction() bool {
-	_, ok := r.v.(Function)
-	return ok
+	switch r.v.(type) {
+	case Function, Callable:
+		return true
+	}
+	return false
 }
 
 func (Ref) ToString() string     { return "[object Ref]" }
@@ -25,16 +28,21 @@ func (ref Ref) ToNumber() Number { return Float(ref.ToFloat()) }
 func (Ref) ToBool() bool         { return true }
 
 func (r Ref) ToFunction() Function {
-	f, ok := r.v.(Function)
-	if !ok {
-		return _EmptyFunction
+	switch f := r.v.(type) {
+	case Function:
+		return f
+	case Callable:
+		return FunctionFunc(f.Call)
 	}
-	return f
+	return _EmptyFunction
 }
 
 func (r Ref) ToNative(...ToNativeOption) interface{} { return r.v }
 
 func (ref Ref) Equals(other Value) bool {
+	if e, ok := ref.v.(Equaler); ok {
+		return e.Equal(other)
+	}
 	if o, ok := other.(Ref); ok {
 		return ref.v == o.v
 	}
@@ -0,0 +1,150 @@
+package gates
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/lujjjh/gates/syntax"
+)
+
+// A Reference is a use of a global identifier in a program.
+type Reference struct {
+	Name     string
+	Position syntax.Position
+
+	// Path holds the constant keys selected from the global, e.g.
+	// ["address", "city"] for user.address.city or user["address"].city.
+	// A key that is not constant ends the path.
+	Path []string
+
+	// Assigned is set if the global itself is assigned to.
+	Assigned bool
+
+	// Called is set if the value at the path is called, either directly
+	// or as a stage of a pipeline.
+	Called bool
+}
+
+// References describes the globals that a program may touch.
+type References struct {
+	// Globals holds the uses of globals in the order of their positions.
+	Globals []Reference
+
+	// Calls holds the sorted names of the global functions called, with
+	// the path appended by dots, e.g. "map" or "strings.to_upper".
+	Calls []string
+}
+
+// References returns the uses of globals in p and in its functions,
+// including those in code removed by constant folding, such as user in
+// false && user.secret.
+func (p *Program) References() References {
+	refs := References{
+		Globals: make([]Reference, len(p.references)),
+	}
+	calls := make(map[string]bool)
+	for i, ref := range p.references {
+		refs.Globals[i] = *ref
+		if ref.Called {
+			calls[strings.Join(append([]string{ref.Name}, ref.Path...), ".")] = true
+		}
+	}
+	sort.SliceStable(refs.Globals, func(i, j int) bool {
+		return refs.Globals[i].Position.Offset < refs.Globals[j].Position.Offset
+	})
+	for name := range calls {
+		refs.Calls = append(refs.Calls, name)
+	}
+	sort.Strings(refs.Calls)
+	return refs
+}
+
+// referenceGlobal records the use of the global identifier e.
+func (c *compiler) referenceGlobal(e *compiledIdentExpr) *Reference {
+	if ref, ok := c.references[e]; ok {
+		return ref
+	}
+	ref := &Reference{
+		Name:     e.name,
+		Position: c.program.src.Position(e.pos),
+	}
+	if c.references == nil {
+		c.references = make(map[*compiledIdentExpr]*Reference)
+	}
+	c.references[e] = ref
+	c.referenceList = append(c.referenceList, ref)
+	return ref
+}
+
+// referencePath returns the reference of the global that e selects from,
+// if any, along with the keys selected. complete is unset if a key on the
+// way is not constant, which ends the path.
+func (c *compiler) referencePath(e compiledExpr) (ref *Reference, path []string, complete bool) {
+	var key compiledExpr
+	switch e := e.(type) {
+	case *compiledIdentExpr:
+		ref, ok := c.references[e]
+		return ref, nil, ok
+	case *compiledSelectorExpr:
+		ref, path, complete = c.referencePath(e.expr)
+		key = e.key
+	case *compiledIndexExpr:
+		ref, path, complete = c.referencePath(e.expr)
+		key = e.index
+	default:
+		return nil, nil, false
+	}
+	if ref == nil || !complete {
+		return ref, path, false
+	}
+	if lit, ok := key.(*compiledLit); ok {
+		return ref, append(path, lit.value.ToString()), true
+	}
+	return ref, path, false
+}
+
+// referenceSelection records the keys selected by e from a global. If
+// call is set, the selected value is called.
+func (c *compiler) referenceSelection(e compiledExpr, call bool) {
+	ref, path, complete := c.referencePath(e)
+	if ref == nil {
+		return
+	}
+	if len(path) > len(ref.Path) {
+		ref.Path = path
+	}
+	if call && complete {
+		ref.Called = true
+	}
+}
+
+// A Definition links the use of a local name, a parameter or a name
+// declared by let, to its declaration.
+type Definition struct {
+	Name string
+	Use  syntax.Position
+	Decl syntax.Position
+}
+
+// Definitions returns the uses of local names in p and in its functions
+// in the order of their positions.
+func (p *Program) Definitions() []Definition {
+	defs := append([]Definition(nil), p.definitions...)
+	sort.SliceStable(defs, func(i, j int) bool {
+		return defs[i].Use.Offset < defs[j].Use.Offset
+	})
+	return defs
+}
+
+// defineLocal records the use of the local identifier e.
+func (c *compiler) defineLocal(e *compiledIdentExpr) {
+	decl, ok := c.scope.declaration(e.name)
+	if !ok {
+		return
+	}
+	c.definitions = append(c.definitions, Definition{
+		Name: e.name,
+		Use:  c.program.src.Position(e.pos),
+		Decl: c.program.src.Position(decl),
+	})
+}
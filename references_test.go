@@ -0,0 +1,108 @@
+package gates
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferences(t *testing.T) {
+	program, err := CompileScript("rule.gates", `let limit = 10;
+let country = user.address["country"];
+if (country == "NZ" && orders[0].total > limit) {
+  flagged = strings.to_upper(country);
+}
+user.tags[limit].name;
+orders | map(x => x.total) | fold(price.calc)`)
+	assert.NoError(t, err)
+
+	refs := program.References()
+	type ref struct {
+		Name     string
+		Line     int
+		Path     []string
+		Assigned bool
+		Called   bool
+	}
+	var globals []ref
+	for _, r := range refs.Globals {
+		assert.Equal(t, "rule.gates", r.Position.Filename)
+		globals = append(globals, ref{r.Name, r.Position.Line, r.Path, r.Assigned, r.Called})
+	}
+	assert.Equal(t, []ref{
+		{"user", 2, []string{"address", "country"}, false, false},
+		{"orders", 3, []string{"0", "total"}, false, false},
+		{"flagged", 4, nil, true, false},
+		{"strings", 4, []string{"to_upper"}, false, true},
+		{"user", 6, []string{"tags"}, false, false},
+		{"orders", 7, nil, false, false},
+		{"map", 7, nil, false, true},
+		{"fold", 7, nil, false, true},
+		{"price", 7, []string{"calc"}, false, false},
+	}, globals)
+	assert.Equal(t, []string{"fold", "map", "strings.to_upper"}, refs.Calls)
+
+	// local variables and parameters are not globals
+	program, err = Compile(`(function (x) { return x.y; })(z)`)
+	assert.NoError(t, err)
+	refs = program.References()
+	if assert.Len(t, refs.Globals, 1) {
+		assert.Equal(t, "z", refs.Globals[0].Name)
+		assert.Equal(t, 32, refs.Globals[0].Position.Column)
+	}
+	assert.Empty(t, refs.Calls)
+
+	// constant folding does not hide globals
+	program, err = Compile(`false && user.secret || true || audit(user)`)
+	assert.NoError(t, err)
+	refs = program.References()
+	var names []string
+	for _, r := range refs.Globals {
+		names = append(names, r.Name+"."+strings.Join(r.Path, "."))
+	}
+	assert.Equal(t, []string{"user.secret", "audit.", "user."}, names)
+	assert.Equal(t, []string{"audit"}, refs.Calls)
+}
+
+func TestDefinitions(t *testing.T) {
+	program, err := CompileScript("defs.gates", `let total = 0;
+let add = function (x) {
+  let total2 = total + x;
+  return total2;
+};
+for (let i = 0; i < 2; i = i + 1) {
+  total = add(i);
+}
+let total = total * 2;
+total`)
+	assert.NoError(t, err)
+
+	var defs []string
+	for _, d := range program.Definitions() {
+		defs = append(defs, fmt.Sprintf("%s %d:%d -> %d:%d", d.Name, d.Use.Line, d.Use.Column, d.Decl.Line, d.Decl.Column))
+	}
+	assert.Equal(t, []string{
+		"total 3:16 -> 1:5",
+		"x 3:24 -> 2:21",
+		"total2 4:10 -> 3:7",
+		"i 6:17 -> 6:10",
+		"i 6:24 -> 6:10",
+		"i 6:28 -> 6:10",
+		"total 7:3 -> 1:5",
+		"add 7:11 -> 2:5",
+		"i 7:15 -> 6:10",
+		"total 9:13 -> 1:5",
+		"total 10:1 -> 1:5",
+	}, defs)
+
+	// nor local names
+	program, err = CompileScript("defs.gates", `let secret = 1;
+false && secret`)
+	assert.NoError(t, err)
+	if defs := program.Definitions(); assert.Len(t, defs, 1) {
+		assert.Equal(t, "secret", defs[0].Name)
+		assert.Equal(t, 2, defs[0].Use.Line)
+	}
+}
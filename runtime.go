@@ -9,6 +9,8 @@ type ToNativeOption int
 
 const (
 	SkipCircularReference ToNativeOption = 1 << iota
+	// DecimalAsString converts decimals to strings instead of *big.Rat.
+	DecimalAsString
 )
 
 func checkToNativeOption(desiredOption ToNativeOption, options int) bool {
@@ -24,6 +26,22 @@ func convertToNativeOption2BinaryOptions(options []ToNativeOption) int {
 }
 
 func Compile(x string) (program *Program, err error) {
+	return compile(x, nil)
+}
+
+// CompileWithGlobals is like Compile but resolves global identifiers at
+// compile time. globals declares the names of the globals provided by the
+// host in addition to the built-ins, e.g. Global.Names. Reading a declared
+// global is faster than a lookup by name, and an undeclared identifier is
+// reported as a CompilerSyntaxError.
+func CompileWithGlobals(x string, globals []string) (*Program, error) {
+	if globals == nil {
+		globals = []string{}
+	}
+	return compile(x, globals)
+}
+
+func compile(x string, globals []string) (program *Program, err error) {
 	defer func() {
 		if x := recover(); x != nil {
 			program = nil
@@ -36,24 +54,99 @@ func Compile(x string) (program *Program, err error) {
 		}
 	}()
 
-	e, err := syntax.ParseExpr(x)
+	fset := syntax.NewFileSet()
+	base := fset.Base()
+	e, err := syntax.ParseExprFrom(fset, "", x)
 	if err != nil {
 		return nil, err
 	}
 
 	compiler := &compiler{
 		program: &Program{
-			src: syntax.NewFileSet().AddFile("", -1, len(x)),
+			src: fset.File(syntax.Pos(base)),
 		},
 	}
+	if globals != nil {
+		compiler.declareGlobals(globals)
+	}
 	compiler.compile(e)
 
 	return compiler.program, nil
 }
 
+// CompileScript compiles a script, which is a list of statements, into a
+// program. The result of the program is the value of the final expression
+// statement or of an explicit top-level return.
+func CompileScript(name, x string) (*Program, error) {
+	return CompileFile(syntax.NewFileSet(), name, x)
+}
+
+// CompileFile is like CompileScript but adds the source to fset, so that
+// positions from different scripts may be resolved by a single file set.
+// The filename is recorded in syntax and runtime errors.
+func CompileFile(fset *syntax.FileSet, filename, src string) (program *Program, err error) {
+	return compileFile(fset, filename, src, nil)
+}
+
+// CompileScriptWithGlobals is like CompileScript but resolves global
+// identifiers at compile time, see CompileWithGlobals.
+func CompileScriptWithGlobals(name, x string, globals []string) (*Program, error) {
+	if globals == nil {
+		globals = []string{}
+	}
+	return compileFile(syntax.NewFileSet(), name, x, globals)
+}
+
+// CompileTree compiles a script parsed by syntax.ParseFile into fset. The
+// syntax tree may be partial: the statements and expressions that failed
+// to parse are compiled as nothing and null respectively, so that tools
+// can still look up the references and definitions of the rest.
+func CompileTree(fset *syntax.FileSet, s *syntax.Script) (*Program, error) {
+	return compileTree(fset, s, nil)
+}
+
+func compileFile(fset *syntax.FileSet, filename, src string, globals []string) (*Program, error) {
+	s, err := syntax.ParseFile(fset, filename, src)
+	if err != nil {
+		return nil, err
+	}
+	return compileTree(fset, s, globals)
+}
+
+func compileTree(fset *syntax.FileSet, s *syntax.Script, globals []string) (program *Program, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			program = nil
+			switch x1 := x.(type) {
+			case *CompilerSyntaxError:
+				err = x1
+			default:
+				panic(x)
+			}
+		}
+	}()
+
+	compiler := &compiler{
+		program: &Program{
+			src: fset.File(s.FileStart),
+		},
+	}
+	if globals != nil {
+		compiler.declareGlobals(globals)
+	}
+	compiler.compileScript(s)
+
+	return compiler.program, nil
+}
+
 type Runtime struct {
 	vm     *vm
 	global *Global
+
+	// strict restricts the assignments of scripts to globals to the
+	// writable names.
+	strict   bool
+	writable map[string]bool
 }
 
 func New() *Runtime {
@@ -63,12 +156,13 @@ func New() *Runtime {
 }
 
 func (r *Runtime) init() {
-	r.vm = &vm{r: r}
+	r.vm = &vm{r: r, decimalScale: DefaultDecimalScale}
 	r.vm.init()
 	r.global = NewGlobal()
 	r.global.initBuiltInFunctions()
+	r.vm.globals = ref(&globalScope{base: r.global})
 
-	r.global.Set("strings", packageStrings())
+	r.global.SetReadOnly("strings", packageStrings())
 }
 
 func (r *Runtime) Global() *Global {
@@ -79,14 +173,85 @@ func (r *Runtime) SetCyclesLimit(max int) {
 	r.vm.cyclesLimit = max
 }
 
+// SetStrict sets whether scripts are restricted in assigning to globals.
+// In strict mode, an assignment to an undeclared identifier fails unless
+// its name is writable (see SetWritableGlobals) or it is defined in the
+// bindings of the run. Read-only globals are never assignable.
+func (r *Runtime) SetStrict(strict bool) {
+	r.strict = strict
+}
+
+// SetWritableGlobals sets the names of the globals that scripts may assign
+// to in strict mode, e.g. to publish values to the host.
+func (r *Runtime) SetWritableGlobals(names ...string) {
+	r.writable = make(map[string]bool, len(names))
+	for _, name := range names {
+		r.writable[name] = true
+	}
+}
+
+// SetDecimalRounding sets the number of digits after the decimal point kept
+// in decimal quotients and how the last digit is rounded. The default is
+// DefaultDecimalScale digits rounded with RoundHalfEven.
+func (r *Runtime) SetDecimalRounding(scale int, mode RoundingMode) {
+	r.vm.decimalScale = scale
+	r.vm.decimalRounding = mode
+}
+
 func (r *Runtime) RunProgram(ctx context.Context, program *Program) (Value, error) {
+	v, _, err := r.runProgram(ctx, program, nil)
+	return v, err
+}
+
+// RunProgramWithBindings runs program with bindings layered over the
+// Runtime's Global. Global identifiers are looked up in bindings first, and
+// assignments to them go to bindings, so the Global is left untouched.
+func (r *Runtime) RunProgramWithBindings(ctx context.Context, program *Program, bindings *Global) (Value, error) {
+	v, _, err := r.runProgram(ctx, program, bindings)
+	return v, err
+}
+
+// RunScript runs a program compiled by CompileScript and returns its
+// result along with the values of its top-level let bindings.
+func (r *Runtime) RunScript(ctx context.Context, program *Program) (Value, Map, error) {
+	v, s, err := r.runProgram(ctx, program, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	bindings := make(Map, len(program.bindings))
+	for name, idx := range program.bindings {
+		bindings[name] = s.getByIdx(idx)
+	}
+	return v, bindings, nil
+}
+
+func (r *Runtime) runProgram(ctx context.Context, program *Program, bindings *Global) (Value, *stash, error) {
+	var s *stash
+	if program.script {
+		s = &stash{names: program.bindings}
+	}
+	if bindings != nil {
+		globals := r.vm.globals
+		r.vm.globals = ref(&globalScope{base: r.global, bindings: bindings})
+		defer func() {
+			r.vm.globals = globals
+		}()
+	}
+	if r.vm.depth == 0 {
+		r.vm.init()
+		r.vm.remainingCycles = r.vm.cyclesLimit
+	}
+	r.vm.stash = s
 	r.vm.program = program
 	r.vm.pc = 0
 	r.vm.ctx = ctx
 	if err := r.vm.run(); err != nil {
-		return nil, err
+		if _, ok := err.(*RuntimeError); ok {
+			return nil, nil, err
+		}
+		return nil, nil, r.vm.newRuntimeError(err)
 	}
-	return r.vm.stack.Pop(), nil
+	return r.vm.stack.Pop(), s, nil
 }
 
 func (r *Runtime) RunString(s string) (Value, error) {
@@ -97,17 +262,96 @@ func (r *Runtime) RunString(s string) (Value, error) {
 	return r.RunProgram(context.Background(), program)
 }
 
+// Call calls f with args. If the call fails while a program is running,
+// the program is aborted with the error (see Throw); otherwise Call panics.
 func (r *Runtime) Call(f Function, args ...Value) Value {
+	ctx := r.vm.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	v, err := r.CallContext(ctx, f, args...)
+	if err != nil {
+		r.raise(err)
+	}
+	return v
+}
+
+// raise aborts the running program with err, or panics if no program is
+// running.
+func (r *Runtime) raise(err error) {
+	if r.vm.depth == 0 {
+		panic(err)
+	}
+	r.Throw(err)
+}
+
+// CallContext calls f with args and returns its result, or the error that
+// made it fail. It may be called from native functions while a program is
+// running, to any depth: the state of the running program is restored
+// before it returns, and the callee consumes the cycles budget of the
+// running program.
+func (r *Runtime) CallContext(ctx context.Context, f Function, args ...Value) (Value, error) {
+	v, err := r.call(ctx, f, args)
+	if err != nil {
+		return nil, err
+	}
+	return r.vm.materialize(v)
+}
+
+// invoke is like Call but leaves a lazy array returned by f as it is, so
+// that curried built-ins such as map can be chained lazily.
+func (r *Runtime) invoke(f Function, args ...Value) Value {
+	ctx := r.vm.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	v, err := r.call(ctx, f, args)
+	if err != nil {
+		r.raise(err)
+	}
+	return v
+}
+
+func (r *Runtime) call(ctx context.Context, f Function, args []Value) (result Value, err error) {
 	switch f := f.(type) {
 	case *nativeFunction:
-		return f.fun(&functionCall{vm: r.vm, args: args})
+		defer func() {
+			if x := recover(); x != nil {
+				t, ok := x.(*thrown)
+				if !ok {
+					panic(x)
+				}
+				result, err = nil, t.err
+			}
+		}()
+		if !f.streams {
+			args = append([]Value(nil), args...)
+			materializeAll(args)
+		}
+		v := f.fun(&functionCall{vm: r.vm, args: args})
+		if p, ok := v.(*Pending); ok {
+			return p.wait(ctx)
+		}
+		return v, nil
 	case *literalFunction:
 		vm := r.vm
+		if f.program.generator {
+			return NewSequence(newGenerator(vm, f, args)), nil
+		}
+		saved := ctxState{
+			ctx:       vm.ctx,
+			program:   vm.program,
+			stash:     vm.stash,
+			pc:        vm.pc,
+			bp:        vm.bp,
+			sp:        vm.stack.sp,
+			callStack: len(vm.callStack),
+		}
+		defer saved.restore(vm)
 		for i := range args {
 			vm.stack.Push(args[i])
 		}
 		vm.stack.Push(Int(len(args)))
-		pc := vm.pc
 		vm.pc = -1
 		vm.pushCtx()
 		vm.bp = vm.stack.sp
@@ -117,14 +361,47 @@ func (r *Runtime) Call(f Function, args ...Value) Value {
 		vm.stash = f.stash
 		vm.program = f.program
 		vm.pc = 0
+		vm.ctx = ctx
+		if vm.depth == 0 {
+			vm.remainingCycles = vm.cyclesLimit
+		}
 		if err := vm.run(); err != nil {
-			panic(err)
+			if _, ok := err.(*RuntimeError); !ok {
+				err = vm.newRuntimeError(err)
+			}
+			return nil, err
 		}
-		vm.halt = false
-		vm.pc = pc
-		return vm.stack.Pop()
+		return vm.stack.Pop(), nil
 	}
-	return Null
+	return Null, nil
+}
+
+// ctxState is the state of a running program saved by CallContext.
+type ctxState struct {
+	ctx       context.Context
+	program   *Program
+	stash     *stash
+	pc, bp    int
+	sp        int
+	callStack int
+}
+
+func (s *ctxState) restore(vm *vm) {
+	vm.ctx = s.ctx
+	vm.program = s.program
+	vm.stash = s.stash
+	vm.pc = s.pc
+	vm.bp = s.bp
+	vm.stack.sp = s.sp
+	vm.callStack = vm.callStack[:s.callStack]
+	vm.halt = false
+}
+
+// Throw aborts the running program with err. Native functions use it to
+// report failures: the program run, or the innermost CallContext, returns
+// err.
+func (r *Runtime) Throw(err error) {
+	panic(&thrown{err: err})
 }
 
 func (r *Runtime) ToValue(i interface{}) Value {
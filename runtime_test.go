@@ -2,13 +2,27 @@ package gates
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"strings"
 	"testing"
 
+	"github.com/lujjjh/gates/syntax"
 	"github.com/stretchr/testify/assert"
 )
 
+// cause returns the error wrapped by err, e.g. by a RuntimeError, at the
+// innermost level.
+func cause(err error) error {
+	for {
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return err
+		}
+		err = u.Unwrap()
+	}
+}
+
 type panicErr struct {
 	message string
 }
@@ -68,8 +82,13 @@ func TestRunString(t *testing.T) {
 
 	assertValue(t, Int(4), mustRunString(`("he" + "he").length`))
 	assertValue(t, String("e"), mustRunString(`"hehe"[1]`))
-	assertValue(t, Null, mustRunString(`"hehe"[-1]`))
+	assertValue(t, String("e"), mustRunString(`"hehe"[-1]`))
+	assertValue(t, Null, mustRunString(`"hehe"[-5]`))
 	assertValue(t, Null, mustRunString(`"hehe"[4]`))
+	assertValue(t, String("eh"), mustRunString(`"hehe"[1:3]`))
+	assertValue(t, String("界"), mustRunString(`"世界"[-1:]`))
+	assertValue(t, Int(42), mustRunString(`[40, 41, 42][-1]`))
+	assertValue(t, Int(2), mustRunString(`[40, 41, 42][1:].length`))
 
 	assertValue(t, Float(3), mustRunStringWithGlobal(`add(1, 2)`, map[string]Value{
 		"add": FunctionFunc(func(fc FunctionCall) Value {
@@ -145,7 +164,7 @@ func TestRunProgramStackOverflow(t *testing.T) {
 
 	r := New()
 	_, err := r.RunString(src)
-	if err != ErrStackOverflow {
+	if cause(err) != ErrStackOverflow {
 		t.Errorf("stack overflow expected")
 	}
 }
@@ -205,7 +224,12 @@ func TestRunExamples(t *testing.T) {
 			message := String(args[0].ToString() + " not expected")
 			return r.Call(_assert, Bool(!args[0].Equals(args[1])), message)
 		}))
-		_, err = r.RunString(string(s))
+		program, err := CompileScript(name, string(s))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		_, err = r.RunProgram(context.Background(), program)
 		if err != nil {
 			t.Error(err)
 		}
@@ -236,11 +260,132 @@ func TestExceedInstructionLimit(t *testing.T) {
 	r := New()
 	r.SetCyclesLimit(5)
 	_, err := r.RunString(src)
-	if err != ErrCyclesLimitExceeded {
+	if cause(err) != ErrCyclesLimitExceeded {
 		t.Errorf("cycles limit exceeded expected")
 	}
 }
 
+func TestCallContext(t *testing.T) {
+	r := New()
+	r.Global().Set("twice", FunctionFunc(func(fc FunctionCall) Value {
+		args := fc.Args()
+		f, x := args[0].ToFunction(), args[1]
+		for i := 0; i < 2; i++ {
+			v, err := fc.Runtime().CallContext(fc.Runtime().Context(), f, x)
+			if err != nil {
+				fc.Runtime().Throw(err)
+			}
+			x = v
+		}
+		return x
+	}))
+	r.Global().Set("fail", FunctionFunc(func(fc FunctionCall) Value {
+		if fc.Args()[0].ToInt() > 1 {
+			fc.Runtime().Throw(errors.New("boom"))
+		}
+		return fc.Args()[0]
+	}))
+
+	v, err := r.RunString(`1 + twice(function (x) { return twice(function (y) { return y * 2 }, x) + 1 }, 2) + 10`)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(48), v.ToInt())
+
+	_, err = r.RunString(`map(function (x) { return [fail(x)] }, [1, 2, 3])`)
+	assert.EqualError(t, err, "boom at 1:32")
+
+	f, err := r.RunString(`function (x) { return twice(function (y) { return y + x }, 1) }`)
+	assert.NoError(t, err)
+	v, err = r.CallContext(context.Background(), f.ToFunction(), Int(3))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), v.ToInt())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.CallContext(ctx, f.ToFunction(), Int(3))
+	assert.Equal(t, context.Canceled, cause(err))
+
+	v, err = r.RunString(`filter(function (x) { return fail(x) < 2 }, [1])`)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(1)}, v.ToNative())
+}
+
+func TestCallContextCyclesLimit(t *testing.T) {
+	src := `map(function (x) { return map(function (y) { return x * y }, [1, 2, 3, 4, 5]) }, [1, 2, 3, 4, 5])`
+
+	r := New()
+	_, err := r.RunString(src)
+	assert.NoError(t, err)
+
+	r.SetCyclesLimit(100)
+	_, err = r.RunString(src)
+	if cause(err) != ErrCyclesLimitExceeded {
+		t.Errorf("cycles limit exceeded expected, got %v", err)
+	}
+}
+
+func TestCompileScript(t *testing.T) {
+	program, err := CompileScript("test.gates", `
+		let x = 40;
+		let double = n => n * 2;
+		if (x > 0) {
+			x = x + 1;
+		}
+		for (let i = 0; i < 1; i = i + 1) {
+			x = x + 1;
+		}
+		double(x) / 2
+	`)
+	assert.NoError(t, err)
+	r := New()
+	v, bindings, err := r.RunScript(context.Background(), program)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 42, v.ToInt())
+	assert.EqualValues(t, 42, bindings["x"].ToInt())
+	assert.True(t, bindings["double"].IsFunction())
+
+	program, err = CompileScript("test.gates", `
+		let x = 1;
+		if (x) {
+			return "early";
+		}
+		"late";
+	`)
+	assert.NoError(t, err)
+	v, err = r.RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.Equal(t, String("early"), v)
+
+	program, err = CompileScript("test.gates", `let x = 1;`)
+	assert.NoError(t, err)
+	v, err = r.RunProgram(context.Background(), program)
+	assert.NoError(t, err)
+	assert.Equal(t, Null, v)
+
+	_, err = CompileScript("test.gates", `let x = ;`)
+	assert.Error(t, err)
+}
+
+func TestCompileFile(t *testing.T) {
+	fset := syntax.NewFileSet()
+	_, err := CompileFile(fset, "a.gates", "let a = 1;\n")
+	assert.NoError(t, err)
+
+	_, err = CompileFile(fset, "b.gates", "let b = 1;\nb = ;")
+	assert.EqualError(t, err, "b.gates:2:5: expected operand, found ';'")
+
+	// all the syntax errors are reported
+	_, err = CompileFile(fset, "e.gates", "let e = ;\ne = (1;\ne(e e);")
+	assert.EqualError(t, err, "e.gates:1:9: expected operand, found ';' (and 2 more errors)")
+
+	_, err = CompileFile(fset, "c.gates", "let c = 1;\n1 = c;")
+	assert.EqualError(t, err, "SyntaxError: not a valid left-value expression at c.gates:2:1")
+
+	program, err := CompileFile(fset, "d.gates", "let f = () => f();\nf()")
+	assert.NoError(t, err)
+	_, err = New().RunProgram(context.Background(), program)
+	assert.EqualError(t, err, "stack overflow at d.gates:1:16")
+}
+
 func TestValueNotAssigned(t *testing.T) {
 	r := New()
 	src := `(() => {
@@ -1,9 +1,14 @@
 package gates
 
+import "github.com/lujjjh/gates/syntax"
+
 type scope struct {
 	names   map[string]uint32
 	visited bool
 
+	// decls holds the position of the first declaration of each name.
+	decls map[string]syntax.Pos
+
 	outer *scope
 }
 
@@ -15,6 +20,7 @@ func newScope(outer *scope) *scope {
 
 func (s *scope) init(outer *scope) {
 	s.names = make(map[string]uint32)
+	s.decls = make(map[string]syntax.Pos)
 	s.outer = outer
 }
 
@@ -32,10 +38,23 @@ func (s *scope) lookupName(name string) (uint32, bool) {
 	return 0, false
 }
 
-func (s *scope) bindName(name string) uint32 {
+// declaration returns the position where the name visible in s is
+// declared.
+func (s *scope) declaration(name string) (syntax.Pos, bool) {
+	for current := s; current != nil; current = current.outer {
+		if _, ok := current.names[name]; ok {
+			return current.decls[name], true
+		}
+	}
+	return syntax.NoPos, false
+}
+
+func (s *scope) bindName(name string, pos syntax.Pos) uint32 {
 	if idx, ok := s.names[name]; ok {
+		// a name declared again in the same scope is the same variable
 		return idx
 	}
+	s.decls[name] = pos
 	idx := uint32(len(s.names))
 	s.names[name] = idx
 	return idx
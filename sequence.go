@@ -0,0 +1,262 @@
+package gates
+
+import "math"
+
+// Sequence is a lazy sequence of values, such as the result of calling a
+// generator function. Its values are produced as it is iterated, and only
+// once: a Sequence can be consumed a single time.
+type Sequence struct {
+	it Iterator
+}
+
+// NewSequence returns a Sequence of the values produced by it.
+func NewSequence(it Iterator) *Sequence {
+	return &Sequence{it: it}
+}
+
+func (*Sequence) Type() string { return "sequence" }
+
+func (*Sequence) IsString() bool   { return false }
+func (*Sequence) IsInt() bool      { return false }
+func (*Sequence) IsFloat() bool    { return false }
+func (*Sequence) IsBool() bool     { return false }
+func (*Sequence) IsFunction() bool { return false }
+
+func (*Sequence) ToString() string                         { return "[object Sequence]" }
+func (*Sequence) ToInt() int64                             { return 0 }
+func (*Sequence) ToFloat() float64                         { return math.NaN() }
+func (*Sequence) ToNumber() Number                         { return Float(math.NaN()) }
+func (*Sequence) ToBool() bool                             { return true }
+func (*Sequence) ToFunction() Function                     { return _EmptyFunction }
+func (s *Sequence) ToNative(...ToNativeOption) interface{} { return s }
+
+func (s *Sequence) Equals(other Value) bool {
+	return (interface{})(s) == (interface{})(other)
+}
+
+func (s *Sequence) SameAs(other Value) bool { return s.Equals(other) }
+
+func (s *Sequence) Iterator() Iterator { return s.it }
+
+// sequenceOf returns the iterator of v if v is a Sequence.
+func sequenceOf(v Value) (Iterator, bool) {
+	s, ok := v.(*Sequence)
+	if !ok {
+		return nil, false
+	}
+	return s.it, true
+}
+
+type mapIterator struct {
+	f  Callback
+	it Iterator
+	i  int
+}
+
+func (m *mapIterator) Next() (Value, bool) {
+	v, ok := m.it.Next()
+	if !ok {
+		return nil, false
+	}
+	v = m.f(v, Int(m.i))
+	m.i++
+	return v, true
+}
+
+type filterIterator struct {
+	f  Callback
+	it Iterator
+	i  int
+}
+
+func (f *filterIterator) Next() (Value, bool) {
+	for {
+		v, ok := f.it.Next()
+		if !ok {
+			return nil, false
+		}
+		i := f.i
+		f.i++
+		if f.f(v, Int(i)).ToBool() {
+			return v, true
+		}
+	}
+}
+
+type takeIterator struct {
+	it Iterator
+	n  int64
+}
+
+func (t *takeIterator) Next() (Value, bool) {
+	if t.n <= 0 {
+		return nil, false
+	}
+	t.n--
+	return t.it.Next()
+}
+
+// lazyArray is the result of map, filter or take over an array. Its
+// elements are computed when they are first needed, e.g. by indexing or
+// length, so that a chain of these functions makes a single pass without
+// intermediate arrays, and find or reduce at the end of the chain
+// consume it element by element.
+//
+// A lazyArray is materialized when it is stored in a variable, an array or
+// a map, discarded by an expression statement, yielded, passed to a
+// function other than those consuming it element by element, or leaves
+// the VM, so it can be used anywhere an Array is expected and its
+// callbacks run once, within the statement that creates it.
+type lazyArray struct {
+	iterator func() Iterator
+	values   []Value
+	done     bool
+}
+
+func newLazyArray(iterator func() Iterator) *lazyArray {
+	return &lazyArray{iterator: iterator}
+}
+
+// array computes the elements of a once and returns them as an Array.
+func (a *lazyArray) array() Array {
+	if !a.done {
+		values := make([]Value, 0)
+		it := a.iterator()
+		for {
+			v, ok := it.Next()
+			if !ok {
+				break
+			}
+			values = append(values, v)
+		}
+		a.values = values
+		a.done = true
+		a.iterator = nil
+	}
+	return NewArray(a.values)
+}
+
+func (*lazyArray) Type() string { return "array" }
+
+func (*lazyArray) IsString() bool   { return false }
+func (*lazyArray) IsInt() bool      { return false }
+func (*lazyArray) IsFloat() bool    { return false }
+func (*lazyArray) IsBool() bool     { return false }
+func (*lazyArray) IsFunction() bool { return false }
+
+func (a *lazyArray) ToString() string        { return a.array().ToString() }
+func (*lazyArray) ToInt() int64              { return 0 }
+func (*lazyArray) ToFloat() float64          { return math.NaN() }
+func (*lazyArray) ToNumber() Number          { return Float(math.NaN()) }
+func (*lazyArray) ToBool() bool              { return true }
+func (*lazyArray) ToFunction() Function      { return _EmptyFunction }
+func (a *lazyArray) Equals(other Value) bool { return a.array().Equals(other) }
+func (a *lazyArray) SameAs(other Value) bool { return false }
+
+func (a *lazyArray) ToNative(ops ...ToNativeOption) interface{} {
+	return a.array().ToNative(ops...)
+}
+
+func (a *lazyArray) toNative(seen map[interface{}]interface{}, ops int) interface{} {
+	return a.array().toNative(seen, ops)
+}
+
+func (a *lazyArray) Get(r *Runtime, key Value) Value         { return a.array().Get(r, key) }
+func (a *lazyArray) Set(r *Runtime, key, value Value)        { a.array().Set(r, key, value) }
+func (a *lazyArray) Length(r *Runtime) int                   { return a.array().Length(r) }
+func (a *lazyArray) Slice(r *Runtime, low, high Value) Value { return a.array().Slice(r, low, high) }
+
+// Iterator streams the elements of a without materializing it.
+func (a *lazyArray) Iterator() Iterator {
+	if a.done {
+		return NewArray(a.values).Iterator()
+	}
+	return a.iterator()
+}
+
+// arrayIterator returns a function returning an iterator over the
+// elements of v if v is an array.
+func arrayIterator(v Value) (func() Iterator, bool) {
+	switch v := v.(type) {
+	case Array:
+		return v.Iterator, true
+	case *lazyArray:
+		return v.Iterator, true
+	}
+	return nil, false
+}
+
+// streamOf returns an iterator over v if v is lazy, i.e. a Sequence or a
+// lazyArray, so that it can be consumed element by element.
+func streamOf(v Value) (Iterator, bool) {
+	switch v := v.(type) {
+	case *Sequence:
+		return v.it, true
+	case *lazyArray:
+		return v.Iterator(), true
+	}
+	return nil, false
+}
+
+// materialize returns the Array of v if v is a lazyArray, and v otherwise.
+func materialize(v Value) Value {
+	if a, ok := v.(*lazyArray); ok {
+		return a.array()
+	}
+	return v
+}
+
+// materializeAll materializes the lazy arrays of values in place.
+func materializeAll(values []Value) {
+	for i, v := range values {
+		values[i] = materialize(v)
+	}
+}
+
+// materialize is like the function materialize, but callbacks of the lazy
+// array may fail even if no program is running.
+func (vm *vm) materialize(v Value) (result Value, err error) {
+	a, ok := v.(*lazyArray)
+	if !ok {
+		return v, nil
+	}
+	vm.depth++
+	defer func() {
+		vm.depth--
+		if x := recover(); x != nil {
+			t, ok := x.(*thrown)
+			if !ok {
+				panic(x)
+			}
+			result, err = nil, t.err
+		}
+	}()
+	return a.array(), nil
+}
+
+// toArray collects the elements of an array or a sequence into an Array.
+func toArray(v Value) Value {
+	switch v := v.(type) {
+	case Array:
+		return v
+	case *lazyArray:
+		return v.array()
+	case _Null:
+		return NewArray([]Value{})
+	}
+	it, ok := sequenceOf(v)
+	if ro, isReadOnly := v.(*readOnlyValue); isReadOnly && Type(ro) == "array" {
+		it, ok = ro.Iterator(), true
+	}
+	if !ok {
+		return Null
+	}
+	values := make([]Value, 0)
+	for {
+		elem, ok := it.Next()
+		if !ok {
+			return NewArray(values)
+		}
+		values = append(values, elem)
+	}
+}
@@ -0,0 +1,110 @@
+package gates
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazyPipeline(t *testing.T) {
+	calls := 0
+	r := New()
+	r.Global().Set("inc", FunctionFunc(func(fc FunctionCall) Value {
+		calls++
+		return Int(fc.Args()[0].ToInt() + 1)
+	}))
+	run := func(src string) Value {
+		calls = 0
+		program, err := CompileScript("pipeline.gates", src)
+		assert.NoError(t, err)
+		v, err := r.RunProgram(context.Background(), program)
+		assert.NoError(t, err)
+		return v
+	}
+
+	v := run(`[1, 2, 3, 4, 5, 6, 7, 8] | map(inc) | filter(x => x % 2 == 0) | find(x => x > 3)`)
+	assert.EqualValues(t, 4, v.ToInt())
+	assert.Equal(t, 3, calls)
+
+	v = run(`[1, 2, 3, 4, 5, 6, 7, 8] | map(inc) | take(2) | reduce((acc, x) => acc + x, 0)`)
+	assert.EqualValues(t, 5, v.ToInt())
+	assert.Equal(t, 2, calls)
+
+	v = run(`[1, 2, 3] | map(inc) | find_index(x => x == 3)`)
+	assert.EqualValues(t, 1, v.ToInt())
+	assert.Equal(t, 2, calls)
+
+	// indexing, length and to_array materialize the pipeline once
+	v = run(`let xs = [1, 2, 3] | map(inc); [xs[-1], xs.length, (xs | to_array)[0]]`)
+	assert.Equal(t, []interface{}{int64(4), int64(3), int64(2)}, v.ToNative())
+	assert.Equal(t, 3, calls)
+
+	v = run(`([1, 2, 3] | map(inc) | filter(x => x > 2))[0]`)
+	assert.EqualValues(t, 3, v.ToInt())
+
+	v = run(`[1, 2, 3] | map(inc)`)
+	assert.Equal(t, NewArray([]Value{Int(2), Int(3), Int(4)}), v)
+	assert.True(t, v.Equals(NewArray([]Value{Int(2), Int(3), Int(4)})))
+	assert.Equal(t, "2,3,4", v.ToString())
+
+	v = run(`([1, 2] | map(inc)) == [2, 3] && type([1] | map(inc)) == "array"`)
+	assert.True(t, v.ToBool())
+
+	// an expression statement runs the callbacks, before the next
+	// statement
+	v = run(`[1, 2, 3] | map(inc); [4, 5] | filter(inc); 0`)
+	assert.EqualValues(t, 0, v.ToInt())
+	assert.Equal(t, 5, calls)
+	v = run(`let n = 0; [1, 2, 3] | map(x => { n = n + x; }); n`)
+	assert.EqualValues(t, 6, v.ToInt())
+
+	// a pipeline stored in a map or an array, assigned to a member, passed
+	// to a function or yielded runs its callbacks once, where it is created
+	v = run(`let n = 1; let m = { xs: [1, 2, 3] | map(x => x * n) }; n = 10; m.xs[0]`)
+	assert.EqualValues(t, 1, v.ToInt())
+	v = run(`let m = { xs: [1, 2, 3] | map(inc) }; [...m.xs, ...m.xs].length`)
+	assert.EqualValues(t, 6, v.ToInt())
+	assert.Equal(t, 3, calls)
+	v = run(`let a = [[1, 2, 3] | map(inc)]; [...a[0], ...a[0]].length`)
+	assert.EqualValues(t, 6, v.ToInt())
+	assert.Equal(t, 3, calls)
+	v = run(`let m = {}; m.xs = [1, 2, 3] | map(inc); [...m.xs, ...m.xs].length`)
+	assert.EqualValues(t, 6, v.ToInt())
+	assert.Equal(t, 3, calls)
+	v = run(`let m = { xs: [1, 2, 3] | map(inc) }; m.xs[0] = 9; m.xs[0]`)
+	assert.EqualValues(t, 9, v.ToInt())
+	v = run(`let n = 1; let f = (xs) => { n = 10; return xs[0]; }; f([1, 2, 3] | map(x => x * n))`)
+	assert.EqualValues(t, 1, v.ToInt())
+	v = run(`let n = 1; let g = function* () { yield [1, 2, 3] | map(x => x * n); }; let s = g() | to_array; n = 10; s[0][0]`)
+	assert.EqualValues(t, 1, v.ToInt())
+	r.Global().Set("keep", FunctionFunc(func(fc FunctionCall) Value {
+		_, lazy := fc.Args()[0].(*lazyArray)
+		assert.False(t, lazy)
+		return fc.Args()[0]
+	}))
+	v = run(`keep([1, 2, 3] | map(inc))[0]`)
+	assert.EqualValues(t, 2, v.ToInt())
+
+	// take over a host array
+	r.Global().Set("host", ToValue(hostArray{1, 2, 3, 4}))
+	v = run(`host | take(2)`)
+	assert.Equal(t, []interface{}{int64(1), int64(2)}, v.ToNative())
+	v = run(`(host | take(-1)).length`)
+	assert.EqualValues(t, 0, v.ToInt())
+}
+
+// hostArray is a host value that behaves like an array.
+type hostArray []int64
+
+func (hostArray) Type() string { return "array" }
+
+func (l hostArray) Get(r *Runtime, key Value) Value {
+	if key.IsString() && key.ToString() == "length" {
+		return Int(int64(len(l)))
+	}
+	if i := key.ToInt(); key.IsInt() && i >= 0 && i < int64(len(l)) {
+		return Int(l[i])
+	}
+	return Null
+}
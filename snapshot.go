@@ -0,0 +1,604 @@
+package gates
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+// ErrSnapshotMismatch is returned by RestoreExecution when a snapshot was
+// not taken from an execution of the given program.
+var ErrSnapshotMismatch = errors.New("snapshot does not match the program")
+
+// ErrSnapshotUnsupported is returned by Snapshot when the state of an
+// execution holds a value that cannot be encoded, such as a host value, or
+// the result of map or filter whose elements are yet to be computed.
+type ErrSnapshotUnsupported struct {
+	v Value
+}
+
+func (e *ErrSnapshotUnsupported) Error() string {
+	if _, ok := e.v.(*lazyArray); ok {
+		return "cannot snapshot array while its elements are computed"
+	}
+	if t := Type(e.v); t != "" {
+		return fmt.Sprintf("cannot snapshot %s value", t)
+	}
+	return fmt.Sprintf("cannot snapshot %T value", unref(e.v))
+}
+
+const snapshotVersion = 1
+
+type snapshotData struct {
+	Version     int
+	Fingerprint []byte
+
+	Done   bool
+	Result int
+
+	Program         int
+	Stash           int
+	Top             int
+	PC, BP          int
+	Stack           []int
+	CallStack       []snapshotFrame
+	RemainingCycles int
+	Bindings        map[string]int
+
+	Values  []snapshotValue
+	Stashes []snapshotStash
+}
+
+type snapshotFrame struct {
+	Program int
+	Stash   int
+	PC, BP  int
+}
+
+type snapshotKind uint8
+
+const (
+	snapshotNull snapshotKind = iota
+	snapshotBool
+	snapshotInt
+	snapshotFloat
+	snapshotString
+	snapshotDecimal
+	snapshotArray
+	snapshotMap
+	snapshotFunction
+	snapshotNative
+	snapshotGlobals
+	snapshotReadOnly
+)
+
+type snapshotValue struct {
+	Kind  snapshotKind
+	Int   int64
+	Float float64
+	Str   string
+	Keys  []string
+	Elems []int
+	Stash int
+}
+
+type snapshotStash struct {
+	Values []int
+	Names  map[string]uint32
+	Outer  int
+}
+
+// Snapshot encodes the state of a paused or completed execution. It can be
+// restored by RestoreExecution with the same program, e.g. compiled again
+// from the same source in another process. Host values and native
+// functions that are not reachable by name from the Global cannot be
+// encoded; the values of the Global itself are not part of the snapshot.
+func (e *Execution) Snapshot() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	programs := programList(e.program)
+	enc := &snapshotEncoder{
+		e:        e,
+		programs: make(map[*Program]int, len(programs)),
+		natives:  make(map[*nativeFunction]string),
+		maps:     make(map[uintptr]int),
+		arrays:   make(map[arrayKey]int),
+		stashes:  make(map[*stash]int),
+		data: &snapshotData{
+			Version:         snapshotVersion,
+			Fingerprint:     programFingerprint(programs),
+			Done:            e.done,
+			PC:              e.state.pc,
+			BP:              e.state.bp,
+			RemainingCycles: e.state.remainingCycles,
+		},
+	}
+	for i, p := range programs {
+		enc.programs[p] = i
+	}
+	rangeNatives(e.r.global, func(name string, f *nativeFunction) {
+		enc.natives[f] = name
+	})
+	if err := enc.encode(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(enc.data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type snapshotEncoder struct {
+	e        *Execution
+	programs map[*Program]int
+	natives  map[*nativeFunction]string
+	maps     map[uintptr]int
+	arrays   map[arrayKey]int
+	stashes  map[*stash]int
+	data     *snapshotData
+}
+
+// arrayKey identifies the elements of an array.
+type arrayKey struct {
+	len int
+	ptr uintptr
+}
+
+func (enc *snapshotEncoder) encode() (err error) {
+	e, data := enc.e, enc.data
+	if e.done {
+		data.Result, err = enc.value(e.result)
+		return err
+	}
+	if data.Program, err = enc.program(e.state.program); err != nil {
+		return err
+	}
+	if data.Stash, err = enc.stash(e.state.stash); err != nil {
+		return err
+	}
+	if data.Top, err = enc.stash(e.top); err != nil {
+		return err
+	}
+	for _, v := range e.state.stack {
+		id, err := enc.value(v)
+		if err != nil {
+			return err
+		}
+		data.Stack = append(data.Stack, id)
+	}
+	for _, c := range e.state.callStack {
+		frame := snapshotFrame{Program: -1, PC: c.pc, BP: c.bp}
+		if c.program != nil {
+			if frame.Program, err = enc.program(c.program); err != nil {
+				return err
+			}
+		}
+		if frame.Stash, err = enc.stash(c.stash); err != nil {
+			return err
+		}
+		data.CallStack = append(data.CallStack, frame)
+	}
+	if e.bindings != nil {
+		data.Bindings = make(map[string]int, len(e.bindings.m))
+		for name, v := range e.bindings.m {
+			if data.Bindings[name], err = enc.value(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (enc *snapshotEncoder) program(p *Program) (int, error) {
+	if i, ok := enc.programs[p]; ok {
+		return i, nil
+	}
+	return 0, ErrSnapshotMismatch
+}
+
+func (enc *snapshotEncoder) stash(s *stash) (int, error) {
+	if s == nil {
+		return -1, nil
+	}
+	if id, ok := enc.stashes[s]; ok {
+		return id, nil
+	}
+	id := len(enc.data.Stashes)
+	enc.stashes[s] = id
+	enc.data.Stashes = append(enc.data.Stashes, snapshotStash{Names: s.names})
+	values := make([]int, len(s.values.l))
+	for i, v := range s.values.l {
+		var err error
+		if values[i], err = enc.value(v); err != nil {
+			return 0, err
+		}
+	}
+	outer, err := enc.stash(s.outer)
+	if err != nil {
+		return 0, err
+	}
+	enc.data.Stashes[id].Values = values
+	enc.data.Stashes[id].Outer = outer
+	return id, nil
+}
+
+func (enc *snapshotEncoder) add(v snapshotValue) int {
+	enc.data.Values = append(enc.data.Values, v)
+	return len(enc.data.Values) - 1
+}
+
+func (enc *snapshotEncoder) value(v Value) (int, error) {
+	if v == enc.e.globals {
+		return enc.add(snapshotValue{Kind: snapshotGlobals}), nil
+	}
+	if a, ok := v.(*lazyArray); ok {
+		// computing the elements would run the callbacks of the script
+		// within Snapshot
+		if !a.done {
+			return 0, &ErrSnapshotUnsupported{v: v}
+		}
+		v = NewArray(a.values)
+	}
+	switch v := v.(type) {
+	case _Null:
+		return enc.add(snapshotValue{Kind: snapshotNull}), nil
+	case Bool:
+		sv := snapshotValue{Kind: snapshotBool}
+		if v {
+			sv.Int = 1
+		}
+		return enc.add(sv), nil
+	case Int:
+		return enc.add(snapshotValue{Kind: snapshotInt, Int: int64(v)}), nil
+	case Float:
+		return enc.add(snapshotValue{Kind: snapshotFloat, Float: float64(v)}), nil
+	case String:
+		return enc.add(snapshotValue{Kind: snapshotString, Str: string(v)}), nil
+	case Decimal:
+		return enc.add(snapshotValue{Kind: snapshotDecimal, Str: v.rat().RatString()}), nil
+	case Array:
+		// arrays sharing their elements are the same array, which may
+		// contain itself
+		key := arrayKey{len(v.values), 0}
+		if len(v.values) > 0 {
+			key.ptr = reflect.ValueOf(v.values).Pointer()
+			if id, ok := enc.arrays[key]; ok {
+				return id, nil
+			}
+		}
+		id := enc.add(snapshotValue{Kind: snapshotArray})
+		if key.ptr != 0 {
+			enc.arrays[key] = id
+		}
+		elems := make([]int, len(v.values))
+		for i, elem := range v.values {
+			var err error
+			if elems[i], err = enc.value(elem); err != nil {
+				return 0, err
+			}
+		}
+		enc.data.Values[id].Elems = elems
+		return id, nil
+	case Map:
+		ptr := reflect.ValueOf(v).Pointer()
+		if id, ok := enc.maps[ptr]; ok {
+			return id, nil
+		}
+		id := enc.add(snapshotValue{Kind: snapshotMap})
+		enc.maps[ptr] = id
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		elems := make([]int, len(keys))
+		for i, key := range keys {
+			var err error
+			if elems[i], err = enc.value(v[key]); err != nil {
+				return 0, err
+			}
+		}
+		enc.data.Values[id].Keys = keys
+		enc.data.Values[id].Elems = elems
+		return id, nil
+	case *readOnlyValue:
+		elem, err := enc.value(v.v)
+		if err != nil {
+			return 0, err
+		}
+		return enc.add(snapshotValue{Kind: snapshotReadOnly, Str: v.path, Elems: []int{elem}}), nil
+	case *literalFunction:
+		p, err := enc.program(v.program)
+		if err != nil {
+			return 0, err
+		}
+		s, err := enc.stash(v.stash)
+		if err != nil {
+			return 0, err
+		}
+		return enc.add(snapshotValue{Kind: snapshotFunction, Int: int64(p), Float: float64(v.stackSize), Stash: s}), nil
+	case *nativeFunction:
+		if name, ok := enc.natives[v]; ok {
+			return enc.add(snapshotValue{Kind: snapshotNative, Str: name}), nil
+		}
+	}
+	return 0, &ErrSnapshotUnsupported{v: v}
+}
+
+// RestoreExecution restores an execution of program from a snapshot taken
+// by Execution.Snapshot. The bindings, if any, are layered over the
+// Runtime's Global and receive the bindings recorded in the snapshot.
+func (r *Runtime) RestoreExecution(program *Program, bindings *Global, snapshot []byte) (*Execution, error) {
+	var data snapshotData
+	if err := gob.NewDecoder(bytes.NewReader(snapshot)).Decode(&data); err != nil {
+		return nil, err
+	}
+	programs := programList(program)
+	if data.Version != snapshotVersion || !bytes.Equal(data.Fingerprint, programFingerprint(programs)) {
+		return nil, ErrSnapshotMismatch
+	}
+	if data.Bindings != nil && bindings == nil {
+		bindings = NewGlobal()
+	}
+	e := r.NewExecution(program, bindings)
+	dec := &snapshotDecoder{
+		e:        e,
+		data:     &data,
+		programs: programs,
+		natives:  make(map[string]*nativeFunction),
+		values:   make([]Value, len(data.Values)),
+		stashes:  make([]*stash, len(data.Stashes)),
+	}
+	rangeNatives(r.global, func(name string, f *nativeFunction) {
+		dec.natives[name] = f
+	})
+	if err := dec.decode(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+type snapshotDecoder struct {
+	e        *Execution
+	data     *snapshotData
+	programs []*Program
+	natives  map[string]*nativeFunction
+	values   []Value
+	stashes  []*stash
+}
+
+func (dec *snapshotDecoder) decode() (err error) {
+	e, data := dec.e, dec.data
+	for i := range dec.stashes {
+		dec.stashes[i] = &stash{names: data.Stashes[i].Names}
+	}
+	for i, s := range data.Stashes {
+		values := make([]Value, len(s.Values))
+		for j, id := range s.Values {
+			if values[j], err = dec.value(id); err != nil {
+				return err
+			}
+		}
+		dec.stashes[i].values.l = values
+		if dec.stashes[i].outer, err = dec.stash(s.Outer); err != nil {
+			return err
+		}
+	}
+	if data.Done {
+		e.done = true
+		e.result, err = dec.value(data.Result)
+		return err
+	}
+
+	state := execState{
+		pc:              data.PC,
+		bp:              data.BP,
+		remainingCycles: data.RemainingCycles,
+	}
+	if state.program, err = dec.program(data.Program); err != nil {
+		return err
+	}
+	if state.stash, err = dec.stash(data.Stash); err != nil {
+		return err
+	}
+	if data.Top >= 0 {
+		if e.top, err = dec.stash(data.Top); err != nil {
+			return err
+		}
+	}
+	for _, id := range data.Stack {
+		v, err := dec.value(id)
+		if err != nil {
+			return err
+		}
+		state.stack = append(state.stack, v)
+	}
+	for _, frame := range data.CallStack {
+		c := ctx{pc: frame.PC, bp: frame.BP}
+		if frame.Program >= 0 {
+			if c.program, err = dec.program(frame.Program); err != nil {
+				return err
+			}
+		}
+		if c.stash, err = dec.stash(frame.Stash); err != nil {
+			return err
+		}
+		state.callStack = append(state.callStack, c)
+	}
+	for name, id := range data.Bindings {
+		v, err := dec.value(id)
+		if err != nil {
+			return err
+		}
+		e.bindings.Set(name, v)
+	}
+	e.state = state
+	return nil
+}
+
+func (dec *snapshotDecoder) program(i int) (*Program, error) {
+	if i < 0 || i >= len(dec.programs) {
+		return nil, ErrSnapshotMismatch
+	}
+	return dec.programs[i], nil
+}
+
+func (dec *snapshotDecoder) stash(id int) (*stash, error) {
+	if id == -1 {
+		return nil, nil
+	}
+	if id < 0 || id >= len(dec.stashes) {
+		return nil, ErrSnapshotMismatch
+	}
+	return dec.stashes[id], nil
+}
+
+func (dec *snapshotDecoder) value(id int) (Value, error) {
+	if id < 0 || id >= len(dec.values) {
+		return nil, ErrSnapshotMismatch
+	}
+	if v := dec.values[id]; v != nil {
+		return v, nil
+	}
+	sv := dec.data.Values[id]
+	var v Value
+	switch sv.Kind {
+	case snapshotNull:
+		v = Null
+	case snapshotBool:
+		v = Bool(sv.Int != 0)
+	case snapshotInt:
+		v = Int(sv.Int)
+	case snapshotFloat:
+		v = Float(sv.Float)
+	case snapshotString:
+		v = String(sv.Str)
+	case snapshotDecimal:
+		r, ok := new(big.Rat).SetString(sv.Str)
+		if !ok {
+			return nil, ErrSnapshotMismatch
+		}
+		v = Decimal{r: r}
+	case snapshotArray:
+		values := make([]Value, len(sv.Elems))
+		a := NewArray(values)
+		dec.values[id] = a
+		for i, elem := range sv.Elems {
+			var err error
+			if values[i], err = dec.value(elem); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case snapshotMap:
+		if len(sv.Keys) != len(sv.Elems) {
+			return nil, ErrSnapshotMismatch
+		}
+		m := make(Map, len(sv.Keys))
+		dec.values[id] = m
+		for i, key := range sv.Keys {
+			elem, err := dec.value(sv.Elems[i])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = elem
+		}
+		return m, nil
+	case snapshotFunction:
+		p, err := dec.program(int(sv.Int))
+		if err != nil {
+			return nil, err
+		}
+		s, err := dec.stash(sv.Stash)
+		if err != nil {
+			return nil, err
+		}
+		v = &literalFunction{program: p, stackSize: int(sv.Float), stash: s}
+	case snapshotNative:
+		f, ok := dec.natives[sv.Str]
+		if !ok {
+			return nil, fmt.Errorf("native function %s not found", sv.Str)
+		}
+		v = f
+	case snapshotGlobals:
+		v = dec.e.globals
+	case snapshotReadOnly:
+		if len(sv.Elems) != 1 {
+			return nil, ErrSnapshotMismatch
+		}
+		elem, err := dec.value(sv.Elems[0])
+		if err != nil {
+			return nil, err
+		}
+		v = readOnlyView(sv.Str, elem)
+	default:
+		return nil, ErrSnapshotMismatch
+	}
+	dec.values[id] = v
+	return v, nil
+}
+
+// programList returns program and the programs of the function literals
+// nested in it, in the order they appear in the code.
+func programList(program *Program) []*Program {
+	programs := []*Program{program}
+	for i := 0; i < len(programs); i++ {
+		for _, ins := range programs[i].code {
+			if f, ok := ins.(*newFunc); ok {
+				programs = append(programs, f.program)
+			}
+		}
+	}
+	return programs
+}
+
+// programFingerprint identifies compiled code, so that a snapshot is only
+// restored into the program it was taken from.
+func programFingerprint(programs []*Program) []byte {
+	h := sha256.New()
+	index := make(map[*Program]int, len(programs))
+	for i, p := range programs {
+		index[p] = i
+	}
+	for _, p := range programs {
+		fmt.Fprintf(h, "program %d %d %t %t\n", len(p.code), len(p.values), p.script, p.generator)
+		for _, ins := range p.code {
+			if f, ok := ins.(*newFunc); ok {
+				fmt.Fprintf(h, "newFunc %d %d\n", index[f.program], f.stackSize)
+				continue
+			}
+			fmt.Fprintf(h, "%T %v\n", ins, ins)
+		}
+		for _, v := range p.values {
+			fmt.Fprintf(h, "%s %q\n", Type(v), v.ToString())
+		}
+	}
+	if globals := programs[0].globals; globals != nil {
+		fmt.Fprintf(h, "globals %q\n", globals.names)
+	}
+	return h.Sum(nil)
+}
+
+// rangeNatives calls f for the native functions of g and of the maps in g,
+// such as the strings package, with their dotted names.
+func rangeNatives(g *Global, f func(name string, fn *nativeFunction)) {
+	for name, v := range g.m {
+		switch v := v.(type) {
+		case *nativeFunction:
+			f(name, v)
+		case Map:
+			for key, v := range v {
+				if fn, ok := v.(*nativeFunction); ok {
+					f(name+"."+key, fn)
+				}
+			}
+		}
+	}
+}
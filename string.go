@@ -44,6 +44,9 @@ func (s String) ToFunction() Function                   { return _EmptyFunction
 func (s String) ToNative(...ToNativeOption) interface{} { return string(s) }
 
 func (s String) Equals(other Value) bool {
+	if d, ok := other.(Decimal); ok {
+		return d.Equals(s)
+	}
 	switch {
 	case other.IsString():
 		return s.SameAs(other)
@@ -67,7 +70,10 @@ func (s String) Get(r *Runtime, key Value) Value {
 	case key.IsInt():
 		index := int(key.ToInt())
 		if index < 0 {
-			return Null
+			index += utf8.RuneCountInString(string(s))
+			if index < 0 {
+				return Null
+			}
 		}
 		i := 0
 		start := -1
@@ -93,3 +99,14 @@ func (s String) Get(r *Runtime, key Value) Value {
 
 	return Null
 }
+
+func (s String) Slice(r *Runtime, low, high Value) Value {
+	runes := []rune(string(s))
+	l, h, ok := sliceBounds(low, high, len(runes))
+	if !ok {
+		return Null
+	}
+	return String(runes[l:h])
+}
+
+func (s String) Length(r *Runtime) int { return utf8.RuneCountInString(string(s)) }
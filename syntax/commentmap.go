@@ -0,0 +1,101 @@
+package syntax
+
+// A CommentMap maps a node to the comment groups associated with it.
+type CommentMap map[Node][]*CommentGroup
+
+// NewCommentMap associates each comment group of comments with a node of
+// the tree rooted at node:
+//
+//   - a group that starts on the line where a statement ends is associated
+//     with that statement, e.g. a trailing comment;
+//   - otherwise it is associated with the next statement in the innermost
+//     node enclosing the group, e.g. a leading comment;
+//   - otherwise it is associated with the innermost enclosing node itself.
+//
+// Where several statements qualify, the outermost one is chosen. The
+// comments must be sorted by position, as in Script.Comments.
+func NewCommentMap(fset *FileSet, node Node, comments []*CommentGroup) CommentMap {
+	cmap := make(CommentMap)
+
+	// The nodes, in depth-first order, and the groups are swept together
+	// in the order of their positions.
+	var (
+		stack   []Node // the nodes enclosing the current position
+		last    Node   // the outermost statement that ended last
+		pending []pendingComment
+	)
+	// advance moves the current position to pos.
+	advance := func(pos Pos) {
+		for len(stack) > 0 && stack[len(stack)-1].End() <= pos {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if _, ok := n.(Stmt); ok && (last == nil || n.End() >= last.End()) {
+				last = n
+			}
+		}
+		// a group is associated with its enclosing node once the node ends
+		// without a next statement
+		k := 0
+		for _, p := range pending {
+			if p.enclosing.End() <= pos {
+				cmap[p.enclosing] = append(cmap[p.enclosing], p.group)
+				continue
+			}
+			pending[k] = p
+			k++
+		}
+		pending = pending[:k]
+	}
+	visitComment := func(g *CommentGroup) {
+		advance(g.Pos())
+		if last != nil && fset.Position(last.End()).Line == fset.Position(g.Pos()).Line {
+			cmap[last] = append(cmap[last], g)
+			return
+		}
+		enclosing := node
+		if len(stack) > 0 {
+			enclosing = stack[len(stack)-1]
+		}
+		pending = append(pending, pendingComment{g, enclosing})
+	}
+
+	i := 0
+	Inspect(node, func(n Node) bool {
+		if n == nil {
+			return true
+		}
+		for ; i < len(comments) && comments[i].End() <= n.Pos(); i++ {
+			visitComment(comments[i])
+		}
+		advance(n.Pos())
+		if _, ok := n.(Stmt); ok {
+			// n is the next statement of the pending groups enclosing it
+			k := 0
+			for _, p := range pending {
+				if n.End() <= p.enclosing.End() {
+					cmap[n] = append(cmap[n], p.group)
+					continue
+				}
+				pending[k] = p
+				k++
+			}
+			pending = pending[:k]
+		}
+		stack = append(stack, n)
+		return true
+	})
+	for ; i < len(comments); i++ {
+		visitComment(comments[i])
+	}
+	for _, p := range pending {
+		cmap[p.enclosing] = append(cmap[p.enclosing], p.group)
+	}
+	return cmap
+}
+
+// A pendingComment is a comment group waiting for the next statement in
+// its enclosing node.
+type pendingComment struct {
+	group     *CommentGroup
+	enclosing Node
+}
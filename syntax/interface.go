@@ -1,28 +1,92 @@
 package syntax
 
+// ParseExpr parses a single expression.
 func ParseExpr(x string) (e Expr, err error) {
+	return ParseExprFrom(NewFileSet(), "", x)
+}
+
+// ParseExprFrom parses a single expression and adds it to fset under the
+// given filename.
+func ParseExprFrom(fset *FileSet, filename string, x string) (e Expr, err error) {
+	return Config{}.ParseExpr(fset, filename, x)
+}
+
+// ParseComments makes ParseFileMode collect the comments of the source in
+// Script.Comments.
+const ParseComments = ScanComments
+
+// DefaultErrorLimit is the number of errors after which the parser stops
+// unless Config.ErrorLimit is set.
+const DefaultErrorLimit = 10
+
+// A Config controls the parsing of a source.
+type Config struct {
+	Mode Mode // e.g. ParseComments
+
+	// ErrorLimit is the maximum number of errors reported. The parser
+	// stops when it is reached. If it is 0, DefaultErrorLimit is used; if
+	// it is negative, all the errors are reported.
+	ErrorLimit int
+}
+
+// ParseFile parses the source code of a script, which is a list of
+// statements, and adds it to fset under the given filename.
+//
+// If the source has syntax errors, ParseFile returns an ErrorList with at
+// most one error per line, sorted by position, and the partial syntax
+// tree, in which BadExpr and BadStmt nodes stand for the erroneous parts.
+// The tree is nil if the parser stopped at the error limit.
+func ParseFile(fset *FileSet, filename string, src string) (f *Script, err error) {
+	return Config{}.ParseFile(fset, filename, src)
+}
+
+// ParseFileMode is like ParseFile but the mode controls the amount of
+// source parsed, e.g. whether comments are collected.
+func ParseFileMode(fset *FileSet, filename string, src string, mode Mode) (f *Script, err error) {
+	return Config{Mode: mode}.ParseFile(fset, filename, src)
+}
+
+// ParseFile is like the function ParseFile but is controlled by conf.
+func (conf Config) ParseFile(fset *FileSet, filename string, src string) (f *Script, err error) {
 	var p parser
 
 	defer func() {
-		if e := recover(); e != nil {
+		if x := recover(); x != nil {
 			// resume same panic if it's not a bailout
-			if _, ok := e.(bailout); !ok {
-				panic(e)
+			if _, ok := x.(bailout); !ok {
+				panic(x)
 			}
+			f = nil
 		}
-		p.errors.Sort()
-		err = p.errors.Err()
+		err = p.finish()
+	}()
+
+	// parse script
+	p.init(fset, filename, []byte(src), conf)
+	f = p.parseScript()
+
+	return f, nil
+}
+
+// ParseExpr is like ParseExprFrom but is controlled by conf.
+func (conf Config) ParseExpr(fset *FileSet, filename string, x string) (e Expr, err error) {
+	var p parser
+
+	defer func() {
+		if x := recover(); x != nil {
+			// resume same panic if it's not a bailout
+			if _, ok := x.(bailout); !ok {
+				panic(x)
+			}
+			e = nil
+		}
+		err = p.finish()
 	}()
 
 	// parse expr
-	p.init(NewFileSet(), "", []byte(x))
+	p.init(fset, filename, []byte(x), conf)
 	e = p.parseExpr()
 	p.expect(EOF)
 
-	if p.errors.Len() > 0 {
-		p.errors.Sort()
-		return nil, p.errors.Err()
-	}
-
 	return e, nil
 }
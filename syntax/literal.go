@@ -0,0 +1,57 @@
+package syntax
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unquote interprets lit, the literal of a STRING token, returning the
+// string value that lit represents. lit may be a double-quoted or a
+// single-quoted string with escape sequences including \u{...}, or a raw
+// string in back quotes whose carriage returns are discarded.
+func Unquote(lit string) (string, error) {
+	n := len(lit)
+	if n < 2 || lit[0] != lit[n-1] {
+		return "", strconv.ErrSyntax
+	}
+	quote, body := lit[0], lit[1:n-1]
+	switch quote {
+	case '`':
+		if strings.IndexByte(body, '`') >= 0 {
+			return "", strconv.ErrSyntax
+		}
+		return strings.Replace(body, "\r", "", -1), nil
+	case '"', '\'':
+	default:
+		return "", strconv.ErrSyntax
+	}
+
+	var buf strings.Builder
+	for len(body) > 0 {
+		if strings.HasPrefix(body, `\u{`) {
+			end := strings.IndexByte(body, '}')
+			if end < 4 || end > 9 {
+				return "", strconv.ErrSyntax
+			}
+			v, err := strconv.ParseUint(body[3:end], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(v)) {
+				return "", strconv.ErrSyntax
+			}
+			buf.WriteRune(rune(v))
+			body = body[end+1:]
+			continue
+		}
+		c, multibyte, tail, err := strconv.UnquoteChar(body, quote)
+		if err != nil {
+			return "", err
+		}
+		if c < utf8.RuneSelf || !multibyte {
+			buf.WriteByte(byte(c))
+		} else {
+			buf.WriteRune(c)
+		}
+		body = tail
+	}
+	return buf.String(), nil
+}
@@ -1,6 +1,65 @@
 package syntax
 
+import "strings"
+
+// A Comment node represents a single //-style or /*-style comment.
+type Comment struct {
+	Slash Pos    // position of "/" starting the comment
+	Text  string // comment text including the comment markers
+}
+
+func (c *Comment) Pos() Pos { return c.Slash }
+func (c *Comment) End() Pos { return Pos(int(c.Slash) + len(c.Text)) }
+
+// A CommentGroup represents a sequence of comments with no other tokens
+// and no empty lines between.
+type CommentGroup struct {
+	List []*Comment // len(List) > 0
+}
+
+func (g *CommentGroup) Pos() Pos { return g.List[0].Pos() }
+func (g *CommentGroup) End() Pos { return g.List[len(g.List)-1].End() }
+
+// Text returns the text of the comment group without the comment markers
+// and with leading and trailing empty lines removed.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	var lines []string
+	for _, c := range g.List {
+		if strings.HasPrefix(c.Text, "/*") {
+			text := strings.TrimSuffix(c.Text[2:], "*/")
+			for _, line := range strings.Split(text, "\n") {
+				lines = append(lines, strings.TrimSpace(line))
+			}
+			continue
+		}
+		text := strings.TrimPrefix(c.Text, "//")
+		text = strings.TrimPrefix(text, " ")
+		lines = append(lines, strings.TrimRight(text, " \t"))
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// All node types implement the Node interface.
+type Node interface {
+	Pos() Pos // position of first character belonging to the node
+	End() Pos // position of first character immediately after the node
+}
+
+// All expression nodes implement the Expr interface.
 type Expr interface {
+	Node
 	exprNode()
 }
 
@@ -8,7 +67,9 @@ type expr struct{}
 
 func (expr) exprNode() {}
 
+// All statement nodes implement the Stmt interface.
 type Stmt interface {
+	Node
 	stmtNode()
 }
 
@@ -42,8 +103,12 @@ type (
 		Rbrack   Pos
 	}
 
+	// The Key of a MapLitEntry is an *Ident for a key written as a name,
+	// e.g. {a: 1}, which stands for the string "a". A Computed key is
+	// written in brackets, e.g. {["a" + b]: 1}.
 	MapLitEntry struct {
 		Expanded bool
+		Computed bool
 		Key      Expr
 		Value    Expr
 	}
@@ -58,7 +123,9 @@ type (
 	FunctionLit struct {
 		expr
 		Function      Pos
+		Star          Pos // position of "*" of a generator function, or NoPos
 		ParameterList *ParameterList
+		Arrow         Pos // position of "=>" of an arrow function, or NoPos
 		Body          *FunctionBody
 	}
 
@@ -98,6 +165,15 @@ type (
 		Rbrack Pos
 	}
 
+	SliceExpr struct {
+		expr
+		X      Expr
+		Lbrack Pos
+		Low    Expr
+		High   Expr
+		Rbrack Pos
+	}
+
 	CallExpr struct {
 		expr
 		Fun    Expr
@@ -162,6 +238,12 @@ type (
 		Result Expr
 	}
 
+	YieldStmt struct {
+		stmt
+		Yield Pos
+		Value Expr
+	}
+
 	BadExpr struct {
 		expr
 		From, To Pos
@@ -172,15 +254,156 @@ type (
 		From, To Pos
 	}
 
+	Script struct {
+		FileStart Pos
+		StmtList  []Stmt
+		FileEnd   Pos
+		Comments  []*CommentGroup // list of all comments in the source, see ParseComments
+	}
+
+	// A ParameterList has no parentheses if it is the single parameter
+	// of an arrow function, e.g. x => x; Lparen and Rparen are NoPos then.
 	ParameterList struct {
 		Lparen Pos
 		List   []*Ident
 		Rparen Pos
 	}
 
+	// A FunctionBody has no braces if it is the expression body of an
+	// arrow function, which is a single return statement; Lbrace and
+	// Rbrace are NoPos then.
 	FunctionBody struct {
 		Lbrace   Pos
 		StmtList []Stmt
 		Rbrace   Pos
 	}
 )
+
+// Pos and End implementations for expression nodes.
+
+func (x *Ident) Pos() Pos        { return x.NamePos }
+func (x *Lit) Pos() Pos          { return x.ValuePos }
+func (x *ArrayLit) Pos() Pos     { return x.Lbrack }
+func (x *MapLit) Pos() Pos       { return x.Lbrace }
+func (x *FunctionLit) Pos() Pos  { return x.Function }
+func (x *UnaryExpr) Pos() Pos    { return x.OpPos }
+func (x *BinaryExpr) Pos() Pos   { return x.X.Pos() }
+func (x *ParenExpr) Pos() Pos    { return x.Lparen }
+func (x *SelectorExpr) Pos() Pos { return x.X.Pos() }
+func (x *IndexExpr) Pos() Pos    { return x.X.Pos() }
+func (x *SliceExpr) Pos() Pos    { return x.X.Pos() }
+func (x *CallExpr) Pos() Pos     { return x.Fun.Pos() }
+func (x *VarDeclExpr) Pos() Pos  { return x.NamePos }
+func (x *BadExpr) Pos() Pos      { return x.From }
+
+func (x *Ident) End() Pos        { return Pos(int(x.NamePos) + len(x.Name)) }
+func (x *Lit) End() Pos          { return Pos(int(x.ValuePos) + len(x.Value)) }
+func (x *ArrayLit) End() Pos     { return x.Rbrack + 1 }
+func (x *MapLit) End() Pos       { return x.Rbrace + 1 }
+func (x *FunctionLit) End() Pos  { return x.Body.End() }
+func (x *UnaryExpr) End() Pos    { return x.X.End() }
+func (x *BinaryExpr) End() Pos   { return x.Y.End() }
+func (x *ParenExpr) End() Pos    { return x.Rparen + 1 }
+func (x *SelectorExpr) End() Pos { return x.Sel.End() }
+func (x *IndexExpr) End() Pos    { return x.Rbrack + 1 }
+func (x *SliceExpr) End() Pos    { return x.Rbrack + 1 }
+func (x *CallExpr) End() Pos     { return x.Rparen + 1 }
+func (x *BadExpr) End() Pos      { return x.To }
+
+func (x *VarDeclExpr) End() Pos {
+	if x.Initializer != nil {
+		return x.Initializer.End()
+	}
+	return Pos(int(x.NamePos) + len(x.Name))
+}
+
+// Pos and End implementations for statement nodes.
+
+func (s *AssignStmt) Pos() Pos { return s.Lhs.Pos() }
+func (s *ExprStmt) Pos() Pos   { return s.X.Pos() }
+func (s *BodyStmt) Pos() Pos   { return s.Lbrace }
+func (s *LetStmt) Pos() Pos    { return s.Let }
+func (s *IfStmt) Pos() Pos     { return s.If }
+func (s *ForStmt) Pos() Pos    { return s.For }
+func (s *ReturnStmt) Pos() Pos { return s.Return }
+func (s *YieldStmt) Pos() Pos  { return s.Yield }
+func (s *BadStmt) Pos() Pos    { return s.From }
+
+func (s *AssignStmt) End() Pos { return s.Rhs.End() }
+func (s *ExprStmt) End() Pos   { return s.X.End() }
+func (s *BodyStmt) End() Pos   { return s.Rbrace + 1 }
+func (s *ForStmt) End() Pos    { return s.Body.End() }
+func (s *BadStmt) End() Pos    { return s.To }
+
+func (s *LetStmt) End() Pos {
+	if n := len(s.List); n > 0 {
+		return s.List[n-1].End()
+	}
+	return s.Let + 3 // len("let")
+}
+
+func (s *IfStmt) End() Pos {
+	if s.Alternate != nil {
+		return s.Alternate.End()
+	}
+	return s.Consequent.End()
+}
+
+func (s *ReturnStmt) End() Pos {
+	if s.Result != nil {
+		return s.Result.End()
+	}
+	return s.Return + 6 // len("return")
+}
+
+func (s *YieldStmt) End() Pos {
+	if s.Value != nil {
+		return s.Value.End()
+	}
+	return s.Yield + 5 // len("yield")
+}
+
+// Pos and End implementations for other nodes.
+
+func (s *Script) Pos() Pos { return s.FileStart }
+func (s *Script) End() Pos { return s.FileEnd }
+
+func (l *ParameterList) Pos() Pos {
+	if l.Lparen.IsValid() {
+		return l.Lparen
+	}
+	if len(l.List) > 0 {
+		return l.List[0].Pos()
+	}
+	return NoPos
+}
+
+func (l *ParameterList) End() Pos {
+	if l.Rparen.IsValid() {
+		return l.Rparen + 1
+	}
+	if n := len(l.List); n > 0 {
+		return l.List[n-1].End()
+	}
+	return NoPos
+}
+
+func (b *FunctionBody) Pos() Pos {
+	if b.Lbrace.IsValid() {
+		return b.Lbrace
+	}
+	if len(b.StmtList) > 0 {
+		return b.StmtList[0].Pos()
+	}
+	return NoPos
+}
+
+func (b *FunctionBody) End() Pos {
+	if b.Rbrace.IsValid() {
+		return b.Rbrace + 1
+	}
+	if n := len(b.StmtList); n > 0 {
+		return b.StmtList[n-1].End()
+	}
+	return NoPos
+}
@@ -1,30 +1,69 @@
 package syntax
 
-import (
-	"strconv"
-)
-
 type parser struct {
 	file    *File
 	errors  ErrorList
 	scanner Scanner
+	mode    Mode
+	limit   int // maximum number of errors, or < 0
+
+	// Comments
+	comments []*CommentGroup
 
 	// Next token
 	pos Pos    // token position
 	tok Token  // one token look-ahead
 	lit string // token literal
+
+	prevLine int // line of the end of the previous token
 }
 
-func (p *parser) init(fset *FileSet, filename string, src []byte) {
+func (p *parser) init(fset *FileSet, filename string, src []byte, conf Config) {
 	p.file = fset.AddFile(filename, -1, len(src))
 	eh := func(pos Position, msg string) { p.errors.Add(pos, msg) }
-	p.scanner.Init(p.file, src, eh)
+	p.scanner.InitMode(p.file, src, eh, conf.Mode&ParseComments)
+	p.mode = conf.Mode
+	p.limit = conf.ErrorLimit
+	if p.limit == 0 {
+		p.limit = DefaultErrorLimit
+	}
 
 	p.next()
 }
 
+// consumeCommentGroup collects the comments starting at the current token
+// into a group. Comments belong to the same group if they are at most n
+// lines apart.
+func (p *parser) consumeCommentGroup(n int) {
+	var list []*Comment
+	endline := p.file.Line(p.pos)
+	for p.tok == COMMENT && p.file.Line(p.pos) <= endline+n {
+		comment := &Comment{Slash: p.pos, Text: p.lit}
+		endline = p.file.Line(comment.End())
+		list = append(list, comment)
+		p.pos, p.tok, p.lit = p.scanner.Scan()
+	}
+	p.comments = append(p.comments, &CommentGroup{List: list})
+}
+
+// next advances to the next non-comment token. In ParseComments mode the
+// comments are collected in groups; a comment on the same line as the
+// previous token forms a group of its own.
 func (p *parser) next() {
+	prev := p.pos
+	if prev.IsValid() {
+		p.prevLine = p.file.Line(Pos(int(prev) + len(p.lit)))
+	}
 	p.pos, p.tok, p.lit = p.scanner.Scan()
+	if p.tok != COMMENT {
+		return
+	}
+	if prev.IsValid() && p.file.Line(p.pos) == p.file.Line(prev) {
+		p.consumeCommentGroup(0)
+	}
+	for p.tok == COMMENT {
+		p.consumeCommentGroup(1)
+	}
 }
 
 // A bailout panic is raised to indicate early termination.
@@ -33,16 +72,27 @@ type bailout struct{}
 func (p *parser) error(pos Pos, msg string) {
 	epos := p.file.Position(pos)
 
-	// If AllErrors is not set, discard errors reported on the same line
-	// as the last recorded error and stop parsing if there are more than
-	// 10 errors.
+	// Discard errors reported on the same line as the last recorded error
+	// and stop parsing if the error limit is reached.
 	n := len(p.errors)
 	if n > 0 && p.errors[n-1].Pos.Line == epos.Line {
 		return // discard - likely a spurious error
 	}
 
 	p.errors.Add(epos, msg)
-	panic(bailout{})
+	if p.limit > 0 && len(p.errors) >= p.limit {
+		panic(bailout{})
+	}
+}
+
+// finish sorts the errors, removes the errors following the first one on
+// a line and applies the error limit.
+func (p *parser) finish() error {
+	p.errors.RemoveMultiples()
+	if p.limit > 0 && len(p.errors) > p.limit {
+		p.errors = p.errors[:p.limit]
+	}
+	return p.errors.Err()
 }
 
 func (p *parser) errorExpected(pos Pos, msg string) {
@@ -65,11 +115,57 @@ func (p *parser) expect(tok Token) Pos {
 	pos := p.pos
 	if p.tok != tok {
 		p.errorExpected(pos, "'"+tok.String()+"'")
+		if p.tok == SEMICOLON || p.tok == LBRACE || p.tok == RBRACE || p.tok == EOF || stmtStart[p.tok] {
+			// keep the tokens delimiting statements and blocks for
+			// recovery
+			return pos
+		}
 	}
 	p.next() // make progress
 	return pos
 }
 
+// expectSemi consumes the semicolon terminating a statement. The semicolon
+// may be omitted before a closing "}" or at the end of the source. If the
+// statement does not end here, the rest of it is skipped unless the
+// semicolon is missing at the end of a line.
+func (p *parser) expectSemi() {
+	switch p.tok {
+	case RBRACE, EOF:
+	case SEMICOLON:
+		p.next()
+	default:
+		p.errorExpected(p.pos, "';'")
+		if p.file.Line(p.pos) == p.prevLine {
+			p.advance(stmtStart)
+		}
+	}
+}
+
+// stmtStart is the set of tokens that certainly start a statement.
+var stmtStart = map[Token]bool{
+	LET:    true,
+	IF:     true,
+	FOR:    true,
+	RETURN: true,
+	YIELD:  true,
+}
+
+// advance consumes tokens until the current token is in the to set or a
+// closing "}", or until after a semicolon, to resynchronize the parser
+// after an error.
+func (p *parser) advance(to map[Token]bool) {
+	for ; p.tok != EOF; p.next() {
+		if p.tok == SEMICOLON {
+			p.next()
+			return
+		}
+		if to[p.tok] || p.tok == RBRACE {
+			return
+		}
+	}
+}
+
 func (p *parser) parseIdent() *Ident {
 	pos := p.pos
 	name := ""
@@ -125,23 +221,20 @@ func (p *parser) parseMapLit() *MapLit {
 				})
 			} else {
 				var key Expr
+				computed := false
 				if p.tok == LBRACK {
 					p.next()
 					key = p.parseExpr()
 					p.expect(RBRACK)
+					computed = true
 				} else if p.tok == IDENT {
-					ident := p.parseIdent()
-					key = &Lit{
-						ValuePos: ident.NamePos,
-						Kind:     STRING,
-						Value:    strconv.Quote(ident.Name),
-					}
+					key = p.parseIdent()
 				} else {
 					key = p.parseOperand()
 				}
 				p.expect(COLON)
 				value := p.parseExpr()
-				entries = append(entries, MapLitEntry{Key: key, Value: value})
+				entries = append(entries, MapLitEntry{Computed: computed, Key: key, Value: value})
 			}
 			if p.tok != COMMA {
 				break
@@ -155,11 +248,17 @@ func (p *parser) parseMapLit() *MapLit {
 
 func (p *parser) parseFunction() *FunctionLit {
 	function := p.expect(FUNCTION)
+	var star Pos
+	if p.tok == MUL {
+		star = p.pos
+		p.next()
+	}
 	parameterList := p.parseFunctionParameterList()
 	body := p.parseFunctionBody()
 
 	return &FunctionLit{
 		Function:      function,
+		Star:          star,
 		ParameterList: parameterList,
 		Body:          body,
 	}
@@ -203,28 +302,31 @@ func (p *parser) parseFunctionBody() *FunctionBody {
 	}
 }
 
-func (p *parser) parseArrowFunction(paramStart, paramEnd Pos, params []*Ident) *FunctionLit {
+// parseArrowFunction parses the "=>" and the body of an arrow function
+// starting at start. lparen and rparen are NoPos if the single parameter
+// is not parenthesized.
+func (p *parser) parseArrowFunction(start, lparen, rparen Pos, params []*Ident) *FunctionLit {
 	f := &FunctionLit{
-		Function: paramStart,
+		Function: start,
 		ParameterList: &ParameterList{
-			Lparen: paramStart,
+			Lparen: lparen,
 			List:   params,
-			Rparen: paramEnd,
+			Rparen: rparen,
 		},
+		Arrow: p.expect(ARROW),
 	}
 	if p.tok == LBRACE {
 		f.Body = p.parseFunctionBody()
 	} else {
+		pos := p.pos
 		f.Body = &FunctionBody{
-			Lbrace: p.pos,
-		}
-		f.Body.StmtList = []Stmt{
-			&ReturnStmt{
-				Return: p.pos,
-				Result: p.parseExpr(),
+			StmtList: []Stmt{
+				&ReturnStmt{
+					Return: pos,
+					Result: p.parseExpr(),
+				},
 			},
 		}
-		f.Body.Rbrace = p.pos
 	}
 	return f
 }
@@ -235,9 +337,7 @@ func (p *parser) parseOperand() Expr {
 		paramStart := p.pos
 		x := p.parseIdent()
 		if p.tok == ARROW { // arrow function?
-			paramEnd := p.pos
-			p.next()
-			return p.parseArrowFunction(paramStart, paramEnd, []*Ident{x})
+			return p.parseArrowFunction(paramStart, NoPos, NoPos, []*Ident{x})
 		}
 		return x
 
@@ -252,8 +352,7 @@ func (p *parser) parseOperand() Expr {
 		if p.tok == RPAREN { // arrow function?
 			rparen := p.pos
 			p.next()
-			p.expect(ARROW)
-			return p.parseArrowFunction(lparen, rparen, nil)
+			return p.parseArrowFunction(lparen, lparen, rparen, nil)
 		}
 		x := p.parseExpr()
 		xIdent, isIdent := x.(*Ident)
@@ -270,13 +369,15 @@ func (p *parser) parseOperand() Expr {
 				}
 			}
 			rparen := p.expect(RPAREN)
-			p.expect(ARROW)
-			return p.parseArrowFunction(lparen, rparen, params)
+			return p.parseArrowFunction(lparen, lparen, rparen, params)
 		}
 		rparen := p.expect(RPAREN)
 		if p.tok == ARROW { // arrow function?
-			p.next()
-			return p.parseArrowFunction(lparen, rparen, []*Ident{xIdent})
+			if !isIdent {
+				p.errorExpected(x.Pos(), "parameter name")
+				xIdent = &Ident{NamePos: x.Pos()}
+			}
+			return p.parseArrowFunction(lparen, lparen, rparen, []*Ident{xIdent})
 		}
 		return &ParenExpr{Lparen: lparen, X: x, Rparen: rparen}
 
@@ -303,10 +404,24 @@ func (p *parser) parseSelector(x Expr) Expr {
 
 func (p *parser) parseIndex(x Expr) Expr {
 	lbrack := p.expect(LBRACK)
-	index := p.parseExpr()
+	var index [2]Expr
+	if p.tok != COLON {
+		index[0] = p.parseExpr()
+	}
+	slice := false
+	if p.tok == COLON {
+		slice = true
+		p.next()
+		if p.tok != RBRACK && p.tok != EOF {
+			index[1] = p.parseExpr()
+		}
+	}
 	rbrack := p.expect(RBRACK)
 
-	return &IndexExpr{X: x, Lbrack: lbrack, Index: index, Rbrack: rbrack}
+	if slice {
+		return &SliceExpr{X: x, Lbrack: lbrack, Low: index[0], High: index[1], Rbrack: rbrack}
+	}
+	return &IndexExpr{X: x, Lbrack: lbrack, Index: index[0], Rbrack: rbrack}
 }
 
 func (p *parser) parseCall(fun Expr) *CallExpr {
@@ -314,9 +429,10 @@ func (p *parser) parseCall(fun Expr) *CallExpr {
 	var list []Expr
 	for p.tok != RPAREN && p.tok != EOF {
 		list = append(list, p.parseExpr())
-		if p.tok != RPAREN {
-			p.expect(COMMA)
+		if p.tok != COMMA {
+			break
 		}
+		p.next()
 	}
 	rparen := p.expect(RPAREN)
 
@@ -334,8 +450,9 @@ L:
 			if p.tok == IDENT {
 				x = p.parseSelector(x)
 			} else {
-				p.errorExpected(p.pos, "selector")
-				p.next()
+				pos := p.pos
+				p.errorExpected(pos, "selector")
+				x = &BadExpr{From: x.Pos(), To: pos}
 			}
 		case LBRACK:
 			x = p.parseIndex(x)
@@ -433,7 +550,7 @@ func (p *parser) parseLetStmt() Stmt {
 	let := p.expect(LET)
 
 	list := p.parseVarDeclList()
-	p.expect(SEMICOLON)
+	p.expectSemi()
 	return &LetStmt{
 		Let:  let,
 		List: list,
@@ -509,25 +626,38 @@ func (p *parser) parseForStmt() Stmt {
 func (p *parser) parseReturnStmt() Stmt {
 	pos := p.expect(RETURN)
 	var result Expr
-	if p.tok != SEMICOLON {
+	if p.tok != SEMICOLON && p.tok != RBRACE && p.tok != EOF {
 		result = p.parseExpr()
 	}
-	p.expect(SEMICOLON)
+	p.expectSemi()
 	return &ReturnStmt{
 		Return: pos,
 		Result: result,
 	}
 }
 
+func (p *parser) parseYieldStmt() Stmt {
+	pos := p.expect(YIELD)
+	var value Expr
+	if p.tok != SEMICOLON && p.tok != RBRACE && p.tok != EOF {
+		value = p.parseExpr()
+	}
+	p.expectSemi()
+	return &YieldStmt{
+		Yield: pos,
+		Value: value,
+	}
+}
+
 func (p *parser) parseStmt() Stmt {
 	switch p.tok {
 	case LBRACE:
 		return p.parseBodyStmt()
 	case LET:
 		return p.parseLetStmt()
-	case IDENT, LBRACK: // FIXME: array literals as lhs
+	case IDENT, NUMBER, STRING, BOOL, NULL, LPAREN, LBRACK, FUNCTION, ADD, SUB, NOT: // FIXME: array literals as lhs
 		s := p.parseSimpleStmt()
-		p.expect(SEMICOLON)
+		p.expectSemi()
 		return s
 	case IF:
 		return p.parseIfStmt()
@@ -535,10 +665,13 @@ func (p *parser) parseStmt() Stmt {
 		return p.parseForStmt()
 	case RETURN:
 		return p.parseReturnStmt()
+	case YIELD:
+		return p.parseYieldStmt()
 	default:
 		pos := p.pos
 		p.errorExpected(pos, "statement")
-		p.next()
+		p.next() // make progress
+		p.advance(stmtStart)
 		return &BadStmt{From: pos, To: p.pos}
 	}
 }
@@ -550,3 +683,23 @@ func (p *parser) parseStmtList() (list []Stmt) {
 
 	return
 }
+
+func (p *parser) parseScript() *Script {
+	stmtList := p.parseStmtList()
+	for p.tok != EOF {
+		// an unbalanced "}"
+		pos := p.pos
+		p.errorExpected(pos, "statement")
+		p.next()
+		stmtList = append(stmtList, &BadStmt{From: pos, To: p.pos})
+		stmtList = append(stmtList, p.parseStmtList()...)
+	}
+	eof := p.pos
+
+	return &Script{
+		FileStart: Pos(p.file.Base()),
+		StmtList:  stmtList,
+		FileEnd:   eof,
+		Comments:  p.comments,
+	}
+}
@@ -1,6 +1,8 @@
 package syntax
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +47,14 @@ func TestParseExpr(t *testing.T) {
 		t.Errorf("ParseExpr(%q): got error %s", src, err)
 	}
 
+	// slice
+	src = `a[1:2]+a[:2]+a[1:]+a[:]`
+	if x, err := ParseExpr(src); err != nil {
+		t.Errorf("ParseExpr(%q): got error %s", src, err)
+	} else if _, ok := x.(*BinaryExpr).Y.(*SliceExpr); !ok {
+		t.Errorf("ParseExpr(%q): got %T, want *SliceExpr", src, x.(*BinaryExpr).Y)
+	}
+
 	// array and map
 	src = `[]+[1]+[1,2]+({})+({x:1})+({x:1,["y"+"z"]:2})`
 	if _, err := ParseExpr(src); err != nil {
@@ -56,4 +66,130 @@ func TestParseExpr(t *testing.T) {
 	if _, err := ParseExpr(src); err != nil {
 		t.Errorf("ParseExpr(%q): got error %s", src, err)
 	}
+
+	// generator function
+	src = `function* (n) { yield n; yield }`
+	if x, err := ParseExpr(src); err != nil {
+		t.Errorf("ParseExpr(%q): got error %s", src, err)
+	} else if f := x.(*FunctionLit); !f.Star.IsValid() || len(f.Body.StmtList) != 2 {
+		t.Errorf("ParseExpr(%q): got %#v, want a generator with 2 statements", src, f)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	src := `let x = 1; if (x) { x = x + 1; } x`
+	f, err := ParseFile(NewFileSet(), "test.gates", src)
+	if err != nil {
+		t.Errorf("ParseFile(%q): got error %s", src, err)
+	} else if len(f.StmtList) != 3 {
+		t.Errorf("ParseFile(%q): got %d statements, want 3", src, len(f.StmtList))
+	}
+
+	src = `let x = 1; }`
+	if _, err := ParseFile(NewFileSet(), "test.gates", src); err == nil {
+		t.Errorf("ParseFile(%q): got no error", src)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const src = `let a = ;
+let b = 1
+f(a b);
+x = [1, 2;
+if (a { b = 2; }
+}
+let c = (1) => 2;
+let d = a.;
+y = 3;
+`
+	expected := []string{
+		"errors.gates:1:9: expected operand, found ';'",
+		"errors.gates:3:1: expected ';', found f",
+		"errors.gates:4:10: expected ']', found ';'",
+		"errors.gates:5:7: expected ')', found '{'",
+		"errors.gates:6:1: expected statement, found '}'",
+		"errors.gates:7:10: expected parameter name",
+		"errors.gates:8:11: expected selector, found ';'",
+	}
+
+	f, err := Config{ErrorLimit: -1}.ParseFile(NewFileSet(), "errors.gates", src)
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("got error %v, want an ErrorList", err)
+	}
+	var got []string
+	for _, e := range list {
+		got = append(got, e.Error())
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got errors\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// the partial tree has all the statements
+	if f == nil {
+		t.Fatal("got no syntax tree")
+	}
+	var kinds []string
+	for _, s := range f.StmtList {
+		kinds = append(kinds, fmt.Sprintf("%T", s))
+	}
+	want := "*syntax.LetStmt *syntax.LetStmt *syntax.ExprStmt *syntax.AssignStmt *syntax.IfStmt *syntax.BadStmt *syntax.LetStmt *syntax.LetStmt *syntax.AssignStmt"
+	if strings.Join(kinds, " ") != want {
+		t.Errorf("got statements %s, want %s", strings.Join(kinds, " "), want)
+	}
+	bad := 0
+	Inspect(f, func(n Node) bool {
+		if _, ok := n.(*BadExpr); ok {
+			bad++
+		}
+		return true
+	})
+	if bad != 2 {
+		t.Errorf("got %d bad expressions, want 2", bad)
+	}
+
+	// the parser stops at the error limit
+	f, err = Config{ErrorLimit: 3}.ParseFile(NewFileSet(), "errors.gates", src)
+	if list := err.(ErrorList); f != nil || len(list) != 3 || list[2].Error() != expected[2] {
+		t.Errorf("got %v, %v with a limit of 3 errors", f, err)
+	}
+	_, err = ParseFile(NewFileSet(), "errors.gates", strings.Repeat("x = ;\n", 20))
+	if list := err.(ErrorList); len(list) != DefaultErrorLimit {
+		t.Errorf("got %d errors, want %d", len(list), DefaultErrorLimit)
+	}
+}
+
+func TestCommentMap(t *testing.T) {
+	const src = `// leading
+let a = 1; // trailing
+if (a) {
+  // inside
+  a = 2;
+}
+// last
+`
+	fset := NewFileSet()
+	s, err := ParseFileMode(fset, "comments.gates", src, ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Comments) != 4 {
+		t.Fatalf("got %d comment groups, want 4", len(s.Comments))
+	}
+	cmap := NewCommentMap(fset, s, s.Comments)
+	let, ifStmt := s.StmtList[0], s.StmtList[1].(*IfStmt)
+	assign := ifStmt.Consequent.(*BodyStmt).StmtList[0]
+	for n, want := range map[Node]string{
+		let:    "leading\ntrailing\n",
+		assign: "inside\n",
+		s:      "last\n",
+	} {
+		got := ""
+		for _, g := range cmap[n] {
+			got += g.Text()
+		}
+		if got != want {
+			t.Errorf("comments of %T: got %q, want %q", n, got, want)
+		}
+	}
 }
@@ -0,0 +1,573 @@
+// Package printer implements printing of AST nodes in the canonical
+// format of gates source code.
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lujjjh/gates/syntax"
+)
+
+const indentation = "  "
+
+// infinity is a position after any comment.
+const infinity = syntax.Pos(1 << 30)
+
+type printer struct {
+	fset *syntax.FileSet
+	buf  bytes.Buffer
+
+	indent int
+
+	// continued is set while the operands of a binary expression that
+	// spans several lines are printed, which are indented once.
+	continued bool
+
+	// comments to be interspersed with the nodes, in source order
+	comments []*syntax.Comment
+	cindex   int
+
+	// pending is the number of line breaks to be written before the next
+	// output; a blank line is kept if there is one in the source.
+	pending int
+
+	// lastLine is the source line of the last token or comment printed.
+	lastLine int
+
+	// bol is set at the beginning of a line, after the indentation.
+	bol bool
+
+	// blank is set after a comment that is followed by more output on its
+	// line; the blank is written before that output, unless it turns out
+	// to start a new line or to be a closing token.
+	blank bool
+}
+
+// printError is raised by the printer for nodes that cannot be printed.
+type printError struct {
+	err error
+}
+
+func (p *printer) line(pos syntax.Pos) int {
+	if !pos.IsValid() {
+		return 0
+	}
+	return p.fset.Position(pos).Line
+}
+
+// newline requests a line break before the next output.
+func (p *printer) newline() {
+	if p.pending == 0 {
+		p.pending = 1
+	}
+}
+
+// flushNewlines writes the pending line breaks and the indentation. line
+// is the source line of the next output, if known.
+func (p *printer) flushNewlines(line int) {
+	n := p.pending
+	if n == 0 {
+		return
+	}
+	if line > 0 && p.lastLine > 0 && line-p.lastLine > 1 {
+		n = 2
+	}
+	p.buf.WriteString(strings.Repeat("\n", n))
+	p.buf.WriteString(strings.Repeat(indentation, p.indent))
+	p.pending = 0
+	p.bol = true
+	p.blank = false
+}
+
+// flushBlank writes the blank owed to a comment.
+func (p *printer) flushBlank() {
+	if p.blank {
+		p.buf.WriteString(" ")
+		p.blank = false
+	}
+}
+
+// flush prints the comments before pos.
+func (p *printer) flush(pos syntax.Pos) {
+	for p.cindex < len(p.comments) && p.comments[p.cindex].Pos() < pos {
+		c := p.comments[p.cindex]
+		p.cindex++
+		line := p.line(c.Pos())
+		if p.buf.Len() > 0 && line == p.lastLine && !p.bol {
+			// a comment at the end of a line
+			p.blank = false
+			if b := p.buf.Bytes(); b[len(b)-1] != ' ' {
+				p.buf.WriteString(" ")
+			}
+		} else {
+			if p.buf.Len() > 0 && !p.bol {
+				p.newline()
+			}
+			p.flushNewlines(line)
+		}
+		p.buf.WriteString(c.Text)
+		p.lastLine = p.line(c.End())
+		p.bol = false
+		if next := p.nextLine(pos); !strings.HasPrefix(c.Text, "/*") || next == 0 || next > p.lastLine {
+			p.newline()
+		} else {
+			p.blank = true
+		}
+	}
+}
+
+// nextLine returns the source line of the next comment before pos, or of
+// pos itself; it is 0 if neither is known.
+func (p *printer) nextLine(pos syntax.Pos) int {
+	if p.cindex < len(p.comments) && p.comments[p.cindex].Pos() < pos {
+		return p.line(p.comments[p.cindex].Pos())
+	}
+	if pos == infinity {
+		return 0
+	}
+	return p.line(pos)
+}
+
+// print writes s, which is the token at pos if pos is valid.
+func (p *printer) print(pos syntax.Pos, s string) {
+	line := 0
+	if pos.IsValid() {
+		p.flush(pos)
+		line = p.line(pos)
+	}
+	p.flushNewlines(line)
+	switch s {
+	case ")", "]", ",", ";":
+		p.blank = false
+	default:
+		p.flushBlank()
+	}
+	p.buf.WriteString(s)
+	p.bol = false
+	if line > 0 {
+		p.lastLine = line
+	}
+}
+
+// space writes a blank unless a line break is pending.
+func (p *printer) space() {
+	if p.pending == 0 && !p.bol {
+		p.blank = true
+		p.flushBlank()
+	}
+}
+
+// hasComments reports whether there are comments between from and to.
+func (p *printer) hasComments(from, to syntax.Pos) bool {
+	for _, c := range p.comments[p.cindex:] {
+		if c.Pos() >= to {
+			break
+		}
+		if c.Pos() > from {
+			return true
+		}
+	}
+	return false
+}
+
+// multiline reports whether the source has a line break between the
+// positions.
+func (p *printer) multiline(from, to syntax.Pos) bool {
+	return from.IsValid() && to.IsValid() && p.line(from) < p.line(to)
+}
+
+func (p *printer) script(s *syntax.Script) {
+	for _, g := range s.Comments {
+		p.comments = append(p.comments, g.List...)
+	}
+	for i, stmt := range s.StmtList {
+		if i > 0 {
+			p.newline()
+		}
+		// the value of the final expression statement is the result of
+		// the script, which is also the form of expression sources
+		_, isExpr := stmt.(*syntax.ExprStmt)
+		p.stmt(stmt, !(isExpr && i == len(s.StmtList)-1))
+	}
+	p.flush(infinity)
+	if p.buf.Len() > 0 {
+		p.buf.WriteString("\n")
+	}
+}
+
+// block prints a list of statements in braces. A body that is on a single
+// line in the source stays on a single line if oneLine is set.
+func (p *printer) block(lbrace syntax.Pos, list []syntax.Stmt, rbrace syntax.Pos, oneLine bool) {
+	p.print(lbrace, "{")
+	if len(list) == 0 && !p.hasComments(lbrace, rbrace) {
+		p.print(rbrace, "}")
+		return
+	}
+	continued := p.continued
+	p.continued = false
+	if oneLine && !p.multiline(lbrace, rbrace) && !p.hasComments(lbrace, rbrace) {
+		for _, stmt := range list {
+			p.space()
+			p.stmt(stmt, true)
+		}
+		p.space()
+		p.print(rbrace, "}")
+		p.continued = continued
+		return
+	}
+	p.indent++
+	for _, stmt := range list {
+		p.newline()
+		p.stmt(stmt, true)
+	}
+	if rbrace.IsValid() {
+		p.flush(rbrace)
+	}
+	p.indent--
+	p.newline()
+	p.print(rbrace, "}")
+	p.continued = continued
+}
+
+// body prints the body of an if or for statement.
+func (p *printer) body(s syntax.Stmt) {
+	p.space()
+	if b, ok := s.(*syntax.BodyStmt); ok {
+		p.block(b.Lbrace, b.StmtList, b.Rbrace, false)
+		return
+	}
+	p.stmt(s, true)
+}
+
+// simpleStmt prints the initializer or the update of a for statement.
+func (p *printer) simpleStmt(s syntax.Stmt) {
+	switch s := s.(type) {
+	case *syntax.ExprStmt, *syntax.AssignStmt, *syntax.LetStmt:
+		p.stmt(s, false)
+	default:
+		panic(&printError{fmt.Errorf("unexpected statement %T in for clause", s)})
+	}
+}
+
+func (p *printer) stmt(s syntax.Stmt, semi bool) {
+	switch s := s.(type) {
+	case *syntax.ExprStmt:
+		p.expr(s.X)
+	case *syntax.AssignStmt:
+		p.expr(s.Lhs)
+		p.print(syntax.NoPos, " "+s.Tok.String()+" ")
+		p.expr(s.Rhs)
+	case *syntax.LetStmt:
+		p.print(s.Let, "let ")
+		for i, x := range s.List {
+			if i > 0 {
+				p.print(syntax.NoPos, ", ")
+			}
+			p.expr(x)
+		}
+	case *syntax.BodyStmt:
+		p.block(s.Lbrace, s.StmtList, s.Rbrace, false)
+		return
+	case *syntax.IfStmt:
+		p.print(s.If, "if (")
+		p.expr(s.Test)
+		p.print(syntax.NoPos, ")")
+		p.body(s.Consequent)
+		if s.Alternate != nil {
+			p.space()
+			p.print(syntax.NoPos, "else")
+			if alt, ok := s.Alternate.(*syntax.IfStmt); ok {
+				p.space()
+				p.stmt(alt, true)
+			} else {
+				p.body(s.Alternate)
+			}
+		}
+		return
+	case *syntax.ForStmt:
+		p.print(s.For, "for (")
+		if s.Initializer != nil {
+			p.simpleStmt(s.Initializer)
+		}
+		p.print(syntax.NoPos, ";")
+		if s.Test != nil {
+			p.space()
+			p.expr(s.Test)
+		}
+		p.print(syntax.NoPos, ";")
+		if s.Update != nil {
+			p.space()
+			p.simpleStmt(s.Update)
+		}
+		p.print(syntax.NoPos, ")")
+		p.body(s.Body)
+		return
+	case *syntax.ReturnStmt:
+		p.print(s.Return, "return")
+		if s.Result != nil {
+			p.space()
+			p.expr(s.Result)
+		}
+	case *syntax.YieldStmt:
+		p.print(s.Yield, "yield")
+		if s.Value != nil {
+			p.space()
+			p.expr(s.Value)
+		}
+	default:
+		panic(&printError{fmt.Errorf("cannot print %T", s)})
+	}
+	if semi {
+		p.print(syntax.NoPos, ";")
+	}
+}
+
+// list prints the elements of an array or map literal, one per line if
+// the first element is on a line of its own in the source.
+func (p *printer) list(lbrack syntax.Pos, n int, elem func(i int) syntax.Pos, print func(i int), rbrack syntax.Pos, closing string) {
+	if n == 0 && !p.hasComments(lbrack, rbrack) {
+		p.print(rbrack, closing)
+		return
+	}
+	first := rbrack
+	if n > 0 {
+		first = elem(0)
+	}
+	if !p.multiline(lbrack, first) {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				p.print(syntax.NoPos, ",")
+			}
+			p.space()
+			print(i)
+		}
+		if rbrack.IsValid() {
+			p.flush(rbrack)
+		}
+		p.space()
+		p.print(rbrack, closing)
+		return
+	}
+	continued := p.continued
+	p.continued = false
+	p.indent++
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			p.print(syntax.NoPos, ",")
+		}
+		p.newline()
+		print(i)
+	}
+	if rbrack.IsValid() {
+		p.flush(rbrack)
+	}
+	p.indent--
+	p.newline()
+	p.print(rbrack, closing)
+	p.continued = continued
+}
+
+func (p *printer) functionLit(f *syntax.FunctionLit) {
+	params := f.ParameterList
+	if !f.Arrow.IsValid() {
+		p.print(f.Function, "function")
+		if f.Star.IsValid() {
+			p.print(f.Star, "*")
+		}
+		p.print(syntax.NoPos, " ")
+	}
+	parens := params.Lparen.IsValid() || !f.Arrow.IsValid() || len(params.List) != 1
+	if parens {
+		p.print(params.Lparen, "(")
+	}
+	for i, ident := range params.List {
+		if i > 0 {
+			p.print(syntax.NoPos, ", ")
+		}
+		p.expr(ident)
+	}
+	if parens {
+		p.print(params.Rparen, ")")
+	}
+	if f.Arrow.IsValid() {
+		p.print(f.Arrow, " =>")
+		if !f.Body.Lbrace.IsValid() && len(f.Body.StmtList) == 1 {
+			if ret, ok := f.Body.StmtList[0].(*syntax.ReturnStmt); ok && ret.Result != nil {
+				p.space()
+				p.expr(ret.Result)
+				return
+			}
+		}
+	}
+	p.space()
+	p.block(f.Body.Lbrace, f.Body.StmtList, f.Body.Rbrace, true)
+}
+
+func (p *printer) binaryExpr(x *syntax.BinaryExpr) {
+	p.expr(x.X)
+	// a line break before or after the operator is kept
+	before := p.multiline(x.X.End(), x.OpPos)
+	after := !before && p.multiline(x.OpPos, x.Y.Pos())
+	indented := false
+	if (before || after) && !p.continued {
+		p.indent++
+		p.continued = true
+		indented = true
+	}
+	if before {
+		p.newline()
+	} else {
+		p.space()
+	}
+	p.print(x.OpPos, x.Op.String())
+	if after {
+		p.newline()
+	} else {
+		p.space()
+	}
+	p.expr(x.Y)
+	if indented {
+		p.indent--
+		p.continued = false
+	}
+}
+
+func (p *printer) expr(x syntax.Expr) {
+	switch x := x.(type) {
+	case *syntax.Ident:
+		p.print(x.NamePos, x.Name)
+	case *syntax.Lit:
+		p.print(x.ValuePos, x.Value)
+	case *syntax.ArrayLit:
+		p.print(x.Lbrack, "[")
+		p.list(x.Lbrack, len(x.ElemList), func(i int) syntax.Pos {
+			return x.ElemList[i].Value.Pos()
+		}, func(i int) {
+			elem := x.ElemList[i]
+			if elem.Expanded {
+				p.print(syntax.NoPos, "...")
+			}
+			p.expr(elem.Value)
+		}, x.Rbrack, "]")
+	case *syntax.MapLit:
+		p.print(x.Lbrace, "{")
+		p.list(x.Lbrace, len(x.Entries), func(i int) syntax.Pos {
+			entry := x.Entries[i]
+			if entry.Key != nil {
+				return entry.Key.Pos()
+			}
+			return entry.Value.Pos()
+		}, func(i int) {
+			entry := x.Entries[i]
+			switch {
+			case entry.Expanded:
+				p.print(syntax.NoPos, "...")
+			case entry.Computed:
+				p.print(syntax.NoPos, "[")
+				p.expr(entry.Key)
+				p.print(syntax.NoPos, "]: ")
+			default:
+				p.expr(entry.Key)
+				p.print(syntax.NoPos, ": ")
+			}
+			p.expr(entry.Value)
+		}, x.Rbrace, "}")
+	case *syntax.FunctionLit:
+		p.functionLit(x)
+	case *syntax.UnaryExpr:
+		p.print(x.OpPos, x.Op.String())
+		p.expr(x.X)
+	case *syntax.BinaryExpr:
+		p.binaryExpr(x)
+	case *syntax.ParenExpr:
+		p.print(x.Lparen, "(")
+		p.expr(x.X)
+		p.print(x.Rparen, ")")
+	case *syntax.SelectorExpr:
+		p.expr(x.X)
+		p.print(syntax.NoPos, ".")
+		p.expr(x.Sel)
+	case *syntax.IndexExpr:
+		p.expr(x.X)
+		p.print(x.Lbrack, "[")
+		p.expr(x.Index)
+		p.print(x.Rbrack, "]")
+	case *syntax.SliceExpr:
+		p.expr(x.X)
+		p.print(x.Lbrack, "[")
+		if x.Low != nil {
+			p.expr(x.Low)
+		}
+		p.print(syntax.NoPos, ":")
+		if x.High != nil {
+			p.expr(x.High)
+		}
+		p.print(x.Rbrack, "]")
+	case *syntax.CallExpr:
+		p.expr(x.Fun)
+		p.print(x.Lparen, "(")
+		for i, arg := range x.Args {
+			if i > 0 {
+				p.print(syntax.NoPos, ", ")
+			}
+			p.expr(arg)
+		}
+		p.print(x.Rparen, ")")
+	case *syntax.VarDeclExpr:
+		p.print(x.NamePos, x.Name)
+		if x.Initializer != nil {
+			p.print(syntax.NoPos, " = ")
+			p.expr(x.Initializer)
+		}
+	default:
+		panic(&printError{fmt.Errorf("cannot print %T", x)})
+	}
+}
+
+// Fprint "pretty-prints" node to output in the canonical format. If node
+// is a *syntax.Script, its comments are printed as well; they must have
+// been collected with syntax.ParseComments. The positions of the nodes are
+// used to keep blank lines and the line breaks in literals and binary
+// expressions of the source.
+func Fprint(output io.Writer, fset *syntax.FileSet, node syntax.Node) (err error) {
+	p := &printer{fset: fset}
+	defer func() {
+		if x := recover(); x != nil {
+			e, ok := x.(*printError)
+			if !ok {
+				panic(x)
+			}
+			err = e.err
+		}
+	}()
+	switch n := node.(type) {
+	case *syntax.Script:
+		p.script(n)
+	case syntax.Expr:
+		p.expr(n)
+	case syntax.Stmt:
+		p.stmt(n, true)
+	default:
+		return fmt.Errorf("printer: unsupported node type %T", node)
+	}
+	_, err = output.Write(p.buf.Bytes())
+	return err
+}
+
+// Source formats src, the source of a script or an expression, in the
+// canonical format. The comments of src are kept.
+func Source(filename string, src []byte) ([]byte, error) {
+	fset := syntax.NewFileSet()
+	s, err := syntax.ParseFileMode(fset, filename, string(src), syntax.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := Fprint(&buf, fset, s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
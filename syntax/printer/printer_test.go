@@ -0,0 +1,126 @@
+package printer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lujjjh/gates/syntax"
+)
+
+var formatTests = []struct {
+	src, expected string
+}{
+	{`1+2*  3`, "1 + 2 * 3\n"},
+	{`[1,2,...xs]`, "[ 1, 2, ...xs ]\n"},
+	{`({a:1,"b":2,[c]:3,...d})`, "({ a: 1, \"b\": 2, [c]: 3, ...d })\n"},
+	{`[]`, "[]\n"},
+	{"x=>x*2", "x => x * 2\n"},
+	{"(x)=>{return x;}", "(x) => { return x; }\n"},
+	{"function*(a,b){yield a;yield;}", "function* (a, b) { yield a; yield; }\n"},
+	{"let a=1,b;a=a+1;a", "let a = 1, b;\na = a + 1;\na\n"},
+	{"for(;i<3;){i=i+1}", "for (; i < 3;) {\n  i = i + 1;\n}\n"},
+	{"if(a)b;else if(c){d}else{}", "if (a) b; else if (c) {\n  d;\n} else {}\n"},
+	{"x // trailing", "x // trailing\n"},
+	{"// leading\n\n\n\nx;\n// last", "// leading\n\nx\n// last\n"},
+	{"[\n1, // one\n2]", "[\n  1, // one\n  2\n]\n"},
+	{"a\n&& b\n&& c", "a\n  && b\n  && c\n"},
+	{"f(function () {\n// nothing\n})", "f(function () {\n  // nothing\n})\n"},
+	{"/* a\n * b\n */\nx=1+/* c */2; /* d */\ny", "/* a\n * b\n */\nx = 1 + /* c */ 2; /* d */\ny\n"},
+	{"if (a) { /* empty */ } else { b(); }", "if (a) { /* empty */\n} else {\n  b();\n}\n"},
+	{"f = function (x /* param */ ) {};", "f = function (x /* param */) {};\n"},
+	{"f(a, b /* b */ )", "f(a, b /* b */)\n"},
+	{"[1 /* one */ ]", "[ 1 /* one */ ]\n"},
+}
+
+// commentTests are sources whose comments are placed unusually.
+var commentTests = []string{
+	"if (a) { /* empty */ } else { b(); }",
+	"if (a) { /* one */ /* two */ }",
+	"f(/* none */)",
+	"x = /* a */ /* b */ 1;",
+	"let a = 1; /* a */ /* b */\nlet b = 2;",
+	"[\n1 /* one */,\n2 /* two */\n]",
+	"for (;;) { /* forever */ break; }",
+}
+
+func TestIdempotent(t *testing.T) {
+	srcs := commentTests
+	for _, test := range formatTests {
+		srcs = append(srcs, test.src)
+	}
+	for _, src := range srcs {
+		res, err := Source("test.gates", []byte(src))
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		again, err := Source("test.gates", res)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if string(again) != string(res) {
+			t.Errorf("%q: formatting is not idempotent: got %q, then %q", src, res, again)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	for _, test := range formatTests {
+		res, err := Source("test.gates", []byte(test.src))
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if string(res) != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.src, res, test.expected)
+		}
+	}
+}
+
+func comments(t *testing.T, filename string, src []byte) []string {
+	s, err := syntax.ParseFileMode(syntax.NewFileSet(), filename, string(src), syntax.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	for _, g := range s.Comments {
+		for _, c := range g.List {
+			list = append(list, c.Text)
+		}
+	}
+	return list
+}
+
+func TestExamples(t *testing.T) {
+	filenames, err := filepath.Glob("../../examples/*.gates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := Source(filename, src)
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		// formatting is idempotent
+		again, err := Source(filename, res)
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if string(again) != string(res) {
+			t.Errorf("%s: formatting is not idempotent:\n%s", filename, again)
+		}
+		expected := strings.Join(comments(t, filename, src), "\n")
+		if got := strings.Join(comments(t, filename, res), "\n"); got != expected {
+			t.Errorf("%s: comments are not preserved:\n%s\nexpected:\n%s", filename, got, expected)
+		}
+	}
+}
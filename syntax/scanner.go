@@ -19,6 +19,7 @@ type Scanner struct {
 	file *File
 	src  []byte
 	err  ErrorHandler
+	mode Mode
 
 	// scanning state
 	ch         rune
@@ -68,8 +69,22 @@ func (s *Scanner) next() {
 	}
 }
 
-// Init prepares the scanner s to tokenize the text src.
+// A Mode value is a set of flags (or 0). They control scanner behavior.
+type Mode uint
+
+const (
+	ScanComments Mode = 1 << iota // return comments as COMMENT tokens
+)
+
+// Init prepares the scanner s to tokenize the text src. Comments are
+// skipped.
 func (s *Scanner) Init(file *File, src []byte, err ErrorHandler) {
+	s.InitMode(file, src, err, 0)
+}
+
+// InitMode is like Init but the mode controls the tokens returned, e.g.
+// comments are returned if mode has ScanComments set.
+func (s *Scanner) InitMode(file *File, src []byte, err ErrorHandler, mode Mode) {
 	// Explicitly initialize all fields since a scanner may be reused.
 	if file.Size() != len(src) {
 		panic(fmt.Sprintf("file size (%d) does not match src len (%d)", file.Size(), len(src)))
@@ -77,6 +92,7 @@ func (s *Scanner) Init(file *File, src []byte, err ErrorHandler) {
 	s.file = file
 	s.src = src
 	s.err = err
+	s.mode = mode
 
 	s.ch = ' '
 	s.offset = 0
@@ -162,78 +178,174 @@ func digitVal(ch rune) int {
 	return 16 // larger than any legal digit val
 }
 
-func (s *Scanner) scanMantissa(base int) {
-	for digitVal(s.ch) < base {
-		s.next()
+func lower(ch rune) rune     { return ('a' - 'A') | ch } // returns lower-case ch iff ch is ASCII letter
+func isDecimal(ch rune) bool { return '0' <= ch && ch <= '9' }
+func isHex(ch rune) bool     { return '0' <= ch && ch <= '9' || 'a' <= lower(ch) && lower(ch) <= 'f' }
+
+// digits accepts the sequence { digit | '_' } starting at s.ch. If base <=
+// 10, digits accepts any decimal digit but records the offset of the first
+// digit >= base in *invalid, if *invalid < 0. digits returns a bitset
+// describing whether the sequence contained digits (bit 0 is set) or
+// separators '_' (bit 1 is set).
+func (s *Scanner) digits(base int, invalid *int) (digsep int) {
+	if base <= 10 {
+		max := rune('0' + base)
+		for isDecimal(s.ch) || s.ch == '_' {
+			ds := 1
+			if s.ch == '_' {
+				ds = 2
+			} else if s.ch >= max && *invalid < 0 {
+				*invalid = s.offset
+			}
+			digsep |= ds
+			s.next()
+		}
+	} else {
+		for isHex(s.ch) || s.ch == '_' {
+			ds := 1
+			if s.ch == '_' {
+				ds = 2
+			}
+			digsep |= ds
+			s.next()
+		}
 	}
+	return
 }
 
+func litname(prefix rune) string {
+	switch prefix {
+	case 'x':
+		return "hexadecimal number"
+	case 'o', '0':
+		return "octal number"
+	case 'b':
+		return "binary number"
+	}
+	return "decimal number"
+}
+
+// scanNumber scans an integer, a floating-point number or a decimal
+// number with the suffix 'd'. Integers may have the prefixes 0x, 0o and
+// 0b; a leading 0 alone denotes an octal integer, too. Digits may be
+// separated by '_'.
 func (s *Scanner) scanNumber(seenDecimalPoint bool) string {
-	// digitVal(s.ch) < 10
 	offs := s.offset
+	base := 10        // number base
+	prefix := rune(0) // one of 0 (decimal), '0' (0-octal), 'x', 'o', or 'b'
+	digsep := 0       // bit 0: digit present, bit 1: '_' present
+	invalid := -1     // offset of invalid digit in literal, or < 0
+	isFloat := false
 
 	if seenDecimalPoint {
-		offs--
-		s.scanMantissa(10)
-		goto exponent
+		offs-- // the '.' is already consumed
+		isFloat = true
+		digsep |= s.digits(10, &invalid)
+	} else {
+		// integer part
+		if s.ch == '0' {
+			s.next()
+			switch lower(s.ch) {
+			case 'x':
+				s.next()
+				base, prefix = 16, 'x'
+			case 'o':
+				s.next()
+				base, prefix = 8, 'o'
+			case 'b':
+				s.next()
+				base, prefix = 2, 'b'
+			default:
+				base, prefix = 8, '0'
+				digsep = 1 // leading 0
+			}
+		}
+		digsep |= s.digits(base, &invalid)
+		if digsep&1 == 0 {
+			s.error(offs, "illegal "+litname(prefix))
+		}
+
+		// fractional part
+		if s.ch == '.' && (prefix == 0 || prefix == '0') {
+			isFloat = true
+			s.next()
+			digsep |= s.digits(10, &invalid)
+		} else if s.ch == '.' && (prefix == 'o' || prefix == 'b') {
+			s.error(s.offset, "illegal radix point in "+litname(prefix))
+		}
 	}
 
-	if s.ch == '0' {
-		// int or float
-		offs := s.offset
+	// exponent
+	if (prefix == 0 || prefix == '0') && lower(s.ch) == 'e' {
+		isFloat = true
 		s.next()
-		if s.ch == 'x' || s.ch == 'X' {
-			// hexadecimal int
+		if s.ch == '-' || s.ch == '+' {
 			s.next()
-			s.scanMantissa(16)
-			if s.offset-offs <= 2 {
-				// only scanned "0x" or "0X"
-				s.error(offs, "illegal hexadecimal number")
-			}
-		} else {
-			// octal int or float
-			seenDecimalDigit := false
-			s.scanMantissa(8)
-			if s.ch == '8' || s.ch == '9' {
-				// illegal octal int or float
-				seenDecimalDigit = true
-				s.scanMantissa(10)
-			}
-			if s.ch == '.' || s.ch == 'e' || s.ch == 'E' {
-				goto fraction
-			}
-			// octal int
-			if seenDecimalDigit {
-				s.error(offs, "illegal octal number")
-			}
 		}
-		goto exit
+		ds := s.digits(10, nil)
+		digsep |= ds
+		if ds&1 == 0 {
+			s.error(s.offset, "illegal floating-point exponent")
+		}
 	}
 
-	// decimal int or float
-	s.scanMantissa(10)
+	// a legacy 0-octal literal with an 8 or a 9, such as 09, is decimal
+	if !isFloat && invalid >= 0 && prefix != '0' {
+		s.error(invalid, fmt.Sprintf("illegal digit %q in %s", s.src[invalid], litname(prefix)))
+	}
 
-fraction:
-	if s.ch == '.' {
+	if s.ch == 'd' && (prefix == 0 || prefix == '0') {
+		// decimal suffix
 		s.next()
-		s.scanMantissa(10)
 	}
 
-exponent:
-	if s.ch == 'e' || s.ch == 'E' {
-		s.next()
-		if s.ch == '-' || s.ch == '+' {
-			s.next()
+	lit := string(s.src[offs:s.offset])
+	if digsep&2 != 0 {
+		if i := invalidSep(lit); i >= 0 {
+			s.error(offs+i, "'_' must separate successive digits")
+		}
+	}
+	return lit
+}
+
+// invalidSep returns the index of the first invalid separator in x, or -1.
+func invalidSep(x string) int {
+	x1 := ' ' // prefix char, we only care if it's 'x'
+	d := '.'  // digit, one of '_', '0' (a digit), or '.' (anything else)
+	i := 0
+
+	// a prefix counts as a digit
+	if len(x) >= 2 && x[0] == '0' {
+		x1 = lower(rune(x[1]))
+		if x1 == 'x' || x1 == 'o' || x1 == 'b' {
+			d = '0'
+			i = 2
 		}
-		if digitVal(s.ch) < 10 {
-			s.scanMantissa(10)
-		} else {
-			s.error(offs, "illegal floating-point exponent")
+	}
+
+	// mantissa and exponent
+	for ; i < len(x); i++ {
+		p := d // previous digit
+		d = rune(x[i])
+		switch {
+		case d == '_':
+			if p != '0' {
+				return i
+			}
+		case isDecimal(d) || x1 == 'x' && isHex(d):
+			d = '0'
+		default:
+			if p == '_' {
+				return i - 1
+			}
+			d = '.'
 		}
 	}
+	if d == '_' {
+		return len(x) - 1
+	}
 
-exit:
-	return string(s.src[offs:s.offset])
+	return -1
 }
 
 // scanEscape parses an escape sequence where rune is the accepted
@@ -256,6 +368,9 @@ func (s *Scanner) scanEscape(quote rune) bool {
 		n, base, max = 2, 16, 255
 	case 'u':
 		s.next()
+		if s.ch == '{' {
+			return s.scanCodePoint(offs)
+		}
 		n, base, max = 4, 16, unicode.MaxRune
 	case 'U':
 		s.next()
@@ -293,8 +408,43 @@ func (s *Scanner) scanEscape(quote rune) bool {
 	return true
 }
 
+// scanCodePoint parses the rest of a \u{...} escape sequence of 1 to 6
+// hexadecimal digits, where offs is the offset of the 'u'.
+func (s *Scanner) scanCodePoint(offs int) bool {
+	// '{' not yet consumed
+	s.next()
+	var x uint32
+	n := 0
+	for s.ch != '}' {
+		d := uint32(digitVal(s.ch))
+		if d >= 16 || n == 6 {
+			msg := fmt.Sprintf("illegal character %#U in escape sequence", s.ch)
+			if s.ch < 0 {
+				msg = "escape sequence not terminated"
+			}
+			s.error(s.offset, msg)
+			return false
+		}
+		x = x*16 + d
+		n++
+		s.next()
+	}
+	if n == 0 {
+		s.error(s.offset, "escape sequence has no digits")
+		return false
+	}
+	s.next()
+
+	if x > unicode.MaxRune || 0xD800 <= x && x < 0xE000 {
+		s.error(offs, "escape sequence is invalid Unicode code point")
+		return false
+	}
+
+	return true
+}
+
 func (s *Scanner) scanString(quote rune) string {
-	// '"' opening already consumed
+	// opening quote already consumed
 	offs := s.offset - 1
 
 	for {
@@ -315,7 +465,59 @@ func (s *Scanner) scanString(quote rune) string {
 	return string(s.src[offs:s.offset])
 }
 
-// Scan scans tokens.
+func (s *Scanner) scanRawString() string {
+	// '`' opening already consumed
+	offs := s.offset - 1
+
+	for {
+		ch := s.ch
+		if ch < 0 {
+			s.error(offs, "raw string literal not terminated")
+			break
+		}
+		s.next()
+		if ch == '`' {
+			break
+		}
+	}
+
+	return string(s.src[offs:s.offset])
+}
+
+func (s *Scanner) scanComment() string {
+	// initial '/' already consumed; s.ch == '/' || s.ch == '*'
+	offs := s.offset - 1
+
+	if s.ch == '*' {
+		/*-style comment */
+		s.next()
+		for s.ch >= 0 {
+			ch := s.ch
+			s.next()
+			if ch == '*' && s.ch == '/' {
+				s.next()
+				return string(s.src[offs:s.offset])
+			}
+		}
+		s.error(offs, "comment not terminated")
+		return string(s.src[offs:s.offset])
+	}
+
+	//-style comment
+	for s.ch != '\n' && s.ch != -1 {
+		s.next()
+	}
+	lit := s.src[offs:s.offset]
+	// strip a trailing carriage return
+	if n := len(lit); n > 0 && lit[n-1] == '\r' {
+		lit = lit[:n-1]
+	}
+	return string(lit)
+}
+
+// Scan scans tokens. If the scanner is in ScanComments mode, a comment is
+// returned as a COMMENT token whose literal is the comment text including
+// the comment markers "//" or "/*" and "*/".
 func (s *Scanner) Scan() (pos Pos, tok Token, lit string) {
 AGAIN:
 	s.skipWhitespace()
@@ -344,6 +546,8 @@ AGAIN:
 			tok = FOR
 		case "return":
 			tok = RETURN
+		case "yield":
+			tok = YIELD
 		}
 	case '0' <= ch && ch <= '9':
 		tok = NUMBER
@@ -353,9 +557,12 @@ AGAIN:
 		switch ch {
 		case -1:
 			tok = EOF
-		case '"':
+		case '"', '\'':
 			tok = STRING
 			lit = s.scanString(ch)
+		case '`':
+			tok = STRING
+			lit = s.scanRawString()
 		case ':':
 			tok = COLON
 		case '.':
@@ -397,12 +604,14 @@ AGAIN:
 		case '*':
 			tok = MUL
 		case '/':
-			if s.ch == '/' { // single-line comment?
-				s.next()
-				for s.ch != '\n' && s.ch != -1 {
-					s.next()
+			if s.ch == '/' || s.ch == '*' { // comment?
+				comment := s.scanComment()
+				if s.mode&ScanComments == 0 {
+					goto AGAIN
 				}
-				goto AGAIN
+				tok = COMMENT
+				lit = comment
+				break
 			}
 			tok = QUO
 		case '%':
@@ -1,6 +1,7 @@
 package syntax
 
 import (
+	"fmt"
 	"path/filepath"
 	"testing"
 )
@@ -27,8 +28,28 @@ var scanTokens = [...]elt{
 	{NUMBER, "1e+100"},
 	{NUMBER, "1e-100"},
 	{NUMBER, "2.71828e-1000"},
+	{NUMBER, "19.99d"},
+	{NUMBER, "0d"},
+	{NUMBER, "0b1010"},
+	{NUMBER, "0B_1"},
+	{NUMBER, "0o17"},
+	{NUMBER, "0O_7"},
+	{NUMBER, "0x_CAFE_babe"},
+	{NUMBER, "1_000_000"},
+	{NUMBER, "0_1"},
+	{NUMBER, "012"},
+	{NUMBER, "09"},
+	{NUMBER, "0129"},
+	{NUMBER, "1_0.2_5e1_0"},
+	{NUMBER, "1_999.99d"},
 	{STRING, `"foobar"`},
 	{STRING, `"foobar\n\0123\x0020"`},
+	{STRING, `"\u{0}\u{1F600}\u{10FFFF}"`},
+	{STRING, `'foobar'`},
+	{STRING, `'it\'s "quoted"\u{e9}'`},
+	{STRING, "``"},
+	{STRING, "`foobar`"},
+	{STRING, "`foo\n\\bar\"'\n`"},
 
 	// Operators and delimiters
 	{ADD, "+"},
@@ -182,3 +203,97 @@ func TestScan(t *testing.T) {
 		t.Errorf("found %d errors", s.ErrorCount)
 	}
 }
+
+func TestScanComments(t *testing.T) {
+	src := "a // one\r\n//\n/ b /* two\n */c/**/ // three"
+	for _, test := range []struct {
+		mode Mode
+		want []elt
+	}{
+		{0, []elt{{IDENT, "a"}, {QUO, ""}, {IDENT, "b"}, {IDENT, "c"}, {EOF, ""}}},
+		{ScanComments, []elt{{IDENT, "a"}, {COMMENT, "// one"}, {COMMENT, "//"}, {QUO, ""}, {IDENT, "b"}, {COMMENT, "/* two\n */"}, {IDENT, "c"}, {COMMENT, "/**/"}, {COMMENT, "// three"}, {EOF, ""}}},
+	} {
+		var s Scanner
+		s.InitMode(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, test.mode)
+		for _, e := range test.want {
+			_, tok, lit := s.Scan()
+			if tok != e.tok || lit != e.lit {
+				t.Errorf("mode %d: got %s %q, expected %s %q", test.mode, tok, lit, e.tok, e.lit)
+			}
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	for _, test := range []struct {
+		src string
+		tok Token
+		lit string
+		err string
+		col int // of the error
+	}{
+		{"0x", NUMBER, "0x", "illegal hexadecimal number", 1},
+		{"0b", NUMBER, "0b", "illegal binary number", 1},
+		{"0o", NUMBER, "0o", "illegal octal number", 1},
+		{"0b1012", NUMBER, "0b1012", "illegal digit '2' in binary number", 6},
+		{"0o178", NUMBER, "0o178", "illegal digit '8' in octal number", 5},
+		{"0b1.0", NUMBER, "0b1", "illegal radix point in binary number", 4},
+		{"1e+", NUMBER, "1e+", "illegal floating-point exponent", 4},
+		{"1__0", NUMBER, "1__0", "'_' must separate successive digits", 3},
+		{"1_", NUMBER, "1_", "'_' must separate successive digits", 2},
+		{"1_.5", NUMBER, "1_.5", "'_' must separate successive digits", 2},
+		{"1.5_d", NUMBER, "1.5_d", "'_' must separate successive digits", 4},
+		{"0x1_", NUMBER, "0x1_", "'_' must separate successive digits", 4},
+		{"1e_5", NUMBER, "1e_5", "'_' must separate successive digits", 3},
+		{"1e+5_", NUMBER, "1e+5_", "'_' must separate successive digits", 5},
+		{`"abc`, STRING, `"abc`, "string literal not terminated", 1},
+		{"'abc\n'", STRING, "'abc", "string literal not terminated", 1},
+		{"`abc", STRING, "`abc", "raw string literal not terminated", 1},
+		{`"\q"`, STRING, `"\q"`, "unknown escape sequence", 3},
+		{`'\"'`, STRING, `'\"'`, "unknown escape sequence", 3},
+		{`"\u{}"`, STRING, `"\u{}"`, "escape sequence has no digits", 5},
+		{`"\u{12g}"`, STRING, `"\u{12g}"`, "illegal character U+0067 'g' in escape sequence", 7},
+		{`"\u{1234567}"`, STRING, `"\u{1234567}"`, "illegal character U+0037 '7' in escape sequence", 11},
+		{`"\u{110000}"`, STRING, `"\u{110000}"`, "escape sequence is invalid Unicode code point", 3},
+		{`"\u{D800}"`, STRING, `"\u{D800}"`, "escape sequence is invalid Unicode code point", 3},
+		{"a /* b", IDENT, "a", "comment not terminated", 3},
+	} {
+		var errs []string
+		eh := func(pos Position, msg string) {
+			errs = append(errs, fmt.Sprintf("%d: %s", pos.Column, msg))
+		}
+		var s Scanner
+		s.Init(fset.AddFile("", fset.Base(), len(test.src)), []byte(test.src), eh)
+		_, tok, lit := s.Scan()
+		if tok != test.tok || lit != test.lit {
+			t.Errorf("%q: got %s %q, expected %s %q", test.src, tok, lit, test.tok, test.lit)
+		}
+		for tok != EOF {
+			_, tok, _ = s.Scan()
+		}
+		expected := fmt.Sprintf("%d: %s", test.col, test.err)
+		if len(errs) == 0 || errs[0] != expected {
+			t.Errorf("%q: got errors %q, expected %q", test.src, errs, expected)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	for lit, expected := range map[string]string{
+		`"a\tb"`:            "a\tb",
+		`'it\'s'`:           "it's",
+		`'"'`:               `"`,
+		`"\u{1F600}\u00e9"`: "\U0001F600\u00e9",
+		"`a\\n\r\nb`":       "a\\n\nb",
+	} {
+		s, err := Unquote(lit)
+		if err != nil || s != expected {
+			t.Errorf("Unquote(%q) = %q, %v; expected %q", lit, s, err, expected)
+		}
+	}
+	for _, lit := range []string{``, `"`, `"a'`, `'\"'`, `"\u{}"`, `"\u{110000}"`, "`a`b`"} {
+		if _, err := Unquote(lit); err == nil {
+			t.Errorf("Unquote(%q): got no error", lit)
+		}
+	}
+}
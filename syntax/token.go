@@ -10,6 +10,7 @@ const (
 	// Special tokens
 	ILLEGAL Token = iota
 	EOF
+	COMMENT
 
 	literalBeg
 	// Identifiers and basic type literals
@@ -25,6 +26,7 @@ const (
 	ELSE     // else
 	FOR      // for
 	RETURN   // return
+	YIELD    // yield
 	literalEnd
 
 	operatorBeg
@@ -75,7 +77,8 @@ const (
 var tokens = [...]string{
 	ILLEGAL: "ILLEGAL",
 
-	EOF: "EOF",
+	EOF:     "EOF",
+	COMMENT: "COMMENT",
 
 	IDENT:    "IDENT",
 	NUMBER:   "NUMBER",
@@ -88,6 +91,7 @@ var tokens = [...]string{
 	ELSE:     "ELSE",
 	FOR:      "FOR",
 	RETURN:   "RETURN",
+	YIELD:    "YIELD",
 
 	ADD: "+",
 	SUB: "-",
@@ -0,0 +1,175 @@
+package syntax
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+func walkExprList(v Visitor, list []Expr) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+func walkStmtList(v Visitor, list []Stmt) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+// Walk traverses an AST in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor
+// w for each of the non-nil children of node, followed by a call of
+// w.Visit(nil).
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	// walk children
+	// (the order of the cases matches the order
+	// of the corresponding node types in nodes.go)
+	switch n := node.(type) {
+	// Expressions
+	case *Ident, *Lit, *BadExpr:
+		// nothing to do
+
+	case *ArrayLit:
+		for _, elem := range n.ElemList {
+			Walk(v, elem.Value)
+		}
+
+	case *MapLit:
+		for _, entry := range n.Entries {
+			if entry.Key != nil {
+				Walk(v, entry.Key)
+			}
+			Walk(v, entry.Value)
+		}
+
+	case *FunctionLit:
+		Walk(v, n.ParameterList)
+		Walk(v, n.Body)
+
+	case *UnaryExpr:
+		Walk(v, n.X)
+
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+
+	case *ParenExpr:
+		Walk(v, n.X)
+
+	case *SelectorExpr:
+		Walk(v, n.X)
+		Walk(v, n.Sel)
+
+	case *IndexExpr:
+		Walk(v, n.X)
+		Walk(v, n.Index)
+
+	case *SliceExpr:
+		Walk(v, n.X)
+		if n.Low != nil {
+			Walk(v, n.Low)
+		}
+		if n.High != nil {
+			Walk(v, n.High)
+		}
+
+	case *CallExpr:
+		Walk(v, n.Fun)
+		walkExprList(v, n.Args)
+
+	case *VarDeclExpr:
+		if n.Initializer != nil {
+			Walk(v, n.Initializer)
+		}
+
+	// Statements
+	case *AssignStmt:
+		Walk(v, n.Lhs)
+		Walk(v, n.Rhs)
+
+	case *ExprStmt:
+		Walk(v, n.X)
+
+	case *BodyStmt:
+		walkStmtList(v, n.StmtList)
+
+	case *LetStmt:
+		walkExprList(v, n.List)
+
+	case *IfStmt:
+		Walk(v, n.Test)
+		Walk(v, n.Consequent)
+		if n.Alternate != nil {
+			Walk(v, n.Alternate)
+		}
+
+	case *ForStmt:
+		if n.Initializer != nil {
+			Walk(v, n.Initializer)
+		}
+		if n.Test != nil {
+			Walk(v, n.Test)
+		}
+		if n.Update != nil {
+			Walk(v, n.Update)
+		}
+		Walk(v, n.Body)
+
+	case *ReturnStmt:
+		if n.Result != nil {
+			Walk(v, n.Result)
+		}
+
+	case *YieldStmt:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+
+	case *BadStmt:
+		// nothing to do
+
+	// Other nodes
+	case *Script:
+		walkStmtList(v, n.StmtList)
+
+	case *ParameterList:
+		for _, ident := range n.List {
+			Walk(v, ident)
+		}
+
+	case *FunctionBody:
+		walkStmtList(v, n.StmtList)
+
+	default:
+		panic(fmt.Sprintf("syntax.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a
+// call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
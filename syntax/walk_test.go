@@ -0,0 +1,91 @@
+package syntax
+
+import (
+	"fmt"
+	"testing"
+)
+
+const walkSrc = `let xs = [1, ...ys], m = {a: "b", ...n};
+let f = function* (a, b) { yield a[1:]; yield; };
+let g = x => x.y(-1) * 2;
+for (let i = 0; i < 3; i = i + 1) {
+  if (!(i == 1)) { return; } else { xs[i] = () => null; }
+}
+`
+
+func TestInspect(t *testing.T) {
+	fset := NewFileSet()
+	s, err := ParseFile(fset, "walk.gates", walkSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := fset.File(s.FileStart).Base()
+	text := func(n Node) string {
+		return walkSrc[int(n.Pos())-base : int(n.End())-base]
+	}
+
+	var stack []Node
+	got := make(map[string]bool)
+	Inspect(s, func(n Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		if !n.Pos().IsValid() || n.End() < n.Pos() {
+			t.Errorf("%T: invalid range %d-%d", n, n.Pos(), n.End())
+		} else if len(stack) > 0 {
+			parent := stack[len(stack)-1]
+			if n.Pos() < parent.Pos() || n.End() > parent.End() {
+				t.Errorf("%T %q is not within %T %q", n, text(n), parent, text(parent))
+			}
+		}
+		got[fmt.Sprintf("%T %s", n, text(n))] = true
+		stack = append(stack, n)
+		return true
+	})
+	if len(stack) != 0 {
+		t.Errorf("unbalanced Visit(nil) calls: %d", len(stack))
+	}
+
+	for _, want := range []string{
+		`*syntax.ArrayLit [1, ...ys]`,
+		`*syntax.MapLit {a: "b", ...n}`,
+		`*syntax.Lit "b"`,
+		`*syntax.VarDeclExpr m = {a: "b", ...n}`,
+		`*syntax.LetStmt let xs = [1, ...ys], m = {a: "b", ...n}`,
+		`*syntax.FunctionLit function* (a, b) { yield a[1:]; yield; }`,
+		`*syntax.ParameterList (a, b)`,
+		`*syntax.SliceExpr a[1:]`,
+		`*syntax.YieldStmt yield`,
+		`*syntax.FunctionLit x => x.y(-1) * 2`,
+		`*syntax.ParameterList x`,
+		`*syntax.FunctionBody x.y(-1) * 2`,
+		`*syntax.CallExpr x.y(-1)`,
+		`*syntax.SelectorExpr x.y`,
+		`*syntax.UnaryExpr -1`,
+		`*syntax.AssignStmt i = i + 1`,
+		`*syntax.UnaryExpr !(i == 1)`,
+		`*syntax.ParenExpr (i == 1)`,
+		`*syntax.ReturnStmt return`,
+		`*syntax.IndexExpr xs[i]`,
+		`*syntax.FunctionLit () => null`,
+		`*syntax.IfStmt if (!(i == 1)) { return; } else { xs[i] = () => null; }`,
+	} {
+		if !got[want] {
+			t.Errorf("node %s not visited", want)
+		}
+	}
+
+	// returning false prunes the children
+	n := 0
+	Inspect(s, func(node Node) bool {
+		if node != nil {
+			n++
+		}
+		_, isStmt := node.(Stmt)
+		return !isStmt
+	})
+	if n != 1+len(s.StmtList) {
+		t.Errorf("got %d nodes, want %d", n, 1+len(s.StmtList))
+	}
+}
@@ -2,6 +2,7 @@ package gates
 
 import (
 	"fmt"
+	"math/big"
 )
 
 var intCache [256]Value
@@ -81,32 +82,58 @@ func ToValue(i interface{}) Value {
 		return Float(float64(i))
 	case float64:
 		return Float(i)
+	case *big.Rat:
+		return NewDecimal(i)
 	case map[string]Value:
 		return Map(i)
 	case []Value:
 		return NewArray(i)
+	case map[string]interface{}:
+		m := make(Map, len(i))
+		for k, v := range i {
+			m[k] = ToValue(v)
+		}
+		return m
+	case []interface{}:
+		values := make([]Value, len(i))
+		for j, v := range i {
+			values[j] = ToValue(v)
+		}
+		return NewArray(values)
 	default:
 		return Ref{i}
 	}
 }
 
 func GetIterable(v Value) (Iterable, bool) {
-	iter, ok := unref(v).(Iterable)
-	return iter, ok
+	return getIterable(nil, v)
 }
 
 func GetIterator(v Value) (Iterator, bool) {
-	iterable, ok := GetIterable(v)
+	return getIterator(nil, v)
+}
+
+func getIterable(r *Runtime, v Value) (Iterable, bool) {
+	switch b := unref(v).(type) {
+	case Iterable:
+		return b, true
+	case interface {
+		Keyer
+		Getter
+	}:
+		return keyerIterable{r: r, base: b}, true
+	}
+	return nil, false
+}
+
+func getIterator(r *Runtime, v Value) (Iterator, bool) {
+	iterable, ok := getIterable(r, v)
 	if !ok {
 		return nil, false
 	}
 	return iterable.Iterator(), true
 }
 
-type typer interface {
-	Type() string
-}
-
 // Type returns the type tag of the given value.
 func Type(v Value) string {
 	switch {
@@ -121,7 +148,7 @@ func Type(v Value) string {
 	case v.IsString():
 		return "string"
 	}
-	if t, haveTyper := unref(v).(typer); haveTyper {
+	if t, haveTyper := unref(v).(Typer); haveTyper {
 		return t.Type()
 	}
 	return ""
@@ -173,7 +200,7 @@ func convertValue(r *Runtime, dst interface{}, src Value) error {
 				actual:   src,
 			}
 		}
-		it, ok := GetIterator(src)
+		it, ok := getIterator(r, src)
 		if !ok {
 			return nil, &ErrTypeMismatch{
 				expected: Map{},
@@ -211,7 +238,11 @@ func convertValue(r *Runtime, dst interface{}, src Value) error {
 	case *Callback:
 		f := src.ToFunction()
 		*dst = func(args ...Value) Value {
-			return r.Call(f, args...)
+			v, err := r.CallContext(r.Context(), f, args...)
+			if err != nil {
+				r.raise(err)
+			}
+			return v
 		}
 	case *string:
 		*dst = src.ToString()
@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"strings"
+
+	"github.com/lujjjh/gates/syntax"
 )
 
 type valueStack struct {
@@ -26,11 +29,34 @@ type ctx struct {
 	pc, bp  int
 }
 
+// ErrStackOverflow and ErrCyclesLimitExceeded are wrapped in a
+// RuntimeError when they stop a program, so they are compared with its Err,
+// or matched with errors.Is, rather than with the error returned.
 var (
 	ErrStackOverflow       = errors.New("stack overflow")
 	ErrCyclesLimitExceeded = errors.New("cycles limit exceeded")
 )
 
+// RuntimeError is returned when a program fails to run to completion. It
+// records the source position of the instruction being executed, and wraps
+// every error of a run, including ErrStackOverflow and
+// ErrCyclesLimitExceeded, which earlier versions returned as is.
+type RuntimeError struct {
+	Err  error
+	File *syntax.File
+	Pos  syntax.Pos
+}
+
+func (e *RuntimeError) Error() string {
+	if e.File != nil && e.Pos.IsValid() {
+		return fmt.Sprintf("%s at %s", e.Err, e.File.Position(e.Pos))
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error, e.g. ErrCyclesLimitExceeded.
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
 func (v *valueStack) init() {
 	v.l = v.l[:0]
 	v.sp = 0
@@ -113,8 +139,47 @@ type vm struct {
 	callStack []ctx
 	bp        int
 	program   *Program
+	globals   Value
+
+	cyclesLimit     int
+	remainingCycles int
+	depth           int
+
+	// sliced is set while an Execution runs for a limited number of
+	// cycles; the run yields once sliceRemaining drops to zero.
+	sliced         bool
+	sliceRemaining int
+
+	// async is set while an Execution runs, which suspends at calls to
+	// native functions returning a Pending.
+	async     bool
+	suspended *Pending
+
+	// generator is the generator whose body is running, if any.
+	generator *generator
+
+	decimalScale    int
+	decimalRounding RoundingMode
 
-	cyclesLimit int
+	// slots caches the values of the global slots of the running program.
+	slots slotCache
+
+	// hook is called at the start of each statement, see
+	// Runtime.SetDebugHook.
+	hook DebugHook
+
+	// profile records the stacks executing the instructions, see
+	// Runtime.StartProfile.
+	profile *profiler
+}
+
+// slotCache holds the values of global slots looked up in scope. It is
+// valid as long as the version of scope is unchanged.
+type slotCache struct {
+	globals *globalSlots
+	scope   *globalScope
+	version uint64
+	values  []Value
 }
 
 func (vm *vm) newStash() {
@@ -129,13 +194,33 @@ func (vm *vm) init() {
 	vm.callStack = nil
 }
 
+// thrown carries an error raised by Runtime.Throw to the innermost run.
+type thrown struct {
+	err error
+}
+
+// errYield is returned by run when the time slice of an Execution is used
+// up.
+var errYield = errors.New("yield")
+
+// run executes instructions until the program halts. Nested runs, started
+// by Runtime.CallContext from native functions, share the cycles budget of
+// the outermost one, which is reset by the callers of the outermost run.
+// A time-sliced run only yields at the outermost level, where no native
+// function is on the Go stack.
 func (vm *vm) run() (err error) {
+	vm.depth++
 	defer func() {
+		vm.depth--
 		r := recover()
 		if r != nil {
-			if rErr, ok := r.(error); ok {
-				if rErr == ErrStackOverflow {
-					err = rErr
+			switch r := r.(type) {
+			case *thrown:
+				err = r.err
+				return
+			case error:
+				if r == ErrStackOverflow {
+					err = r
 					return
 				}
 			}
@@ -145,24 +230,46 @@ func (vm *vm) run() (err error) {
 
 	vm.halt = false
 	ctx := vm.ctx
-	remainingCycles := vm.cyclesLimit
 
 	for !vm.halt {
+		if vm.sliced {
+			if vm.sliceRemaining <= 0 && vm.depth == 1 {
+				return errYield
+			}
+			vm.sliceRemaining--
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			if remainingCycles > 0 {
-				if remainingCycles--; remainingCycles == 0 {
+			if vm.cyclesLimit > 0 {
+				if vm.remainingCycles--; vm.remainingCycles <= 0 {
 					return ErrCyclesLimitExceeded
 				}
 			}
 		}
+		if vm.hook != nil {
+			if pos, ok := vm.program.stmtPos(vm.pc); ok {
+				vm.hook.Statement(vm.frame(pos))
+			}
+		}
+		if vm.profile != nil {
+			vm.profile.step(vm)
+		}
 		vm.program.code[vm.pc].exec(vm)
 	}
 	return nil
 }
 
+func (vm *vm) newRuntimeError(err error) *RuntimeError {
+	e := &RuntimeError{Err: err}
+	if p := vm.program; p != nil {
+		e.File = p.src
+		e.Pos = p.sourcePos(vm.pc)
+	}
+	return e
+}
+
 func (vm *vm) pushCtx() {
 	if len(vm.callStack) > 1<<10 {
 		panic(ErrStackOverflow)
@@ -192,7 +299,12 @@ type _halt struct{}
 
 var halt _halt
 
+// exec stops the program. A lazy array left as the result is materialized
+// here, while callbacks can still report errors.
 func (_halt) exec(vm *vm) {
+	if vm.stack.sp > 0 {
+		vm.stack.l[vm.stack.sp-1] = materialize(vm.stack.l[vm.stack.sp-1])
+	}
 	vm.halt = true
 	vm.pc++
 }
@@ -226,10 +338,38 @@ type _loadGlobal struct{}
 var loadGlobal _loadGlobal
 
 func (_loadGlobal) exec(vm *vm) {
-	vm.stack.Push(vm.r.global.m)
+	vm.stack.Push(vm.globals)
+	vm.pc++
+}
+
+type loadGlobalSlot int
+
+func (s loadGlobalSlot) exec(vm *vm) {
+	vm.stack.Push(vm.globalSlot(int(s)))
 	vm.pc++
 }
 
+// globalSlot returns the value of the global in slot idx of the running
+// program, which is looked up by name only the first time it is used
+// after the globals have changed.
+func (vm *vm) globalSlot(idx int) Value {
+	c := &vm.slots
+	scope := unref(vm.globals).(*globalScope)
+	globals := vm.program.globals
+	if version := scope.version(); c.globals != globals || c.scope != scope || c.version != version {
+		c.globals = globals
+		c.scope = scope
+		c.version = version
+		c.values = make([]Value, len(globals.names))
+	}
+	v := c.values[idx]
+	if v == nil {
+		v = scope.Get(vm.r, String(globals.names[idx]))
+		c.values[idx] = v
+	}
+	return v
+}
+
 type loadStack int
 
 func (l loadStack) exec(vm *vm) {
@@ -254,7 +394,7 @@ type storeStack uint32
 func (s storeStack) exec(vm *vm) {
 	idx := int(s)
 	bp := vm.bp
-	vm.stack.l[bp+idx] = vm.stack.Pop()
+	vm.stack.l[bp+idx] = materialize(vm.stack.Pop())
 	vm.pc++
 }
 
@@ -274,7 +414,7 @@ func (l loadLocal) exec(vm *vm) {
 type storeLocal uint32
 
 func (s storeLocal) exec(vm *vm) {
-	v := vm.stack.Pop()
+	v := materialize(vm.stack.Pop())
 	level := s >> 24
 	idx := uint32(s & 0x00FFFFFF)
 	stash := vm.stash
@@ -289,8 +429,11 @@ type _pop struct{}
 
 var pop _pop
 
+// exec discards the value on top of the stack. A lazy array is
+// materialized first, so that the callbacks of an expression statement
+// such as xs | map(f) run where the statement is.
 func (_pop) exec(vm *vm) {
-	vm.stack.Pop()
+	materialize(vm.stack.Pop())
 	vm.pc++
 }
 
@@ -298,7 +441,9 @@ type newArray uint
 
 func (l newArray) exec(vm *vm) {
 	values := make([]Value, l)
-	copy(values, vm.stack.PopN(int(l)))
+	for i, v := range vm.stack.PopN(int(l)) {
+		values[i] = materialize(v)
+	}
 	vm.stack.Push(NewArray(values))
 	vm.pc++
 }
@@ -308,7 +453,7 @@ var arrayPush _arrayPush
 type _arrayPush struct{}
 
 func (l _arrayPush) exec(vm *vm) {
-	value := vm.stack.Pop()
+	value := materialize(vm.stack.Pop())
 	array := vm.stack.Pop().(Array)
 	array.push(value)
 	vm.stack.Push(array)
@@ -322,7 +467,7 @@ type _arrayConcat struct{}
 func (l _arrayConcat) exec(vm *vm) {
 	array2 := vm.stack.Pop()
 	array := vm.stack.Pop().(Array)
-	iterable, ok := GetIterable(array2)
+	iterable, ok := getIterable(vm.r, array2)
 	if !ok {
 		goto End
 	}
@@ -349,7 +494,7 @@ func (l newMap) exec(vm *vm) {
 	kvs := vm.stack.PopN(ll)
 	for i := 0; i < ll; i += 2 {
 		key := kvs[i]
-		value := kvs[i+1]
+		value := materialize(kvs[i+1])
 		m[key.ToString()] = value
 	}
 	vm.stack.Push(m)
@@ -361,7 +506,7 @@ var mapSet _mapSet
 type _mapSet struct{}
 
 func (l _mapSet) exec(vm *vm) {
-	v := vm.stack.Pop()
+	v := materialize(vm.stack.Pop())
 	k := vm.stack.Pop()
 	m := vm.stack.Pop().(Map)
 	m[k.ToString()] = v
@@ -376,7 +521,7 @@ type _mapConcat struct{}
 func (l _mapConcat) exec(vm *vm) {
 	m2 := vm.stack.Pop()
 	m := vm.stack.Pop().(Map)
-	iterable, ok := GetIterable(m2)
+	iterable, ok := getIterable(vm.r, m2)
 	if !ok {
 		goto End
 	}
@@ -418,6 +563,10 @@ var newStash _newStash
 
 func (_newStash) exec(vm *vm) {
 	vm.newStash()
+	if vm.hook != nil {
+		// the names are only needed to inspect the locals
+		vm.stash.names = vm.program.stashNames[vm.pc]
+	}
 	vm.pc++
 }
 
@@ -437,7 +586,7 @@ var set _set
 func (_set) exec(vm *vm) {
 	base := vm.stack.Pop()
 	key := vm.stack.Pop()
-	value := vm.stack.Pop()
+	value := materialize(vm.stack.Pop())
 	objectSet(vm.r, base, key, value)
 	vm.pc++
 }
@@ -453,6 +602,18 @@ func (_get) exec(vm *vm) {
 	vm.pc++
 }
 
+type _slice struct{}
+
+var slice _slice
+
+func (_slice) exec(vm *vm) {
+	base := vm.stack.Pop()
+	low := vm.stack.Pop()
+	high := vm.stack.Pop()
+	vm.stack.Push(objectSlice(vm.r, base, low, high))
+	vm.pc++
+}
+
 type jmp1 int64
 
 func (j jmp1) exec(vm *vm) {
@@ -503,8 +664,16 @@ type _neg struct{}
 var neg _neg
 
 func (_neg) exec(vm *vm) {
-	n := vm.stack.Pop().ToNumber()
-	if n.IsInt() {
+	x := vm.stack.Pop()
+	if o, ok := hostOperand(x).(Negater); ok {
+		vm.stack.Push(o.Negate())
+		vm.pc++
+		return
+	}
+	n := x.ToNumber()
+	if d, ok := n.(Decimal); ok {
+		vm.stack.Push(Decimal{r: new(big.Rat).Neg(d.rat())})
+	} else if n.IsInt() {
 		vm.stack.Push(intToValue(-n.ToInt()))
 	} else {
 		vm.stack.Push(Float(-n.ToFloat()))
@@ -530,6 +699,12 @@ func (_add) exec(vm *vm) {
 	y := vm.stack.Pop()
 	x := vm.stack.Pop()
 
+	if v, ok := hostAdd(x, y); ok {
+		vm.stack.Push(v)
+		vm.pc++
+		return
+	}
+
 	switch {
 	case x.IsString() || y.IsString():
 		xStr, yStr := x.ToString(), y.ToString()
@@ -537,6 +712,10 @@ func (_add) exec(vm *vm) {
 	case x.IsInt() && y.IsInt():
 		vm.stack.Push(intToValue(x.ToInt() + y.ToInt()))
 	default:
+		if xd, yd, ok := decimalOperands(x, y); ok {
+			vm.stack.Push(Decimal{r: new(big.Rat).Add(xd, yd)})
+			break
+		}
 		vm.stack.Push(Float(x.ToFloat() + y.ToFloat()))
 	}
 
@@ -551,10 +730,20 @@ func (_sub) exec(vm *vm) {
 	y := vm.stack.Pop()
 	x := vm.stack.Pop()
 
+	if v, ok := hostSub(x, y); ok {
+		vm.stack.Push(v)
+		vm.pc++
+		return
+	}
+
 	switch {
 	case x.IsInt() && y.IsInt():
 		vm.stack.Push(intToValue(x.ToInt() - y.ToInt()))
 	default:
+		if xd, yd, ok := decimalOperands(x, y); ok {
+			vm.stack.Push(Decimal{r: new(big.Rat).Sub(xd, yd)})
+			break
+		}
 		vm.stack.Push(Float(x.ToFloat() - y.ToFloat()))
 	}
 
@@ -569,6 +758,12 @@ func (_mul) exec(vm *vm) {
 	y := vm.stack.Pop()
 	x := vm.stack.Pop()
 
+	if v, ok := hostMul(x, y); ok {
+		vm.stack.Push(v)
+		vm.pc++
+		return
+	}
+
 	switch {
 	case x.IsInt() && y.IsInt():
 		xI, yI := x.ToInt(), y.ToInt()
@@ -581,6 +776,10 @@ func (_mul) exec(vm *vm) {
 		}
 		vm.stack.Push(intToValue(x.ToInt() * y.ToInt()))
 	default:
+		if xd, yd, ok := decimalOperands(x, y); ok {
+			vm.stack.Push(Decimal{r: new(big.Rat).Mul(xd, yd)})
+			break
+		}
 		vm.stack.Push(Float(x.ToFloat() * y.ToFloat()))
 	}
 
@@ -592,10 +791,23 @@ type _div struct{}
 var div _div
 
 func (_div) exec(vm *vm) {
-	y := vm.stack.Pop().ToFloat()
-	x := vm.stack.Pop().ToFloat()
+	y := vm.stack.Pop()
+	x := vm.stack.Pop()
+
+	if v, ok := hostDiv(x, y); ok {
+		vm.stack.Push(v)
+		vm.pc++
+		return
+	}
+
+	if xd, yd, ok := decimalOperands(x, y); ok && yd.Sign() != 0 {
+		q := new(big.Rat).Quo(xd, yd)
+		vm.stack.Push(Decimal{r: roundDecimal(q, vm.decimalScale, vm.decimalRounding)})
+		vm.pc++
+		return
+	}
 
-	vm.stack.Push(Float(x / y))
+	vm.stack.Push(Float(x.ToFloat() / y.ToFloat()))
 
 	vm.pc++
 }
@@ -617,6 +829,12 @@ func (_mod) exec(vm *vm) {
 		}
 	}
 
+	if xd, yd, ok := decimalOperands(x, y); ok && yd.Sign() != 0 {
+		vm.stack.Push(Decimal{r: remDecimal(xd, yd)})
+		vm.pc++
+		return
+	}
+
 	vm.stack.Push(Float(math.Mod(x.ToFloat(), y.ToFloat())))
 	vm.pc++
 }
@@ -661,7 +879,7 @@ var eq _eq
 func (_eq) exec(vm *vm) {
 	y := vm.stack.Pop()
 	x := vm.stack.Pop()
-	vm.stack.Push(Bool(x.Equals(y)))
+	vm.stack.Push(Bool(equals(x, y)))
 	vm.pc++
 }
 
@@ -672,11 +890,15 @@ var neq _neq
 func (_neq) exec(vm *vm) {
 	y := vm.stack.Pop()
 	x := vm.stack.Pop()
-	vm.stack.Push(Bool(!x.Equals(y)))
+	vm.stack.Push(Bool(!equals(x, y)))
 	vm.pc++
 }
 
 func less(x, y Value) Value {
+	if c, ok := hostCompare(x, y); ok {
+		return Bool(c < 0)
+	}
+
 	switch {
 	case x.IsString() && y.IsString():
 		xs, ys := x.ToString(), y.ToString()
@@ -685,6 +907,10 @@ func less(x, y Value) Value {
 		return Bool(x.ToInt() < y.ToInt())
 	}
 
+	if xd, yd, ok := decimalOperands(x, y); ok {
+		return Bool(xd.Cmp(yd) < 0)
+	}
+
 	nx := x.ToFloat()
 	ny := y.ToFloat()
 
@@ -767,11 +993,30 @@ func (_call) exec(vm *vm) {
 	switch f := fun.(type) {
 	case *nativeFunction:
 		argc := int(vm.stack.Pop().ToInt())
-		args := vm.stack.PopN(argc)
+		// the arguments stay on the stack during the call, so that the
+		// script functions it calls back cannot overwrite them
+		args := vm.stack.l[vm.stack.sp-argc : vm.stack.sp]
+		if !f.streams {
+			materializeAll(args)
+		}
 		fc := &functionCall{vm: vm, args: args}
-		vm.stack.Push(f.fun(fc))
+		v := f.fun(fc)
+		vm.stack.sp -= argc
+		if p, ok := v.(*Pending); ok {
+			vm.await(p)
+		} else {
+			vm.stack.Push(v)
+		}
 		vm.pc++
 	case *literalFunction:
+		if f.program.generator {
+			argc := int(vm.stack.Pop().ToInt())
+			args := vm.stack.PopN(argc)
+			materializeAll(args)
+			vm.stack.Push(NewSequence(newGenerator(vm, f, args)))
+			vm.pc++
+			return
+		}
 		vm.pc++
 		vm.pushCtx()
 		vm.bp = vm.stack.sp
@@ -786,6 +1031,22 @@ func (_call) exec(vm *vm) {
 	}
 }
 
+// await pushes the result of p. An Execution is suspended at the
+// outermost level until p is settled; elsewhere the run waits for it.
+func (vm *vm) await(p *Pending) {
+	if vm.async && vm.depth == 1 {
+		vm.stack.Push(p)
+		vm.suspended = p
+		vm.halt = true
+		return
+	}
+	v, err := p.wait(vm.ctx)
+	if err != nil {
+		panic(&thrown{err: err})
+	}
+	vm.stack.Push(v)
+}
+
 type _ret struct{}
 
 var ret _ret